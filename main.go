@@ -1,14 +1,24 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 
 	"github.com/Jason-CKY/telegram-reminderbot/pkg/core"
 	"github.com/Jason-CKY/telegram-reminderbot/pkg/handler"
+	"github.com/Jason-CKY/telegram-reminderbot/pkg/schemas"
 	"github.com/Jason-CKY/telegram-reminderbot/pkg/utils"
+	"github.com/google/uuid"
 	"github.com/joho/godotenv"
 	log "github.com/sirupsen/logrus"
 )
@@ -24,6 +34,16 @@ func main() {
 	flag.StringVar(&utils.LogLevel, "log-level", utils.LookupEnvOrString("LOG_LEVEL", utils.LogLevel), "Logging level for the server")
 	flag.StringVar(&utils.DirectusHost, "directus-host", utils.LookupEnvOrString("DIRECTUS_HOST", utils.DirectusHost), "Hostname for directus server")
 	flag.StringVar(&utils.DirectusToken, "directus-token", utils.LookupEnvOrString("DIRECTUS_TOKEN", utils.DirectusToken), "Access token for directus")
+	flag.StringVar(&utils.StoreBackend, "store", utils.LookupEnvOrString("STORE", utils.StoreBackend), "Storage backend for reminders (directus, postgres, sqlite, memory)")
+	flag.StringVar(&utils.DatabaseUrl, "database-url", utils.LookupEnvOrString("DATABASE_URL", utils.DatabaseUrl), "Connection string for the postgres store")
+	flag.StringVar(&utils.SQLitePath, "sqlite-path", utils.LookupEnvOrString("SQLITE_PATH", utils.SQLitePath), "Database file for the sqlite store")
+	flag.StringVar(&utils.InstanceId, "instance-id", utils.LookupEnvOrString("INSTANCE_ID", utils.InstanceId), "Unique name of this bot instance, used to claim due reminders when several instances share a postgres store (defaults to the hostname)")
+	flag.StringVar(&utils.EncryptionKey, "encryption-key", utils.LookupEnvOrString("ENCRYPTION_KEY", utils.EncryptionKey), "Base64 encoded 32 byte key to encrypt reminder texts and file ids with (optional)")
+	flag.StringVar(&utils.PreviousEncryptionKeys, "previous-encryption-keys", utils.LookupEnvOrString("PREVIOUS_ENCRYPTION_KEYS", utils.PreviousEncryptionKeys), "Comma separated keys that stored values may still be encrypted with")
+	flag.IntVar(&utils.ChatSettingsCacheTtl, "chat-settings-cache-ttl", utils.LookupEnvOrInt("CHAT_SETTINGS_CACHE_TTL", utils.ChatSettingsCacheTtl), "Seconds to cache chat settings for, 0 to always read them from the store")
+	flag.BoolVar(&utils.DirectusSubscribe, "directus-subscribe", utils.LookupEnvOrBool("DIRECTUS_SUBSCRIBE", utils.DirectusSubscribe), "Follow changes made in Directus through its WebSocket API (directus store only)")
+	flag.StringVar(&utils.HealthAddr, "health-addr", utils.LookupEnvOrString("HEALTH_ADDR", utils.HealthAddr), "Address to serve /healthz on, empty to turn it off")
+	rekey := flag.Bool("rekey", false, "Encrypt every stored value with --encryption-key, then exit")
 	flag.StringVar(&utils.BotToken, "bot-token", utils.LookupEnvOrString("TELEGRAM_BOT_TOKEN", utils.BotToken), "Bot token for telegram bot")
 
 	flag.Parse()
@@ -37,7 +57,66 @@ func main() {
 	logLevel, _ := log.ParseLevel(utils.LogLevel)
 	log.SetLevel(logLevel)
 
-	log.Infof("connecting to directus at: %v", utils.DirectusHost)
+	if utils.InstanceId != "" && utils.StoreBackend == utils.STORE_DIRECTUS {
+		// Directus cannot claim reminders atomically, so two instances could both send a reminder
+		panic("--instance-id is for running several instances, which needs the postgres store")
+	}
+	if utils.InstanceId == "" {
+		hostname, _ := os.Hostname()
+		// the suffix keeps two processes on the same host apart
+		utils.InstanceId = fmt.Sprintf("%v-%v", hostname, uuid.New().String()[:8])
+	}
+	log.Infof("running as instance %v", utils.InstanceId)
+
+	// cancelled on SIGINT or SIGTERM, e.g. when the container is stopped during a deploy
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	store, err := schemas.NewStore(utils.StoreBackend)
+	if err != nil {
+		panic(err)
+	}
+	schemas.Store = store
+	if utils.StoreBackend == utils.STORE_DIRECTUS {
+		log.Infof("connecting to directus at: %v", utils.DirectusHost)
+	} else {
+		log.Infof("using %v store", utils.StoreBackend)
+	}
+	err = schemas.Store.Migrate(ctx)
+	if err != nil {
+		panic(fmt.Errorf("error migrating %v store: %v", utils.StoreBackend, err))
+	}
+	if utils.EncryptionKey != "" {
+		var previousKeys []string
+		if utils.PreviousEncryptionKeys != "" {
+			previousKeys = strings.Split(utils.PreviousEncryptionKeys, ",")
+		}
+		keyring, err := schemas.NewKeyring(utils.EncryptionKey, previousKeys)
+		if err != nil {
+			panic(err)
+		}
+		encryptedStore := schemas.NewEncryptedStore(schemas.Store, keyring)
+		schemas.Store = encryptedStore
+		log.Info("encrypting reminder texts and file ids")
+		if *rekey {
+			rewritten, err := encryptedStore.Rekey(ctx)
+			if err != nil {
+				panic(fmt.Errorf("error re-keying %v store: %v", utils.StoreBackend, err))
+			}
+			log.Infof("re-encrypted %v rows with the current key", rewritten)
+			return
+		}
+	} else if *rekey {
+		panic("--rekey needs an --encryption-key")
+	}
+	if utils.ChatSettingsCacheTtl > 0 {
+		cachedStore := schemas.NewCachedStore(schemas.Store, time.Duration(utils.ChatSettingsCacheTtl)*time.Second)
+		schemas.Store = cachedStore
+		schemas.Events.Subscribe(cachedStore.HandleChangeEvent)
+	}
+	// the cache is invalidated before the scheduler looks at changed reminders
+	schemas.Events.Subscribe(core.HandleChangeEvent)
+	schemas.Store = schemas.NewPublishingStore(schemas.Store, schemas.Events)
 
 	bot, err := tgbotapi.NewBotAPI(utils.BotToken)
 	if err != nil {
@@ -45,18 +124,61 @@ func main() {
 	}
 	bot.Debug = utils.LogLevel == "debug"
 	log.Infof("Authorized on account %s", bot.Self.UserName)
+	core.Outbox = core.NewDispatcher(bot)
 
 	if err != nil {
 		panic(err)
 	}
 
-	go core.ScheduledReminderTrigger(bot)
+	var wg sync.WaitGroup
+	wg.Add(3)
+	go func() {
+		defer wg.Done()
+		core.ScheduledReminderTrigger(ctx, bot)
+	}()
+	go func() {
+		defer wg.Done()
+		core.ScheduledDeletedReminderPurge(ctx)
+	}()
+	go func() {
+		defer wg.Done()
+		handler.PollUpdates(ctx, bot)
+	}()
+	if utils.StoreBackend == utils.STORE_DIRECTUS && utils.DirectusSubscribe {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			schemas.NewDirectusSubscriber(utils.DirectusHost, utils.DirectusToken, schemas.Events).Run(ctx)
+		}()
+	}
 
-	u := tgbotapi.NewUpdate(0)
-	u.Timeout = 60
-	updates := bot.GetUpdatesChan(u)
-	for update := range updates {
-		handler.HandleUpdate(&update, bot)
+	if utils.HealthAddr != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/healthz", core.SchedulerHealth)
+		server := &http.Server{Addr: utils.HealthAddr, Handler: mux}
+		go func() {
+			err := server.ListenAndServe()
+			if err != nil && err != http.ErrServerClosed {
+				log.Error(err)
+			}
+		}()
+		defer server.Close()
+		log.Infof("serving health checks at %v/healthz", utils.HealthAddr)
 	}
 
+	<-ctx.Done()
+	// a second signal kills the bot straight away
+	stop()
+	log.Info("shutting down, waiting for reminders and updates in flight")
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		log.Info("shut down cleanly")
+	case <-time.After(utils.SHUTDOWN_TIMEOUT):
+		log.Warnf("work still in flight after %v, exiting anyway", utils.SHUTDOWN_TIMEOUT)
+	}
 }
@@ -0,0 +1,127 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"html"
+	"strings"
+	"time"
+
+	"github.com/Jason-CKY/telegram-reminderbot/pkg/schemas"
+	"github.com/Jason-CKY/telegram-reminderbot/pkg/utils"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+)
+
+// RecordAudit stores a change made by user. before and after are snapshotted as
+// JSON, pass nil when the entity did not exist before or after the change.
+func RecordAudit(ctx context.Context, chatId int64, user *tgbotapi.User, action string, entityType string, entityId string, before interface{}, after interface{}) {
+	auditEntry := schemas.AuditEntry{
+		Id:         uuid.New().String(),
+		ChatId:     chatId,
+		Action:     action,
+		EntityType: entityType,
+		EntityId:   entityId,
+		CreatedAt:  time.Now().UTC().Format(utils.DIRECTUS_DATETIME_FORMAT),
+	}
+	if user != nil {
+		auditEntry.UserId = user.ID
+		auditEntry.UserName = user.String()
+		if user.UserName != "" {
+			auditEntry.UserName = "@" + user.UserName
+		}
+	}
+	if before != nil {
+		snapshot, err := json.Marshal(before)
+		if err != nil {
+			log.Error(err)
+			return
+		}
+		auditEntry.Before = string(snapshot)
+	}
+	if after != nil {
+		snapshot, err := json.Marshal(after)
+		if err != nil {
+			log.Error(err)
+			return
+		}
+		auditEntry.After = string(snapshot)
+	}
+	err := auditEntry.Create(ctx)
+	if err != nil {
+		log.Error(err)
+	}
+}
+
+func truncateText(text string, length int) string {
+	runes := []rune(text)
+	if len(runes) <= length {
+		return text
+	}
+	return string(runes[:length]) + "…"
+}
+
+func describeAuditEntry(auditEntry schemas.AuditEntry) string {
+	switch auditEntry.EntityType {
+	case utils.AUDIT_ENTITY_REMINDER:
+		snapshot := auditEntry.After
+		if snapshot == "" {
+			snapshot = auditEntry.Before
+		}
+		var reminder schemas.Reminder
+		if err := json.Unmarshal([]byte(snapshot), &reminder); err != nil {
+			return fmt.Sprintf("%vd reminder", auditEntry.Action)
+		}
+		return fmt.Sprintf(
+			"%vd reminder \"%v\" (%v at %v)",
+			auditEntry.Action,
+			truncateText(reminder.ReminderText, utils.AUDIT_TEXT_LENGTH),
+			parseReminderFrequencyToText(reminder),
+			reminder.Time,
+		)
+	case utils.AUDIT_ENTITY_CHAT_SETTINGS:
+		var before, after schemas.ChatSettings
+		if json.Unmarshal([]byte(auditEntry.Before), &before) != nil || json.Unmarshal([]byte(auditEntry.After), &after) != nil {
+			return fmt.Sprintf("%vd chat settings", auditEntry.Action)
+		}
+		var changes []string
+		if before.Timezone != after.Timezone {
+			changes = append(changes, fmt.Sprintf("timezone from %v to %v", before.Timezone, after.Timezone))
+		}
+		if before.MissedReminderPolicy() != after.MissedReminderPolicy() {
+			changes = append(changes, fmt.Sprintf("missed reminders from %v to %v", before.MissedReminderPolicy(), after.MissedReminderPolicy()))
+		}
+		if len(changes) == 0 {
+			return fmt.Sprintf("%vd chat settings", auditEntry.Action)
+		}
+		return "changed " + strings.Join(changes, " and ")
+	default:
+		return fmt.Sprintf("%vd %v", auditEntry.Action, auditEntry.EntityType)
+	}
+}
+
+func BuildAuditLogText(auditEntries []schemas.AuditEntry, chatSettings *schemas.ChatSettings) (string, error) {
+	if len(auditEntries) == 0 {
+		return "No changes have been recorded in this chat.", nil
+	}
+	tz, err := time.LoadLocation(chatSettings.Timezone)
+	if err != nil {
+		return "", err
+	}
+	var lines []string
+	for _, auditEntry := range auditEntries {
+		createdAt, err := time.ParseInLocation(utils.DIRECTUS_DATETIME_FORMAT, auditEntry.CreatedAt, time.UTC)
+		if err != nil {
+			return "", err
+		}
+		lines = append(lines, fmt.Sprintf(
+			"<b>%v</b>\n%v %v",
+			createdAt.In(tz).Format(utils.DATE_AND_TIME_FORMAT),
+			html.EscapeString(auditEntry.UserName),
+			html.EscapeString(describeAuditEntry(auditEntry)),
+		))
+	}
+	return fmt.Sprintf("<b>Recent changes:</b>\n\n%v", strings.Join(lines, "\n\n")), nil
+}
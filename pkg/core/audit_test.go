@@ -0,0 +1,49 @@
+package core
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/Jason-CKY/telegram-reminderbot/pkg/schemas"
+	"github.com/Jason-CKY/telegram-reminderbot/pkg/utils"
+)
+
+func TestDescribeChatSettingsAuditEntry(t *testing.T) {
+	for _, test := range []struct {
+		before, after schemas.ChatSettings
+		want          string
+	}{
+		{
+			schemas.ChatSettings{Timezone: "Asia/Singapore"},
+			schemas.ChatSettings{Timezone: "Europe/London"},
+			"changed timezone from Asia/Singapore to Europe/London",
+		},
+		{
+			schemas.ChatSettings{Timezone: "Asia/Singapore"},
+			schemas.ChatSettings{Timezone: "Asia/Singapore", MissedReminders: utils.MISSED_REMINDERS_SKIP},
+			"changed missed reminders from collapse to skip",
+		},
+		{
+			schemas.ChatSettings{Timezone: "Asia/Singapore", MissedReminders: utils.MISSED_REMINDERS_LATE},
+			schemas.ChatSettings{Timezone: "UTC", MissedReminders: utils.MISSED_REMINDERS_COLLAPSE},
+			"changed timezone from Asia/Singapore to UTC and missed reminders from late to collapse",
+		},
+		{
+			schemas.ChatSettings{Timezone: "UTC"},
+			schemas.ChatSettings{Timezone: "UTC"},
+			"updated chat settings",
+		},
+	} {
+		before, _ := json.Marshal(test.before)
+		after, _ := json.Marshal(test.after)
+		got := describeAuditEntry(schemas.AuditEntry{
+			Action:     utils.AUDIT_UPDATE,
+			EntityType: utils.AUDIT_ENTITY_CHAT_SETTINGS,
+			Before:     string(before),
+			After:      string(after),
+		})
+		if got != test.want {
+			t.Errorf("described %+v -> %+v as %q, want %q", test.before, test.after, got, test.want)
+		}
+	}
+}
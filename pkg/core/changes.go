@@ -0,0 +1,191 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"sync"
+
+	"github.com/Jason-CKY/telegram-reminderbot/pkg/schemas"
+	"github.com/Jason-CKY/telegram-reminderbot/pkg/utils"
+	log "github.com/sirupsen/logrus"
+)
+
+// scheduleBasis is what a reminder's next trigger time is calculated from,
+// apart from the chat's timezone.
+type scheduleBasis struct {
+	time      string
+	frequency string
+}
+
+// knownSchedules remembers the time, frequency and chat timezone that reminders
+// were last scheduled with, as far as this process has seen them. A reminder
+// changed outside the bot is only rescheduled when one of these changed, so that
+// a next trigger time edited on its own, e.g. to snooze a reminder, is kept.
+type knownSchedules struct {
+	mu        sync.Mutex
+	reminders map[string]scheduleBasis
+	timezones map[int64]string
+}
+
+var schedulesSeen = &knownSchedules{reminders: map[string]scheduleBasis{}, timezones: map[int64]string{}}
+
+// setReminder records the basis of a reminder, and reports whether it differs from the one known before.
+func (known *knownSchedules) setReminder(reminder schemas.Reminder) bool {
+	frequency, err := json.Marshal(reminder.Frequency)
+	if err != nil {
+		log.Error(err)
+	}
+	basis := scheduleBasis{time: reminder.Time, frequency: string(frequency)}
+	known.mu.Lock()
+	defer known.mu.Unlock()
+	previous, ok := known.reminders[reminder.Id]
+	known.reminders[reminder.Id] = basis
+	return ok && previous != basis
+}
+
+// setTimezone records the timezone of a chat, and reports whether it differs from the one known before.
+func (known *knownSchedules) setTimezone(chatId int64, timezone string) bool {
+	known.mu.Lock()
+	defer known.mu.Unlock()
+	previous, ok := known.timezones[chatId]
+	known.timezones[chatId] = timezone
+	return ok && previous != timezone
+}
+
+func (known *knownSchedules) forgetReminder(id string) {
+	known.mu.Lock()
+	defer known.mu.Unlock()
+	delete(known.reminders, id)
+}
+
+// forget drops what is known of a collection, after changes to it may have been missed.
+func (known *knownSchedules) forget(collection string) {
+	known.mu.Lock()
+	defer known.mu.Unlock()
+	if collection == "reminderbot_reminder" {
+		known.reminders = map[string]scheduleBasis{}
+	} else {
+		known.timezones = map[int64]string{}
+	}
+}
+
+// HandleChangeEvent keeps the schedule in line with reminder changes. The
+// changes made by this process come from the PublishingStore with the items
+// attached, and are the only way they reach the schedule. Changes made outside
+// the bot, e.g. a reminder's time edited in the Directus admin app, only carry
+// keys and are also checked for reminders that need to be rescheduled.
+func HandleChangeEvent(ctx context.Context, event schemas.ChangeEvent) {
+	switch {
+	case event.Action == utils.CHANGE_RESYNC && event.Collection == "reminderbot_reminder":
+		schedulesSeen.forget(event.Collection)
+		reminderSchedule.Resync()
+	case event.Action == utils.CHANGE_RESYNC:
+		schedulesSeen.forget(event.Collection)
+	case event.Action == utils.CHANGE_DELETE && event.Collection == "reminderbot_reminder":
+		for _, id := range event.Keys {
+			schedulesSeen.forgetReminder(id)
+			reminderSchedule.Remove(id)
+		}
+	case event.Action == utils.CHANGE_DELETE:
+	case event.Reminders != nil:
+		// written by the bot, and scheduled when it was written
+		for _, reminder := range event.Reminders {
+			schedulesSeen.setReminder(reminder)
+			reminderSchedule.Set(reminder)
+		}
+	case event.ChatSettings != nil:
+		// the bot reschedules the chat's reminders itself when it changes the timezone
+		for _, chatSettings := range event.ChatSettings {
+			schedulesSeen.setTimezone(chatSettings.ChatId, chatSettings.Timezone)
+		}
+	case event.Collection == "reminderbot_reminder":
+		// changed outside the bot, the subscription leaves out the bot's own writes
+		for _, id := range event.Keys {
+			reminder, err := schemas.GetReminderById(ctx, id)
+			if err != nil {
+				log.Error(err)
+				continue
+			}
+			if reminder == nil {
+				schedulesSeen.forgetReminder(id)
+				reminderSchedule.Remove(id)
+				continue
+			}
+			// rescheduling publishes the reminder again
+			reminderSchedule.Set(*reminder)
+			err = RescheduleIfChanged(ctx, *reminder)
+			if err != nil {
+				log.Error(err)
+			}
+		}
+	case event.Collection == "reminderbot_chat_settings":
+		for _, key := range event.Keys {
+			chatId, err := strconv.ParseInt(key, 10, 64)
+			if err != nil {
+				log.Error(err)
+				continue
+			}
+			chatSettings, err := schemas.GetChatSettings(ctx, chatId)
+			if err != nil {
+				log.Error(err)
+				continue
+			}
+			if chatSettings == nil || !schedulesSeen.setTimezone(chatId, chatSettings.Timezone) {
+				continue
+			}
+			reminders, err := schemas.GetRemindersByChatId(ctx, chatId)
+			if err != nil {
+				log.Error(err)
+				continue
+			}
+			for _, reminder := range reminders {
+				schedulesSeen.setReminder(reminder)
+				err = recalculateNextTriggerTime(ctx, reminder, chatSettings)
+				if err != nil {
+					log.Error(err)
+				}
+			}
+		}
+	}
+}
+
+// RescheduleIfChanged recalculates the next trigger time of a reminder changed
+// outside the bot, when its time, frequency or chat timezone differ from what it
+// was last scheduled with. Otherwise its next trigger time is kept as it is,
+// including when this process has not seen the reminder before.
+func RescheduleIfChanged(ctx context.Context, reminder schemas.Reminder) error {
+	changed := schedulesSeen.setReminder(reminder)
+	if reminder.InConstruction || reminder.Frequency.Validate() != nil {
+		// invalid frequencies are parked by TriggerReminder
+		return nil
+	}
+	chatSettings, err := schemas.GetChatSettings(ctx, reminder.ChatId)
+	if err != nil || chatSettings == nil {
+		return err
+	}
+	if schedulesSeen.setTimezone(reminder.ChatId, chatSettings.Timezone) {
+		changed = true
+	}
+	if !changed {
+		return nil
+	}
+	return recalculateNextTriggerTime(ctx, reminder, chatSettings)
+}
+
+func recalculateNextTriggerTime(ctx context.Context, reminder schemas.Reminder, chatSettings *schemas.ChatSettings) error {
+	if reminder.InConstruction || reminder.Frequency.Validate() != nil {
+		return nil
+	}
+	nextTriggerTime, err := reminder.CalculateNextTriggerTime(chatSettings)
+	if errors.Is(err, schemas.ErrRecurrenceEnded) {
+		log.Warnf("reminder %v was changed to a recurrence with no occurrences left", reminder.Id)
+		return nil
+	} else if err != nil {
+		return err
+	}
+	log.Infof("reminder %v was changed outside the bot, rescheduling it from %v to %v", reminder.Id, reminder.NextTriggerTime, nextTriggerTime.Format(utils.DIRECTUS_DATETIME_FORMAT))
+	reminder.NextTriggerTime = nextTriggerTime.Format(utils.DIRECTUS_DATETIME_FORMAT)
+	return reminder.Update(ctx)
+}
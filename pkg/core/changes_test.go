@@ -0,0 +1,149 @@
+package core
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Jason-CKY/telegram-reminderbot/pkg/schemas"
+	"github.com/Jason-CKY/telegram-reminderbot/pkg/utils"
+)
+
+// setupChanges publishes the bot's writes to HandleChangeEvent, like main does,
+// and returns the store underneath, whose writes stand in for changes made
+// outside the bot.
+func setupChanges(t *testing.T) schemas.ReminderStore {
+	t.Helper()
+	setupTest(t)
+	schedule, seen := reminderSchedule, schedulesSeen
+	t.Cleanup(func() { reminderSchedule, schedulesSeen = schedule, seen })
+	reminderSchedule = NewSchedule()
+	schedulesSeen = &knownSchedules{reminders: map[string]scheduleBasis{}, timezones: map[int64]string{}}
+	outside := schemas.Store
+	bus := &schemas.EventBus{}
+	bus.Subscribe(HandleChangeEvent)
+	schemas.Store = schemas.NewPublishingStore(outside, bus)
+	err := reminderSchedule.Reconcile(context.Background(), time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return outside
+}
+
+// createScheduledReminder creates a daily 09:00 reminder through the bot, in a
+// chat in UTC created through the bot as well.
+func createScheduledReminder(t *testing.T, ctx context.Context) schemas.Reminder {
+	t.Helper()
+	chatSettings := &schemas.ChatSettings{ChatId: 1, Timezone: "UTC"}
+	err := chatSettings.Create(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reminder := schemas.Reminder{Id: "edited", ChatId: 1, ReminderText: "stretch", Time: "09:00", Frequency: schemas.NewDailyRecurrence()}
+	nextTriggerTime, err := reminder.CalculateNextTriggerTime(chatSettings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reminder.NextTriggerTime = nextTriggerTime.Format(utils.DIRECTUS_DATETIME_FORMAT)
+	err = reminder.Create(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return reminder
+}
+
+func updateOutside(t *testing.T, ctx context.Context, outside schemas.ReminderStore, reminder schemas.Reminder) {
+	t.Helper()
+	err := outside.UpdateReminder(ctx, reminder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	HandleChangeEvent(ctx, schemas.ChangeEvent{Collection: "reminderbot_reminder", Action: utils.CHANGE_UPDATE, Keys: []string{reminder.Id}})
+}
+
+func checkNextTriggerTime(t *testing.T, ctx context.Context, id string, want time.Time) {
+	t.Helper()
+	reminder, err := schemas.GetReminderById(ctx, id)
+	if err != nil || reminder == nil {
+		t.Fatalf("reminder %v is gone: %v", id, err)
+	}
+	if reminder.NextTriggerTime != want.UTC().Format(utils.DIRECTUS_DATETIME_FORMAT) {
+		t.Errorf("next trigger time is %v, want %v", reminder.NextTriggerTime, want.UTC().Format(utils.DIRECTUS_DATETIME_FORMAT))
+	}
+}
+
+func TestOutsideEditOfNextTriggerTimeIsKept(t *testing.T) {
+	outside := setupChanges(t)
+	ctx := context.Background()
+	reminder := createScheduledReminder(t, ctx)
+
+	snoozed := time.Now().UTC().Truncate(time.Second).Add(time.Minute)
+	reminder.NextTriggerTime = snoozed.Format(utils.DIRECTUS_DATETIME_FORMAT)
+	updateOutside(t, ctx, outside, reminder)
+	checkNextTriggerTime(t, ctx, reminder.Id, snoozed)
+	checkNext(t, reminderSchedule, snoozed)
+}
+
+func TestOutsideEditOfTimeReschedules(t *testing.T) {
+	outside := setupChanges(t)
+	ctx := context.Background()
+	reminder := createScheduledReminder(t, ctx)
+
+	reminder.Time = "10:30"
+	updateOutside(t, ctx, outside, reminder)
+	want, err := reminder.Frequency.Next("10:30", time.Now(), time.UTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkNextTriggerTime(t, ctx, reminder.Id, want)
+}
+
+func TestOutsideEditOfUnseenReminderIsKept(t *testing.T) {
+	outside := setupChanges(t)
+	ctx := context.Background()
+	err := schemas.ChatSettings{ChatId: 1, Timezone: "UTC"}.Create(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// not an occurrence of 09:00, but nothing is known of how it was scheduled
+	fireNow := time.Now().UTC().Truncate(time.Second)
+	reminder := schemas.Reminder{Id: "unseen", ChatId: 1, Time: "09:00", Frequency: schemas.NewDailyRecurrence(), NextTriggerTime: fireNow.Format(utils.DIRECTUS_DATETIME_FORMAT)}
+	err = outside.CreateReminder(ctx, reminder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	HandleChangeEvent(ctx, schemas.ChangeEvent{Collection: "reminderbot_reminder", Action: utils.CHANGE_CREATE, Keys: []string{reminder.Id}})
+	checkNextTriggerTime(t, ctx, reminder.Id, fireNow)
+}
+
+func TestOutsideTimezoneChangeReschedules(t *testing.T) {
+	outside := setupChanges(t)
+	ctx := context.Background()
+	reminder := createScheduledReminder(t, ctx)
+
+	// a change that leaves the timezone alone keeps the reminders as they are
+	snoozed := time.Now().UTC().Truncate(time.Second).Add(time.Minute)
+	reminder.NextTriggerTime = snoozed.Format(utils.DIRECTUS_DATETIME_FORMAT)
+	updateOutside(t, ctx, outside, reminder)
+	err := outside.UpdateChatSettings(ctx, schemas.ChatSettings{ChatId: 1, Timezone: "UTC", MissedReminders: utils.MISSED_REMINDERS_SKIP})
+	if err != nil {
+		t.Fatal(err)
+	}
+	HandleChangeEvent(ctx, schemas.ChangeEvent{Collection: "reminderbot_chat_settings", Action: utils.CHANGE_UPDATE, Keys: []string{"1"}})
+	checkNextTriggerTime(t, ctx, reminder.Id, snoozed)
+
+	err = outside.UpdateChatSettings(ctx, schemas.ChatSettings{ChatId: 1, Timezone: "Asia/Singapore"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	HandleChangeEvent(ctx, schemas.ChangeEvent{Collection: "reminderbot_chat_settings", Action: utils.CHANGE_UPDATE, Keys: []string{"1"}})
+	singapore, err := time.LoadLocation("Asia/Singapore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := reminder.Frequency.Next("09:00", time.Now(), singapore)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkNextTriggerTime(t, ctx, reminder.Id, want)
+}
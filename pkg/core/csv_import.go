@@ -0,0 +1,174 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Jason-CKY/telegram-reminderbot/pkg/schemas"
+	"github.com/Jason-CKY/telegram-reminderbot/pkg/utils"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// parseReminderCSV reads rows of text, time, frequency, date or weekday and an
+// optional chat id. Rows for another chat are only accepted from its admins.
+func parseReminderCSV(ctx context.Context, data []byte, chatSettings *schemas.ChatSettings, userId int64) (ImportPlan, error) {
+	reader := csv.NewReader(bytes.NewReader(bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))))
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	// the whole file is checked first, nothing is imported while any row is invalid
+	plan := ImportPlan{Strict: true}
+	targetChats := map[int64]*schemas.ChatSettings{chatSettings.ChatId: chatSettings}
+	targetErrors := map[int64]error{}
+	rows := 0
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			var parseError *csv.ParseError
+			if errors.As(err, &parseError) {
+				plan.Skipped = append(plan.Skipped, fmt.Sprintf("line %v: %v", parseError.Line, parseError.Err))
+				continue
+			}
+			return ImportPlan{}, err
+		}
+		line, _ := reader.FieldPos(0)
+		if rows == 0 && strings.EqualFold(strings.TrimSpace(record[0]), "text") {
+			// header row
+			continue
+		}
+		rows++
+		if rows > utils.MAX_IMPORT_REMINDERS {
+			return ImportPlan{}, fmt.Errorf("the file has more than %v rows, at most %v reminders can be imported at once", utils.MAX_IMPORT_REMINDERS, utils.MAX_IMPORT_REMINDERS)
+		}
+
+		targetChatSettings := chatSettings
+		if len(record) > 4 && strings.TrimSpace(record[4]) != "" {
+			chatId, err := strconv.ParseInt(strings.TrimSpace(record[4]), 10, 64)
+			if err != nil {
+				plan.Skipped = append(plan.Skipped, fmt.Sprintf("line %v: invalid chat id %q", line, record[4]))
+				continue
+			}
+			if _, checked := targetChats[chatId]; !checked {
+				targetChats[chatId], targetErrors[chatId] = checkImportTargetChat(ctx, chatId, userId)
+			}
+			if targetErrors[chatId] != nil {
+				plan.Skipped = append(plan.Skipped, fmt.Sprintf("line %v: %v", line, targetErrors[chatId]))
+				continue
+			}
+			targetChatSettings = targetChats[chatId]
+		}
+
+		reminder, err := parseReminderCSVRecord(record, targetChatSettings)
+		if err == nil {
+			err = checkImportedReminder(reminder, targetChatSettings.Timezone)
+		}
+		if err != nil {
+			plan.Skipped = append(plan.Skipped, fmt.Sprintf("line %v: %v", line, err))
+			continue
+		}
+		if targetChatSettings.ChatId != chatSettings.ChatId {
+			reminder.ChatId = targetChatSettings.ChatId
+		}
+		plan.Reminders = append(plan.Reminders, reminder)
+	}
+	return plan, nil
+}
+
+// checkImportTargetChat returns the settings of another chat that a user imports
+// reminders into, after checking that they administer it.
+func checkImportTargetChat(ctx context.Context, chatId int64, userId int64) (*schemas.ChatSettings, error) {
+	if chatId != userId {
+		member, err := Outbox.GetChatMember(ctx, tgbotapi.GetChatMemberConfig{
+			ChatConfigWithUser: tgbotapi.ChatConfigWithUser{ChatID: chatId, UserID: userId},
+		}, utils.PRIORITY_INTERACTIVE)
+		if err != nil {
+			return nil, fmt.Errorf("cannot check your membership of chat %v: %v", chatId, err)
+		}
+		if !member.IsAdministrator() && !member.IsCreator() {
+			return nil, fmt.Errorf("only admins of chat %v can import reminders into it", chatId)
+		}
+	}
+	targetChatSettings, err := schemas.GetChatSettings(ctx, chatId)
+	if err != nil {
+		return nil, err
+	}
+	if targetChatSettings == nil {
+		return nil, fmt.Errorf("the bot has not been used in chat %v yet, send it a command there first", chatId)
+	}
+	return targetChatSettings, nil
+}
+
+// parseReminderCSVRecord applies the checks BuildReminder makes on each answer.
+func parseReminderCSVRecord(record []string, chatSettings *schemas.ChatSettings) (schemas.Reminder, error) {
+	for len(record) < 4 {
+		record = append(record, "")
+	}
+	reminder := schemas.Reminder{
+		ReminderText: strings.TrimSpace(record[0]),
+		Time:         strings.TrimSpace(record[1]),
+	}
+	if reminder.ReminderText == "" {
+		return reminder, errors.New("reminder text is empty")
+	}
+	if !utils.IsValidTime(reminder.Time) {
+		return reminder, fmt.Errorf("invalid time %q, expected <HH>:<MM>", reminder.Time)
+	}
+	frequency := strings.TrimSpace(record[2])
+	detail := strings.TrimSpace(record[3])
+	switch strings.ToLower(frequency) {
+	case strings.ToLower(utils.REMINDER_ONCE), strings.ToLower(utils.REMINDER_YEARLY):
+		date, err := time.Parse(utils.DATE_FORMAT, strings.ReplaceAll(detail, "-", "/"))
+		if err != nil {
+			return reminder, fmt.Errorf("invalid date %q, expected YYYY/MM/DD", detail)
+		}
+		if strings.EqualFold(frequency, utils.REMINDER_ONCE) {
+			reminder.Frequency = schemas.NewOnceRecurrence(date)
+		} else {
+			reminder.Frequency = schemas.NewYearlyRecurrence(date)
+		}
+	case strings.ToLower(utils.REMINDER_DAILY):
+		if detail != "" {
+			return reminder, errors.New("daily reminders take no date or weekday")
+		}
+		reminder.Frequency = schemas.NewDailyRecurrence()
+	case strings.ToLower(utils.REMINDER_WEEKLY):
+		weekday, ok := -1, false
+		for name, day := range utils.DAY_OF_WEEK {
+			if strings.EqualFold(name, detail) {
+				weekday, ok = day, true
+			}
+		}
+		if !ok {
+			return reminder, fmt.Errorf("invalid weekday %q, expected Monday to Sunday", detail)
+		}
+		reminder.Frequency = schemas.NewWeeklyRecurrence(time.Weekday(weekday))
+	case strings.ToLower(utils.REMINDER_MONTHLY):
+		day, err := strconv.Atoi(detail)
+		if err != nil || day < 1 || day > 31 {
+			return reminder, fmt.Errorf("invalid day of month %q, expected 1-31", detail)
+		}
+		reminder.Frequency = schemas.NewMonthlyRecurrence(day)
+	case strings.ToLower(utils.REMINDER_RRULE):
+		tz, err := time.LoadLocation(chatSettings.Timezone)
+		if err != nil {
+			return reminder, err
+		}
+		reminder.Frequency, err = schemas.NewRRuleRecurrence(detail, reminder.Time, time.Now().In(tz))
+		if err != nil {
+			return reminder, err
+		}
+	default:
+		return reminder, fmt.Errorf("unknown frequency %q, expected once, daily, weekly, monthly, yearly or rrule", frequency)
+	}
+	return reminder, nil
+}
@@ -0,0 +1,218 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/Jason-CKY/telegram-reminderbot/pkg/schemas"
+	"github.com/Jason-CKY/telegram-reminderbot/pkg/utils"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+func TestParseReminderCSVRecord(t *testing.T) {
+	chatSettings := &schemas.ChatSettings{ChatId: 1, Timezone: "Asia/Singapore"}
+	for _, test := range []struct {
+		record []string
+		want   string
+	}{
+		{[]string{"pay rent", "09:00", "once", "2030/01/02"}, "Once-2030/01/02"},
+		{[]string{"pay rent", "09:00", "Once", "2030-01-02"}, "Once-2030/01/02"},
+		{[]string{"stretch", "07:30", "DAILY"}, "Daily"},
+		{[]string{"stretch", "07:30", "daily", ""}, "Daily"},
+		{[]string{"standup", "10:00", "weekly", "tuesday"}, "Weekly-2"},
+		{[]string{"standup", "10:00", "Weekly", "Sunday"}, "Weekly-0"},
+		{[]string{"invoice", "18:00", "monthly", "31"}, "Monthly-31"},
+		{[]string{"birthday", "08:00", "yearly", "1990-02-28"}, "Yearly-1990/02/28"},
+		{[]string{" padded ", " 08:00 ", " yearly ", " 1990/02/28 "}, "Yearly-1990/02/28"},
+	} {
+		reminder, err := parseReminderCSVRecord(test.record, chatSettings)
+		if err != nil {
+			t.Errorf("%q: %v", test.record, err)
+			continue
+		}
+		if reminder.Frequency.String() != test.want || reminder.ReminderText != strings.TrimSpace(test.record[0]) || reminder.Time != strings.TrimSpace(test.record[1]) {
+			t.Errorf("%q was read as %q at %v %v, want %v", test.record, reminder.ReminderText, reminder.Time, reminder.Frequency, test.want)
+		}
+	}
+
+	reminder, err := parseReminderCSVRecord([]string{"last friday", "17:00", "rrule", "FREQ=MONTHLY;BYDAY=-1FR"}, chatSettings)
+	if err != nil || reminder.Frequency.Kind != utils.REMINDER_RRULE || !strings.Contains(reminder.Frequency.RRule, "BYDAY=-1FR") {
+		t.Errorf("rrule was read as %v (%v)", reminder.Frequency, err)
+	}
+
+	for _, test := range []struct {
+		record []string
+		want   string
+	}{
+		{[]string{"", "09:00", "daily"}, "reminder text is empty"},
+		{[]string{"late", "24:00", "daily"}, `invalid time "24:00", expected <HH>:<MM>`},
+		{[]string{"late", "9am", "daily"}, `invalid time "9am", expected <HH>:<MM>`},
+		{[]string{"once", "09:00", "once", "02/01/2030"}, `invalid date "02/01/2030", expected YYYY/MM/DD`},
+		{[]string{"once", "09:00", "once"}, `invalid date "", expected YYYY/MM/DD`},
+		{[]string{"stretch", "07:30", "daily", "Monday"}, "daily reminders take no date or weekday"},
+		{[]string{"standup", "10:00", "weekly", "Mon"}, `invalid weekday "Mon", expected Monday to Sunday`},
+		{[]string{"invoice", "18:00", "monthly", "32"}, `invalid day of month "32", expected 1-31`},
+		{[]string{"invoice", "18:00", "monthly", "0"}, `invalid day of month "0", expected 1-31`},
+		{[]string{"often", "18:00", "hourly"}, `unknown frequency "hourly", expected once, daily, weekly, monthly, yearly or rrule`},
+		{[]string{"often", "18:00"}, `unknown frequency "", expected once, daily, weekly, monthly, yearly or rrule`},
+	} {
+		if _, err := parseReminderCSVRecord(test.record, chatSettings); err == nil || err.Error() != test.want {
+			t.Errorf("%q: got error %v, want %v", test.record, err, test.want)
+		}
+	}
+	if _, err := parseReminderCSVRecord([]string{"hourly", "18:00", "rrule", "FREQ=HOURLY"}, chatSettings); err == nil {
+		t.Error("an rrule more often than daily was accepted")
+	}
+}
+
+func TestParseReminderCSV(t *testing.T) {
+	setupTest(t)
+	ctx := context.Background()
+	chatSettings := &schemas.ChatSettings{ChatId: 1, Timezone: "UTC"}
+	data := "\xef\xbb\xbfText,Time,Frequency,Detail\r\n" +
+		"stretch,07:30,daily\r\n" +
+		"\"pack,\r\nthen ship\",10:00,weekly,Friday\r\n" +
+		"invoice,18:00,monthly,32\r\n" +
+		"standup,10:00,weekly,Tuesday\r\n"
+	plan, err := parseReminderCSV(ctx, []byte(data), chatSettings, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !plan.Strict {
+		t.Error("CSV import plan is not strict")
+	}
+	var got []string
+	for _, reminder := range plan.Reminders {
+		got = append(got, fmt.Sprintf("%q at %v %v", reminder.ReminderText, reminder.Time, reminder.Frequency))
+	}
+	want := []string{`"stretch" at 07:30 Daily`, `"pack,\nthen ship" at 10:00 Weekly-5`, `"standup" at 10:00 Weekly-2`}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("read %q, want %q", got, want)
+	}
+	// lines are counted from the header, the quoted text takes two
+	if strings.Join(plan.Skipped, "\n") != `line 5: invalid day of month "32", expected 1-31` {
+		t.Errorf("skipped %q", plan.Skipped)
+	}
+
+	// without a header the first row is a reminder
+	plan, err = parseReminderCSV(ctx, []byte("stretch,07:30,daily\nbad \"quote\",07:30,daily\nwalk,late,daily\n"), chatSettings, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan.Reminders) != 1 || plan.Reminders[0].ReminderText != "stretch" {
+		t.Errorf("read %+v, want the first row", plan.Reminders)
+	}
+	wantSkipped := `line 2: bare " in non-quoted-field` + "\n" + `line 3: invalid time "late", expected <HH>:<MM>`
+	if strings.Join(plan.Skipped, "\n") != wantSkipped {
+		t.Errorf("skipped %q, want %q", plan.Skipped, wantSkipped)
+	}
+
+	plan, err = parseReminderCSV(ctx, []byte("long ago,09:00,once,2001/01/02\n"), chatSettings, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan.Reminders) != 0 || len(plan.Skipped) != 1 || !strings.HasPrefix(plan.Skipped[0], "line 1: ") {
+		t.Errorf("read %+v and skipped %q, want the passed reminder skipped", plan.Reminders, plan.Skipped)
+	}
+
+	var rows strings.Builder
+	for i := 0; i <= utils.MAX_IMPORT_REMINDERS; i++ {
+		rows.WriteString("stretch,07:30,daily\n")
+	}
+	if _, err := parseReminderCSV(ctx, []byte(rows.String()), chatSettings, 1); err == nil {
+		t.Errorf("a file of %v rows was accepted", utils.MAX_IMPORT_REMINDERS+1)
+	}
+}
+
+func TestParseReminderCSVChecksOtherChats(t *testing.T) {
+	setupTest(t)
+	ctx := context.Background()
+	chatSettings := &schemas.ChatSettings{ChatId: -100, Timezone: "UTC"}
+	data := "text,time,frequency,detail,chat id\n" +
+		"here,09:00,daily,,\n" +
+		"private,09:00,daily,,5\n" +
+		"other group,09:00,daily,,-200\n" +
+		"somewhere,09:00,daily,,group\n"
+
+	plan, err := parseReminderCSV(ctx, []byte(data), chatSettings, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantSkipped := []string{
+		"line 3: the bot has not been used in chat 5 yet, send it a command there first",
+		"line 4: only admins of chat -200 can import reminders into it",
+		`line 5: invalid chat id "group"`,
+	}
+	if strings.Join(plan.Skipped, "\n") != strings.Join(wantSkipped, "\n") {
+		t.Errorf("skipped %q, want %q", plan.Skipped, wantSkipped)
+	}
+
+	err = schemas.ChatSettings{ChatId: 5, Timezone: "Asia/Singapore"}.Create(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plan, err = parseReminderCSV(ctx, []byte(data), chatSettings, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan.Reminders) != 2 || plan.Reminders[0].ChatId != 0 || plan.Reminders[1].ChatId != 5 {
+		t.Errorf("read %+v, want a reminder here and one in the private chat", plan.Reminders)
+	}
+	if len(plan.Skipped) != 2 {
+		t.Errorf("skipped %q, want the other group and the invalid chat id", plan.Skipped)
+	}
+}
+
+func TestStrictImportPlanWithSkippedEntriesIsBlocked(t *testing.T) {
+	setupTest(t)
+	ctx := context.Background()
+	chatSettings := &schemas.ChatSettings{ChatId: 1, Timezone: "UTC"}
+	err := chatSettings.Create(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plan, err := parseReminderCSV(ctx, []byte("stretch,07:30,daily\ninvoice,18:00,monthly,32\n"), chatSettings, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	text, markup := BuildImportPlanTextAndMarkup(plan, utils.IMPORT_FORMAT_CSV, 1)
+	if !strings.Contains(text, "Nothing is imported until every line is valid.") {
+		t.Errorf("text does not say the import is blocked:\n%v", text)
+	}
+	for _, row := range markup.InlineKeyboard {
+		for _, button := range row {
+			if button.Text != "Cancel" {
+				t.Errorf("offered the %q button", button.Text)
+			}
+		}
+	}
+
+	created, err := ApplyImportPlan(ctx, plan, chatSettings, &tgbotapi.User{ID: 1})
+	if err == nil || created != 0 {
+		t.Errorf("created %v reminders (%v), want the import refused", created, err)
+	}
+	reminders, err := schemas.GetRemindersByChatId(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reminders) != 0 {
+		t.Errorf("%v reminders were created", len(reminders))
+	}
+
+	// once the file is fixed the import goes ahead
+	plan, err = parseReminderCSV(ctx, []byte("stretch,07:30,daily\ninvoice,18:00,monthly,31\n"), chatSettings, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, markup = BuildImportPlanTextAndMarkup(plan, utils.IMPORT_FORMAT_CSV, 1)
+	if button := markup.InlineKeyboard[0][0]; button.Text != "Import 2 reminders" {
+		t.Errorf("first button is %q, want the import", button.Text)
+	}
+	created, err = ApplyImportPlan(ctx, plan, chatSettings, &tgbotapi.User{ID: 1})
+	if err != nil || created != 2 {
+		t.Errorf("created %v reminders (%v), want 2", created, err)
+	}
+}
@@ -0,0 +1,270 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/Jason-CKY/telegram-reminderbot/pkg/utils"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	log "github.com/sirupsen/logrus"
+)
+
+// tokenBucket allows rate requests a second on average, and bursts of up to
+// capacity requests.
+type tokenBucket struct {
+	tokens   float64
+	capacity float64
+	rate     float64
+	updated  time.Time
+	// pausedUntil is set from the retry_after of a rate limited request
+	pausedUntil time.Time
+}
+
+func newTokenBucket(rate float64, capacity float64, now time.Time) *tokenBucket {
+	return &tokenBucket{tokens: capacity, capacity: capacity, rate: rate, updated: now}
+}
+
+func (bucket *tokenBucket) refill(now time.Time) {
+	if now.After(bucket.updated) {
+		bucket.tokens = min(bucket.capacity, bucket.tokens+now.Sub(bucket.updated).Seconds()*bucket.rate)
+		bucket.updated = now
+	}
+}
+
+// readyAt returns when the bucket will have a token to take.
+func (bucket *tokenBucket) readyAt(now time.Time) time.Time {
+	bucket.refill(now)
+	ready := now
+	if bucket.tokens < 1 {
+		ready = now.Add(time.Duration((1 - bucket.tokens) / bucket.rate * float64(time.Second)))
+	}
+	if bucket.pausedUntil.After(ready) {
+		ready = bucket.pausedUntil
+	}
+	return ready
+}
+
+// outboundRequest is a request waiting for its turn in a Dispatcher.
+type outboundRequest struct {
+	chatId   int64
+	priority int
+	sequence uint64
+}
+
+func (request *outboundRequest) before(other *outboundRequest) bool {
+	if request.priority != other.priority {
+		return request.priority < other.priority
+	}
+	return request.sequence < other.sequence
+}
+
+// Dispatcher sends requests to Telegram within its rate limits: a global limit,
+// and a limit per chat that is stricter for groups. Waiting requests go out by
+// priority, then in the order they were made, skipping those whose chat has no
+// room yet. Requests that are rate limited anyway are retried after the
+// retry_after that Telegram answers with.
+type Dispatcher struct {
+	bot *tgbotapi.BotAPI
+
+	mu       sync.Mutex
+	global   *tokenBucket
+	chats    map[int64]*tokenBucket
+	waiting  []*outboundRequest
+	sequence uint64
+	// changed is closed and replaced whenever a waiting request may be able to go
+	changed chan struct{}
+}
+
+func NewDispatcher(bot *tgbotapi.BotAPI) *Dispatcher {
+	return &Dispatcher{
+		bot:     bot,
+		global:  newTokenBucket(utils.OUTBOX_GLOBAL_RATE, utils.OUTBOX_GLOBAL_BURST, time.Now()),
+		chats:   map[int64]*tokenBucket{},
+		changed: make(chan struct{}),
+	}
+}
+
+// Outbox sends every message of the bot, it is set up in main.
+var Outbox *Dispatcher
+
+// Request sends c once the rate limits allow it, and returns Telegram's
+// response like bot.Request. It gives up with ctx.Err() if ctx is done first.
+// priority is one of the utils.PRIORITY_* constants.
+func (dispatcher *Dispatcher) Request(ctx context.Context, c tgbotapi.Chattable, priority int) (*tgbotapi.APIResponse, error) {
+	dispatcher.mu.Lock()
+	dispatcher.sequence++
+	request := &outboundRequest{chatId: requestChatId(c), priority: priority, sequence: dispatcher.sequence}
+	dispatcher.mu.Unlock()
+
+	for attempt := 1; ; attempt++ {
+		err := dispatcher.wait(ctx, request)
+		if err != nil {
+			return nil, err
+		}
+		res, err := dispatcher.bot.Request(c)
+		if err == nil || res == nil || res.ErrorCode != 429 || res.Parameters == nil || res.Parameters.RetryAfter == 0 || attempt == utils.OUTBOX_MAX_ATTEMPTS {
+			return res, err
+		}
+		retryAfter := time.Duration(res.Parameters.RetryAfter) * time.Second
+		log.Warnf("telegram rate limited a request to chat %v, retrying in %v", request.chatId, retryAfter)
+		dispatcher.pause(request.chatId, retryAfter)
+	}
+}
+
+// GetChatMember is bot.GetChatMember, sent within the rate limits.
+func (dispatcher *Dispatcher) GetChatMember(ctx context.Context, config tgbotapi.GetChatMemberConfig, priority int) (tgbotapi.ChatMember, error) {
+	var member tgbotapi.ChatMember
+	res, err := dispatcher.Request(ctx, config, priority)
+	if err != nil {
+		return member, err
+	}
+	err = json.Unmarshal(res.Result, &member)
+	return member, err
+}
+
+// GetFileDirectURL is bot.GetFileDirectURL, sent within the rate limits.
+func (dispatcher *Dispatcher) GetFileDirectURL(ctx context.Context, fileId string, priority int) (string, error) {
+	var file tgbotapi.File
+	res, err := dispatcher.Request(ctx, tgbotapi.FileConfig{FileID: fileId}, priority)
+	if err != nil {
+		return "", err
+	}
+	err = json.Unmarshal(res.Result, &file)
+	if err != nil {
+		return "", err
+	}
+	return file.Link(dispatcher.bot.Token), nil
+}
+
+// wait blocks until it is request's turn, and takes its tokens.
+func (dispatcher *Dispatcher) wait(ctx context.Context, request *outboundRequest) error {
+	dispatcher.mu.Lock()
+	defer dispatcher.mu.Unlock()
+	dispatcher.waiting = append(dispatcher.waiting, request)
+	defer dispatcher.remove(request)
+
+	for {
+		now := time.Now()
+		ready := dispatcher.readyAt(request, now)
+		if !ready.After(now) && dispatcher.isNext(request, now) {
+			dispatcher.global.tokens--
+			if request.chatId != 0 {
+				dispatcher.chats[request.chatId].tokens--
+			}
+			return nil
+		}
+
+		// a request that is ready but not next is woken up by the one before it going out
+		changed := dispatcher.changed
+		timer := time.NewTimer(time.Hour)
+		if ready.After(now) {
+			timer.Reset(ready.Sub(now))
+		}
+		dispatcher.mu.Unlock()
+		select {
+		case <-ctx.Done():
+		case <-changed:
+		case <-timer.C:
+		}
+		timer.Stop()
+		dispatcher.mu.Lock()
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+	}
+}
+
+// isNext reports whether no request that goes before this one can be sent now.
+func (dispatcher *Dispatcher) isNext(request *outboundRequest, now time.Time) bool {
+	for _, other := range dispatcher.waiting {
+		if other.before(request) && !dispatcher.readyAt(other, now).After(now) {
+			return false
+		}
+	}
+	return true
+}
+
+func (dispatcher *Dispatcher) readyAt(request *outboundRequest, now time.Time) time.Time {
+	ready := dispatcher.global.readyAt(now)
+	if request.chatId != 0 {
+		if chatReady := dispatcher.chat(request.chatId, now).readyAt(now); chatReady.After(ready) {
+			ready = chatReady
+		}
+	}
+	return ready
+}
+
+func (dispatcher *Dispatcher) chat(chatId int64, now time.Time) *tokenBucket {
+	bucket, ok := dispatcher.chats[chatId]
+	if !ok {
+		// negative ids are groups and channels
+		if chatId < 0 {
+			bucket = newTokenBucket(utils.OUTBOX_GROUP_CHAT_RATE, utils.OUTBOX_GROUP_CHAT_BURST, now)
+		} else {
+			bucket = newTokenBucket(utils.OUTBOX_PRIVATE_CHAT_RATE, utils.OUTBOX_PRIVATE_CHAT_BURST, now)
+		}
+		dispatcher.chats[chatId] = bucket
+	}
+	return bucket
+}
+
+// remove takes a request off the waiting list, and forgets the chats that are
+// back to their full burst, so the map does not grow with every chat ever seen.
+func (dispatcher *Dispatcher) remove(request *outboundRequest) {
+	for i, other := range dispatcher.waiting {
+		if other == request {
+			dispatcher.waiting = append(dispatcher.waiting[:i], dispatcher.waiting[i+1:]...)
+			break
+		}
+	}
+	if len(dispatcher.waiting) == 0 {
+		now := time.Now()
+		for chatId, bucket := range dispatcher.chats {
+			bucket.refill(now)
+			if bucket.tokens >= bucket.capacity && !bucket.pausedUntil.After(now) {
+				delete(dispatcher.chats, chatId)
+			}
+		}
+	}
+	dispatcher.notify()
+}
+
+// pause holds back the requests to a chat, or every request when chatId is 0.
+func (dispatcher *Dispatcher) pause(chatId int64, duration time.Duration) {
+	dispatcher.mu.Lock()
+	defer dispatcher.mu.Unlock()
+	now := time.Now()
+	bucket := dispatcher.global
+	if chatId != 0 {
+		bucket = dispatcher.chat(chatId, now)
+	}
+	bucket.pausedUntil = now.Add(duration)
+	dispatcher.notify()
+}
+
+func (dispatcher *Dispatcher) notify() {
+	close(dispatcher.changed)
+	dispatcher.changed = make(chan struct{})
+}
+
+// requestChatId returns the chat a request sends to, or 0 for requests that
+// do not count against a chat's limit, such as answers to callback queries.
+func requestChatId(c tgbotapi.Chattable) int64 {
+	switch c := c.(type) {
+	case tgbotapi.MessageConfig:
+		return c.ChatID
+	case tgbotapi.PhotoConfig:
+		return c.ChatID
+	case tgbotapi.DocumentConfig:
+		return c.ChatID
+	case tgbotapi.EditMessageTextConfig:
+		return c.ChatID
+	case tgbotapi.EditMessageCaptionConfig:
+		return c.ChatID
+	case tgbotapi.EditMessageReplyMarkupConfig:
+		return c.ChatID
+	}
+	return 0
+}
@@ -0,0 +1,42 @@
+package core
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/Jason-CKY/telegram-reminderbot/pkg/schemas"
+	"github.com/Jason-CKY/telegram-reminderbot/pkg/utils"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+func SplitCallbackForgetMeData(callbackData string) (string, int64) {
+	x := strings.Split(callbackData, "_")
+	action := x[1]
+	userId, _ := strconv.ParseInt(x[2], 10, 64)
+	return action, userId
+}
+
+func GetCallbackForgetMeData(action string, userId int64) string {
+	return fmt.Sprintf("fm_%v_%v", action, userId)
+}
+
+func BuildForgetMeMarkup(userId int64) tgbotapi.InlineKeyboardMarkup {
+	return tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("Delete my data", GetCallbackForgetMeData(utils.CALLBACK_CONFIRM, userId)),
+			tgbotapi.NewInlineKeyboardButtonData("Cancel", GetCallbackForgetMeData(utils.CALLBACK_CANCEL, userId)),
+		),
+	)
+}
+
+func BuildErasedUserDataText(erased schemas.ErasedUserData) string {
+	return fmt.Sprintf(
+		"Your data has been deleted:\n\n- %v reminders\n- %v delivery records\n- %v audit log entries\n- %v private chat settings\n\n%v",
+		erased.Reminders,
+		erased.Deliveries,
+		erased.AuditEntries,
+		erased.ChatSettings,
+		utils.FORGET_ME_DONE_MESSAGE,
+	)
+}
@@ -0,0 +1,102 @@
+package core
+
+import (
+	"encoding/json"
+	"math/rand/v2"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/Jason-CKY/telegram-reminderbot/pkg/utils"
+	log "github.com/sirupsen/logrus"
+)
+
+// Health tracks whether the scheduler can reach the store. It is degraded from
+// the first failed attempt until an attempt succeeds again.
+type Health struct {
+	mu            sync.Mutex
+	failures      int
+	lastError     string
+	degradedSince time.Time
+	lastSuccess   time.Time
+}
+
+// HealthStatus is the JSON body served by Health.
+type HealthStatus struct {
+	Status        string     `json:"status"`
+	Failures      int        `json:"failures,omitempty"`
+	LastError     string     `json:"last_error,omitempty"`
+	DegradedSince *time.Time `json:"degraded_since,omitempty"`
+	LastSuccess   *time.Time `json:"last_success,omitempty"`
+}
+
+// SchedulerHealth is the health of ScheduledReminderTrigger.
+var SchedulerHealth = &Health{}
+
+// Fail records a failed attempt and returns how long to wait before the next
+// one: utils.SCHEDULER_MIN_BACKOFF doubled for every failure in a row, up to
+// utils.SCHEDULER_MAX_BACKOFF, and randomly shortened by up to half so that
+// several instances do not all retry at the same moment.
+func (health *Health) Fail(err error) time.Duration {
+	health.mu.Lock()
+	defer health.mu.Unlock()
+	if health.failures == 0 {
+		health.degradedSince = time.Now()
+	}
+	health.failures++
+	health.lastError = err.Error()
+
+	backoff := utils.SCHEDULER_MAX_BACKOFF
+	if health.failures < 32 {
+		backoff = min(utils.SCHEDULER_MIN_BACKOFF<<(health.failures-1), utils.SCHEDULER_MAX_BACKOFF)
+	}
+	return backoff/2 + rand.N(backoff/2+1)
+}
+
+func (health *Health) Succeed() {
+	health.mu.Lock()
+	defer health.mu.Unlock()
+	if health.failures > 0 {
+		log.Infof("store is reachable again after %v failed attempts", health.failures)
+	}
+	health.failures = 0
+	health.lastError = ""
+	health.lastSuccess = time.Now()
+}
+
+func (health *Health) Degraded() bool {
+	health.mu.Lock()
+	defer health.mu.Unlock()
+	return health.failures > 0
+}
+
+func (health *Health) Status() HealthStatus {
+	health.mu.Lock()
+	defer health.mu.Unlock()
+	status := HealthStatus{Status: utils.HEALTH_OK}
+	if !health.lastSuccess.IsZero() {
+		lastSuccess := health.lastSuccess
+		status.LastSuccess = &lastSuccess
+	}
+	if health.failures > 0 {
+		degradedSince := health.degradedSince
+		status.Status = utils.HEALTH_DEGRADED
+		status.Failures = health.failures
+		status.LastError = health.lastError
+		status.DegradedSince = &degradedSince
+	}
+	return status
+}
+
+// ServeHTTP reports the status as JSON, with a 503 status code while degraded.
+func (health *Health) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	status := health.Status()
+	w.Header().Set("Content-Type", "application/json")
+	if status.Status != utils.HEALTH_OK {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	err := json.NewEncoder(w).Encode(status)
+	if err != nil {
+		log.Error(err)
+	}
+}
@@ -0,0 +1,302 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Jason-CKY/telegram-reminderbot/pkg/schemas"
+	"github.com/Jason-CKY/telegram-reminderbot/pkg/utils"
+)
+
+var icsWeekdays = []string{"SU", "MO", "TU", "WE", "TH", "FR", "SA"}
+
+// BuildChatCalendar returns the chat's reminders as an iCalendar (RFC 5545)
+// VCALENDAR. Event times carry the chat's IANA timezone as their TZID, which is
+// described by a VTIMEZONE. Reminders that will not fire again are left out.
+func BuildChatCalendar(ctx context.Context, chatId int64, chatSettings *schemas.ChatSettings) ([]byte, error) {
+	reminders, err := schemas.ListChatReminders(ctx, chatId)
+	if err != nil {
+		return nil, err
+	}
+	tz, err := time.LoadLocation(chatSettings.Timezone)
+	if err != nil {
+		return nil, err
+	}
+	lines := []string{
+		"BEGIN:VCALENDAR",
+		"VERSION:2.0",
+		"PRODID:-//telegram-reminderbot//EN",
+		"CALSCALE:GREGORIAN",
+		"X-WR-CALNAME:Reminders",
+		"X-WR-TIMEZONE:" + chatSettings.Timezone,
+	}
+	lines = append(lines, icsTimezone(tz, chatSettings.Timezone, time.Now())...)
+	dtstamp := time.Now().UTC().Format(utils.ICS_UTC_DATETIME_FORMAT)
+	for _, reminder := range reminders {
+		start, rule, err := icsSchedule(reminder, tz)
+		if err != nil {
+			// finished recurrences and invalid frequencies
+			continue
+		}
+		summary := reminder.ReminderText
+		if summary == "" {
+			summary = "Image reminder"
+		}
+		lines = append(lines,
+			"BEGIN:VEVENT",
+			fmt.Sprintf("UID:%v@telegram-reminderbot", reminder.Id),
+			"DTSTAMP:"+dtstamp,
+			fmt.Sprintf("DTSTART;TZID=%v:%v", chatSettings.Timezone, start.Format(utils.ICS_DATETIME_FORMAT)),
+			"SUMMARY:"+escapeICSText(summary),
+		)
+		if rule != "" {
+			lines = append(lines, "RRULE:"+rule)
+		}
+		lines = append(lines, "END:VEVENT")
+	}
+	lines = append(lines, "END:VCALENDAR")
+
+	var calendar strings.Builder
+	for _, line := range lines {
+		calendar.WriteString(foldICSLine(line))
+		calendar.WriteString("\r\n")
+	}
+	return []byte(calendar.String()), nil
+}
+
+// icsSchedule returns the first upcoming occurrence of a reminder in tz, and the
+// RRULE that repeats it from there. The rule is empty for once-off reminders.
+func icsSchedule(reminder schemas.Reminder, tz *time.Location) (time.Time, string, error) {
+	frequency := reminder.Frequency
+	now := time.Now()
+	start, err := frequency.Next(reminder.Time, now, tz)
+	if err != nil {
+		return start, "", err
+	}
+	start = start.In(tz)
+
+	switch frequency.Kind {
+	case utils.REMINDER_ONCE:
+		// Next returns the date of a once-off reminder even once it has passed
+		if !start.After(now) {
+			return start, "", errors.New("once-off reminder has passed")
+		}
+		return start, "", nil
+	case utils.REMINDER_DAILY:
+		return start, "FREQ=DAILY", nil
+	case utils.REMINDER_WEEKLY:
+		return start, "FREQ=WEEKLY;BYDAY=" + icsWeekdays[frequency.Weekday], nil
+	case utils.REMINDER_MONTHLY:
+		return start, fmt.Sprintf("FREQ=MONTHLY;BYMONTHDAY=%v", frequency.DayOfMonth), nil
+	case utils.REMINDER_YEARLY:
+		return start, "FREQ=YEARLY", nil
+	case utils.REMINDER_RRULE:
+		// DTSTART moves to the next occurrence, so COUNT only keeps the
+		// occurrences left from there, and a date-only UNTIL becomes the end of
+		// that day, which is how the bot reads it.
+		var parts []string
+		for _, part := range strings.Split(frequency.Rule(), ";") {
+			name, value, _ := strings.Cut(part, "=")
+			switch name {
+			case "COUNT":
+				count, _ := strconv.Atoi(value)
+				remaining := 1
+				for occurrence := start; remaining < count; remaining++ {
+					occurrence, err = frequency.Next(reminder.Time, occurrence, tz)
+					if err != nil {
+						break
+					}
+				}
+				part = fmt.Sprintf("COUNT=%v", remaining)
+			case "UNTIL":
+				until, err := time.ParseInLocation(utils.RRULE_DATE_FORMAT, value, tz)
+				if err == nil {
+					part = "UNTIL=" + until.AddDate(0, 0, 1).Add(-time.Second).UTC().Format(utils.ICS_UTC_DATETIME_FORMAT)
+				}
+			}
+			parts = append(parts, part)
+		}
+		return start, strings.Join(parts, ";"), nil
+	default:
+		return start, "", fmt.Errorf("unknown frequency: %v", frequency.Kind)
+	}
+}
+
+// icsTimezone returns the VTIMEZONE for tz, from the year before now on. The
+// transitions of that year repeat yearly when the following ICS_TIMEZONE_YEARS
+// years keep to the same rules, which holds for most timezones with daylight
+// saving time. Otherwise the transitions of those years are listed one by one.
+func icsTimezone(tz *time.Location, tzid string, now time.Time) []string {
+	lines := []string{"BEGIN:VTIMEZONE", "TZID:" + tzid}
+	year := now.In(tz).Year() - 1
+	transitions := zoneTransitions(tz, year, year+1)
+	if len(transitions) == 0 {
+		// the zone keeps its offset, since 1970 as far as calendars need to know
+		name, offset := now.In(tz).Zone()
+		lines = append(lines,
+			"BEGIN:STANDARD",
+			"DTSTART:19700101T000000",
+			"TZOFFSETFROM:"+icsUtcOffset(offset),
+			"TZOFFSETTO:"+icsUtcOffset(offset),
+			"TZNAME:"+name,
+			"END:STANDARD",
+		)
+	} else if rules := icsTransitionRules(tz, transitions, year); rules != nil {
+		for i, transition := range transitions {
+			lines = append(lines, icsObservance(tz, transition, rules[i])...)
+		}
+	} else {
+		for _, transition := range zoneTransitions(tz, year, year+1+utils.ICS_TIMEZONE_YEARS) {
+			lines = append(lines, icsObservance(tz, transition, "")...)
+		}
+	}
+	return append(lines, "END:VTIMEZONE")
+}
+
+// zoneTransitions returns the times tz changes its offset or abbreviation, from
+// the start of fromYear until the start of toYear.
+func zoneTransitions(tz *time.Location, fromYear int, toYear int) []time.Time {
+	var transitions []time.Time
+	end := time.Date(toYear, 1, 1, 0, 0, 0, 0, tz)
+	for at := time.Date(fromYear, 1, 1, 0, 0, 0, 0, tz); ; {
+		_, next := at.ZoneBounds()
+		if next.IsZero() || !next.Before(end) {
+			return transitions
+		}
+		transitions = append(transitions, next)
+		at = next
+	}
+}
+
+// icsTransitionLocal returns the wall clock time at which a transition happens,
+// in the offset before it, which is how a VTIMEZONE gives its DTSTART.
+func icsTransitionLocal(tz *time.Location, transition time.Time) time.Time {
+	_, offsetFrom := transition.Add(-time.Second).In(tz).Zone()
+	return transition.UTC().Add(time.Duration(offsetFrom) * time.Second)
+}
+
+// icsTransitionRules returns a yearly RRULE for every transition of year,
+// such as the last Sunday of March, or nil if the following years do not keep to them.
+func icsTransitionRules(tz *time.Location, transitions []time.Time, year int) []string {
+	var rules []string
+	for i, transition := range transitions {
+		local := icsTransitionLocal(tz, transition)
+		daysInMonth := time.Date(local.Year(), local.Month()+1, 0, 0, 0, 0, 0, time.UTC).Day()
+		var candidates []int
+		if week := (local.Day()-1)/7 + 1; week <= 4 {
+			candidates = append(candidates, week)
+		}
+		if local.Day()+7 > daysInMonth {
+			candidates = append(candidates, -1)
+		}
+		rule := ""
+		for _, week := range candidates {
+			if icsTransitionRuleHolds(tz, transitions, i, year, week) {
+				rule = fmt.Sprintf("FREQ=YEARLY;BYMONTH=%v;BYDAY=%v%v", int(local.Month()), week, icsWeekdays[local.Weekday()])
+				break
+			}
+		}
+		if rule == "" {
+			return nil
+		}
+		rules = append(rules, rule)
+	}
+	return rules
+}
+
+// icsTransitionRuleHolds checks that the i-th transition of every following
+// year falls on the given week's weekday of the same month, at the same time
+// and with the same offsets.
+func icsTransitionRuleHolds(tz *time.Location, transitions []time.Time, i int, year int, week int) bool {
+	local := icsTransitionLocal(tz, transitions[i])
+	for later := year + 1; later <= year+utils.ICS_TIMEZONE_YEARS; later++ {
+		laterTransitions := zoneTransitions(tz, later, later+1)
+		if len(laterTransitions) != len(transitions) {
+			return false
+		}
+		laterLocal := icsTransitionLocal(tz, laterTransitions[i])
+		want := nthWeekday(later, local.Month(), local.Weekday(), week)
+		if laterLocal.Month() != local.Month() || laterLocal.Day() != want || laterLocal.Format(utils.TIME_ONLY_FORMAT) != local.Format(utils.TIME_ONLY_FORMAT) {
+			return false
+		}
+		if laterTransitions[i].Add(-time.Second).In(tz).Format("-0700 MST") != transitions[i].Add(-time.Second).In(tz).Format("-0700 MST") ||
+			laterTransitions[i].In(tz).Format("-0700 MST") != transitions[i].In(tz).Format("-0700 MST") {
+			return false
+		}
+	}
+	return true
+}
+
+// nthWeekday returns the day of the month of its week-th weekday, counting from
+// the end of the month when week is negative.
+func nthWeekday(year int, month time.Month, weekday time.Weekday, week int) int {
+	if week < 0 {
+		last := time.Date(year, month+1, 0, 0, 0, 0, 0, time.UTC)
+		return last.Day() - (int(last.Weekday())-int(weekday)+7)%7 + 7*(week+1)
+	}
+	first := time.Date(year, month, 1, 0, 0, 0, 0, time.UTC)
+	return 1 + (int(weekday)-int(first.Weekday())+7)%7 + 7*(week-1)
+}
+
+func icsObservance(tz *time.Location, transition time.Time, rule string) []string {
+	name, offsetTo := transition.In(tz).Zone()
+	_, offsetFrom := transition.Add(-time.Second).In(tz).Zone()
+	kind := "STANDARD"
+	if transition.In(tz).IsDST() {
+		kind = "DAYLIGHT"
+	}
+	lines := []string{
+		"BEGIN:" + kind,
+		"DTSTART:" + icsTransitionLocal(tz, transition).Format(utils.ICS_DATETIME_FORMAT),
+		"TZOFFSETFROM:" + icsUtcOffset(offsetFrom),
+		"TZOFFSETTO:" + icsUtcOffset(offsetTo),
+		"TZNAME:" + name,
+	}
+	if rule != "" {
+		lines = append(lines, "RRULE:"+rule)
+	}
+	return append(lines, "END:"+kind)
+}
+
+// icsUtcOffset formats an offset in seconds east of UTC as +HHMM, or +HHMMSS if it has seconds.
+func icsUtcOffset(offset int) string {
+	sign := "+"
+	if offset < 0 {
+		sign = "-"
+		offset = -offset
+	}
+	if offset%60 != 0 {
+		return fmt.Sprintf("%v%02d%02d%02d", sign, offset/3600, offset/60%60, offset%60)
+	}
+	return fmt.Sprintf("%v%02d%02d", sign, offset/3600, offset/60%60)
+}
+
+func escapeICSText(text string) string {
+	return strings.NewReplacer(
+		`\`, `\\`,
+		";", `\;`,
+		",", `\,`,
+		"\r\n", `\n`,
+		"\n", `\n`,
+	).Replace(text)
+}
+
+// foldICSLine splits lines longer than 75 octets, without breaking up UTF-8 characters.
+func foldICSLine(line string) string {
+	var folded strings.Builder
+	length := 0
+	for _, r := range line {
+		size := len(string(r))
+		if length+size > 75 {
+			folded.WriteString("\r\n ")
+			length = 1
+		}
+		folded.WriteRune(r)
+		length += size
+	}
+	return folded.String()
+}
@@ -0,0 +1,281 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Jason-CKY/telegram-reminderbot/pkg/schemas"
+	"github.com/Jason-CKY/telegram-reminderbot/pkg/utils"
+)
+
+type icsProperty struct {
+	params map[string]string
+	value  string
+}
+
+// icsComponent is a VEVENT or VTODO, keeping the first value of each property.
+type icsComponent struct {
+	kind       string
+	properties map[string]icsProperty
+}
+
+// parseICS reads the VEVENTs and VTODOs of an iCalendar file. Properties of
+// nested components such as VALARM are ignored.
+func parseICS(data []byte) ([]icsComponent, error) {
+	text := strings.ReplaceAll(string(data), "\r\n", "\n")
+	// unfold continuation lines, which start with a space or a tab
+	text = strings.NewReplacer("\n ", "", "\n\t", "").Replace(text)
+
+	var components []icsComponent
+	var stack []string
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimRight(line, "\r")
+		if line == "" {
+			continue
+		}
+		name, property := parseICSLine(line)
+		switch name {
+		case "BEGIN":
+			kind := strings.ToUpper(property.value)
+			stack = append(stack, kind)
+			if kind == "VEVENT" || kind == "VTODO" {
+				components = append(components, icsComponent{kind: kind, properties: map[string]icsProperty{}})
+			}
+		case "END":
+			if len(stack) > 0 {
+				stack = stack[:len(stack)-1]
+			}
+		default:
+			if len(stack) == 0 || (stack[len(stack)-1] != "VEVENT" && stack[len(stack)-1] != "VTODO") {
+				continue
+			}
+			component := components[len(components)-1]
+			if _, ok := component.properties[name]; !ok {
+				component.properties[name] = property
+			}
+		}
+	}
+	if len(stack) != 0 || !strings.Contains(strings.ToUpper(text), "BEGIN:VCALENDAR") {
+		return nil, errors.New("not a valid iCalendar file")
+	}
+	return components, nil
+}
+
+// parseICSLine splits a content line such as DTSTART;TZID=Europe/Paris:20240501T090000.
+func parseICSLine(line string) (string, icsProperty) {
+	property := icsProperty{params: map[string]string{}}
+	quoted := false
+	end := len(line)
+	for i, r := range line {
+		if r == '"' {
+			quoted = !quoted
+		} else if r == ':' && !quoted {
+			end = i
+			break
+		}
+	}
+	if end < len(line) {
+		property.value = line[end+1:]
+	}
+	parts := strings.Split(line[:end], ";")
+	for _, param := range parts[1:] {
+		key, value, _ := strings.Cut(param, "=")
+		property.params[strings.ToUpper(key)] = strings.Trim(value, `"`)
+	}
+	return strings.ToUpper(parts[0]), property
+}
+
+func unescapeICSText(text string) string {
+	return strings.NewReplacer(
+		`\\`, `\`,
+		`\;`, ";",
+		`\,`, ",",
+		`\n`, "\n",
+		`\N`, "\n",
+	).Replace(text)
+}
+
+// parseICSDateTime returns a DATE or DATE-TIME value in its own timezone.
+// Floating times without a timezone are read in tz.
+func parseICSDateTime(property icsProperty, tz *time.Location) (time.Time, bool, error) {
+	value := property.value
+	if property.params["VALUE"] == "DATE" || len(value) == len(utils.RRULE_DATE_FORMAT) {
+		date, err := time.ParseInLocation(utils.RRULE_DATE_FORMAT, value, tz)
+		return date, true, err
+	}
+	if strings.HasSuffix(value, "Z") {
+		dateTime, err := time.Parse(utils.ICS_UTC_DATETIME_FORMAT, value)
+		return dateTime, false, err
+	}
+	location := tz
+	if tzid := property.params["TZID"]; tzid != "" {
+		var err error
+		location, err = time.LoadLocation(strings.TrimPrefix(tzid, "/"))
+		if err != nil {
+			return time.Time{}, false, fmt.Errorf("unknown timezone %q", tzid)
+		}
+	}
+	dateTime, err := time.ParseInLocation(utils.ICS_DATETIME_FORMAT, value, location)
+	return dateTime, false, err
+}
+
+// icsRecurrence maps an RRULE onto the reminder frequencies. Rules that only
+// repeat every day, week, month or year become the matching frequency, others
+// are kept as RRule recurrences. dayShift is how many days the start moved
+// when it was converted into the chat's timezone.
+func icsRecurrence(rule string, start time.Time, dayShift int) (schemas.Recurrence, error) {
+	rule = strings.TrimPrefix(strings.ToUpper(rule), "RRULE:")
+	parts := map[string]string{}
+	for _, part := range strings.Split(rule, ";") {
+		name, value, _ := strings.Cut(part, "=")
+		parts[name] = value
+	}
+	frequency := parts["FREQ"]
+	simple := true
+	hasByRule := false
+	for name, value := range parts {
+		switch name {
+		case "FREQ", "WKST":
+		case "INTERVAL":
+			simple = simple && value == "1"
+		case "BYDAY":
+			hasByRule = true
+			simple = simple && frequency == "WEEKLY" && icsWeekdayIndex(value) >= 0
+		case "BYMONTHDAY":
+			hasByRule = true
+			day, err := strconv.Atoi(value)
+			simple = simple && err == nil && frequency == "MONTHLY" && day >= 1 && day <= 31 && dayShift == 0
+		default:
+			hasByRule = hasByRule || strings.HasPrefix(name, "BY")
+			simple = false
+		}
+	}
+	if simple {
+		switch frequency {
+		case "DAILY":
+			return schemas.NewDailyRecurrence(), nil
+		case "WEEKLY":
+			weekday := start.Weekday()
+			if byDay, ok := parts["BYDAY"]; ok {
+				weekday = time.Weekday((icsWeekdayIndex(byDay) + dayShift + 7) % 7)
+			}
+			return schemas.NewWeeklyRecurrence(weekday), nil
+		case "MONTHLY":
+			day := start.Day()
+			if byMonthDay, ok := parts["BYMONTHDAY"]; ok {
+				day, _ = strconv.Atoi(byMonthDay)
+			}
+			return schemas.NewMonthlyRecurrence(day), nil
+		case "YEARLY":
+			return schemas.NewYearlyRecurrence(start), nil
+		}
+	}
+	if hasByRule && dayShift != 0 {
+		return schemas.Recurrence{}, errors.New("repeats on days that fall on other dates in the chat's timezone")
+	}
+	return schemas.NewRRuleRecurrence(rule, start.Format(utils.TIME_ONLY_FORMAT), start)
+}
+
+func icsWeekdayIndex(day string) int {
+	for i, weekday := range icsWeekdays {
+		if weekday == day {
+			return i
+		}
+	}
+	return -1
+}
+
+// icsComponentToReminder returns the reminder for an event or task, and notes
+// on details that could not be carried over.
+func icsComponentToReminder(component icsComponent, tz *time.Location) (schemas.Reminder, []string, error) {
+	var notes []string
+	if _, ok := component.properties["RECURRENCE-ID"]; ok {
+		return schemas.Reminder{}, nil, errors.New("changes to a single occurrence of a recurring event are not supported")
+	}
+	status := strings.ToUpper(component.properties["STATUS"].value)
+	if status == "CANCELLED" || status == "COMPLETED" {
+		return schemas.Reminder{}, nil, fmt.Errorf("is %v", strings.ToLower(status))
+	}
+	startProperty, ok := component.properties["DTSTART"]
+	if due, hasDue := component.properties["DUE"]; component.kind == "VTODO" && hasDue {
+		startProperty, ok = due, true
+	}
+	if !ok {
+		return schemas.Reminder{}, nil, errors.New("has no start or due date")
+	}
+	start, allDay, err := parseICSDateTime(startProperty, tz)
+	if err != nil {
+		return schemas.Reminder{}, nil, err
+	}
+	localStart := start.In(tz)
+	if allDay {
+		hour, minute := utils.ParseReminderTime(utils.ICS_ALL_DAY_REMINDER_TIME)
+		localStart = time.Date(start.Year(), start.Month(), start.Day(), hour, minute, 0, 0, tz)
+		notes = append(notes, fmt.Sprintf("all-day, reminds at %v", utils.ICS_ALL_DAY_REMINDER_TIME))
+	}
+	dayShift := int(dateOnly(localStart).Sub(dateOnly(start)).Hours() / 24)
+
+	reminder := schemas.Reminder{
+		ReminderText: unescapeICSText(component.properties["SUMMARY"].value),
+		Time:         localStart.Format(utils.TIME_ONLY_FORMAT),
+	}
+	if reminder.ReminderText == "" {
+		return schemas.Reminder{}, nil, errors.New("has no SUMMARY")
+	}
+	if rule, ok := component.properties["RRULE"]; ok {
+		reminder.Frequency, err = icsRecurrence(rule.value, localStart, dayShift)
+		if err != nil {
+			return schemas.Reminder{}, nil, err
+		}
+		for _, name := range []string{"EXDATE", "RDATE"} {
+			if _, ok := component.properties[name]; ok {
+				notes = append(notes, name+" is ignored")
+			}
+		}
+	} else {
+		reminder.Frequency = schemas.NewOnceRecurrence(localStart)
+	}
+	return reminder, notes, nil
+}
+
+func dateOnly(date time.Time) time.Time {
+	return time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+func parseICSCalendar(data []byte, chatSettings *schemas.ChatSettings) (ImportPlan, error) {
+	components, err := parseICS(data)
+	if err != nil {
+		return ImportPlan{}, err
+	}
+	if len(components) > utils.MAX_IMPORT_REMINDERS {
+		return ImportPlan{}, fmt.Errorf("the file has %v events and tasks, at most %v can be imported at once", len(components), utils.MAX_IMPORT_REMINDERS)
+	}
+	tz, err := time.LoadLocation(chatSettings.Timezone)
+	if err != nil {
+		return ImportPlan{}, err
+	}
+
+	var plan ImportPlan
+	for i, component := range components {
+		label := fmt.Sprintf("%v %v", strings.ToLower(strings.TrimPrefix(component.kind, "V")), i+1)
+		if summary := unescapeICSText(component.properties["SUMMARY"].value); summary != "" {
+			label = fmt.Sprintf("%q", truncateText(summary, utils.IMPORT_TEXT_LENGTH))
+		}
+		reminder, notes, err := icsComponentToReminder(component, tz)
+		if err == nil {
+			err = checkImportedReminder(reminder, chatSettings.Timezone)
+		}
+		if err != nil {
+			plan.Skipped = append(plan.Skipped, fmt.Sprintf("%v: %v", label, err))
+			continue
+		}
+		for _, note := range notes {
+			plan.Warnings = append(plan.Warnings, fmt.Sprintf("%v: %v", label, note))
+		}
+		plan.Reminders = append(plan.Reminders, reminder)
+	}
+	return plan, nil
+}
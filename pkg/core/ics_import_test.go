@@ -0,0 +1,303 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Jason-CKY/telegram-reminderbot/pkg/schemas"
+	"github.com/Jason-CKY/telegram-reminderbot/pkg/utils"
+)
+
+func icsCalendar(lines ...string) []byte {
+	return []byte("BEGIN:VCALENDAR\r\nVERSION:2.0\r\n" + strings.Join(lines, "\r\n") + "\r\nEND:VCALENDAR\r\n")
+}
+
+func TestParseICS(t *testing.T) {
+	components, err := parseICS(icsCalendar(
+		"BEGIN:VTIMEZONE",
+		"TZID:Europe/Paris",
+		"BEGIN:STANDARD",
+		"DTSTART:19701025T030000",
+		"END:STANDARD",
+		"END:VTIMEZONE",
+		"BEGIN:VEVENT",
+		"SUMMARY:a summary folded over",
+		"  three lines,\twith a tab",
+		"\t fold",
+		`DESCRIPTION;ALTREP="cid:part1@example.org":with a : colon`,
+		"DTSTART;TZID=Europe/Paris:20300501T090000",
+		"BEGIN:VALARM",
+		"DTSTART:20300501T080000",
+		"ACTION:DISPLAY",
+		"END:VALARM",
+		"dtend:20300501T100000",
+		"END:VEVENT",
+		"BEGIN:VTODO",
+		"SUMMARY:first",
+		"SUMMARY:second",
+		"DUE;VALUE=DATE:20300502",
+		"END:VTODO",
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(components) != 2 || components[0].kind != "VEVENT" || components[1].kind != "VTODO" {
+		t.Fatalf("got components %+v, want a VEVENT and a VTODO", components)
+	}
+	event := components[0]
+	if got := event.properties["SUMMARY"].value; got != "a summary folded over three lines,\twith a tab fold" {
+		t.Errorf("summary is %q", got)
+	}
+	if description := event.properties["DESCRIPTION"]; description.value != "with a : colon" || description.params["ALTREP"] != "cid:part1@example.org" {
+		t.Errorf("description is %+v", description)
+	}
+	// the alarm's DTSTART is not the event's
+	if start := event.properties["DTSTART"]; start.value != "20300501T090000" || start.params["TZID"] != "Europe/Paris" {
+		t.Errorf("start is %+v", start)
+	}
+	if _, ok := event.properties["ACTION"]; ok {
+		t.Error("properties of the alarm were kept")
+	}
+	if _, ok := event.properties["DTEND"]; !ok {
+		t.Error("property names are not case insensitive")
+	}
+	if got := components[1].properties["SUMMARY"].value; got != "first" {
+		t.Errorf("summary is %q, want the first value", got)
+	}
+
+	for name, data := range map[string]string{
+		"not a calendar": "BEGIN:VEVENT\r\nSUMMARY:loose\r\nEND:VEVENT\r\n",
+		"unterminated":   "BEGIN:VCALENDAR\r\nBEGIN:VEVENT\r\nSUMMARY:open\r\nEND:VCALENDAR\r\n",
+		"csv":            "text,time\nhello,09:00\n",
+	} {
+		if _, err := parseICS([]byte(data)); err == nil {
+			t.Errorf("%v: file was accepted", name)
+		}
+	}
+}
+
+func TestUnescapeICSText(t *testing.T) {
+	for text, want := range map[string]string{
+		`semi\;colon\, comma`:    "semi;colon, comma",
+		`two\nlines\Nthree`:      "two\nlines\nthree",
+		`back\\slash`:            `back\slash`,
+		`back\\nslash not a new`: `back\nslash not a new`,
+	} {
+		if got := unescapeICSText(text); got != want {
+			t.Errorf("unescaped %q as %q, want %q", text, got, want)
+		}
+		if text != `two\nlines\Nthree` {
+			if roundTrip := unescapeICSText(escapeICSText(want)); roundTrip != want {
+				t.Errorf("%q does not round trip, got %q", want, roundTrip)
+			}
+		}
+	}
+}
+
+func TestICSRecurrence(t *testing.T) {
+	// a Wednesday at 09:00
+	start := time.Date(2030, 1, 2, 9, 0, 0, 0, time.UTC)
+	for _, test := range []struct {
+		rule     string
+		dayShift int
+		want     string
+	}{
+		{"FREQ=DAILY", 0, "Daily"},
+		{"RRULE:FREQ=DAILY;INTERVAL=1;WKST=MO", 0, "Daily"},
+		{"FREQ=WEEKLY", 0, "Weekly-3"},
+		{"FREQ=WEEKLY;BYDAY=MO", 0, "Weekly-1"},
+		// a Monday evening in New York is Tuesday morning in Singapore
+		{"FREQ=WEEKLY;BYDAY=MO", 1, "Weekly-2"},
+		{"FREQ=WEEKLY;BYDAY=SU", -1, "Weekly-6"},
+		{"FREQ=WEEKLY;BYDAY=SA", 1, "Weekly-0"},
+		{"FREQ=MONTHLY", 0, "Monthly-2"},
+		{"FREQ=MONTHLY;BYMONTHDAY=31", 0, "Monthly-31"},
+		{"FREQ=YEARLY", 0, "Yearly-2030/01/02"},
+		{"freq=yearly", 1, "Yearly-2030/01/02"},
+		{"FREQ=WEEKLY;INTERVAL=2", 0, "RRule-FREQ=WEEKLY;INTERVAL=2;DTSTART=20300102"},
+		{"FREQ=WEEKLY;BYDAY=MO,WE", 0, "RRule-FREQ=WEEKLY;BYDAY=MO,WE;DTSTART=20300102"},
+		{"FREQ=MONTHLY;BYDAY=-1FR", 0, "RRule-FREQ=MONTHLY;BYDAY=-1FR;DTSTART=20300102"},
+		{"FREQ=DAILY;COUNT=3", 1, "RRule-FREQ=DAILY;COUNT=3;DTSTART=20300102"},
+		{"FREQ=YEARLY;BYMONTH=1;BYMONTHDAY=2", 0, "RRule-FREQ=YEARLY;BYMONTH=1;BYMONTHDAY=2;DTSTART=20300102"},
+	} {
+		recurrence, err := icsRecurrence(test.rule, start, test.dayShift)
+		if err != nil {
+			t.Errorf("%v shifted by %v: %v", test.rule, test.dayShift, err)
+			continue
+		}
+		if recurrence.String() != test.want {
+			t.Errorf("%v shifted by %v is %v, want %v", test.rule, test.dayShift, recurrence, test.want)
+		}
+	}
+
+	for _, test := range []struct {
+		rule     string
+		dayShift int
+	}{
+		// the days would have to move with the timezone
+		{"FREQ=MONTHLY;BYMONTHDAY=15", 1},
+		{"FREQ=WEEKLY;BYDAY=MO,WE", -1},
+		{"FREQ=MONTHLY;BYDAY=-1FR", 1},
+		// more than once a day
+		{"FREQ=HOURLY", 0},
+		{"FREQ=DAILY;BYHOUR=9,17", 0},
+		{"FREQ=FORTNIGHTLY", 0},
+	} {
+		if recurrence, err := icsRecurrence(test.rule, start, test.dayShift); err == nil {
+			t.Errorf("%v shifted by %v was accepted as %v", test.rule, test.dayShift, recurrence)
+		}
+	}
+}
+
+func TestICSComponentToReminder(t *testing.T) {
+	singapore, err := time.LoadLocation("Asia/Singapore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		name      string
+		lines     []string
+		want      string
+		wantNotes []string
+	}{
+		{"floating time", []string{"SUMMARY:floating", "DTSTART:20300102T093000"}, "floating at 09:30 Once-2030/01/02", nil},
+		{"utc", []string{"SUMMARY:utc", "DTSTART:20300102T093000Z"}, "utc at 17:30 Once-2030/01/02", nil},
+		{
+			"moves to the next day",
+			[]string{"SUMMARY:weekly call", "DTSTART;TZID=America/New_York:20300107T200000", "RRULE:FREQ=WEEKLY;BYDAY=MO"},
+			"weekly call at 09:00 Weekly-2", nil,
+		},
+		{
+			"moves to the day before",
+			[]string{"SUMMARY:early", "DTSTART;TZID=Pacific/Auckland:20300106T040000", "RRULE:FREQ=WEEKLY;BYDAY=SU"},
+			"early at 23:00 Weekly-6", nil,
+		},
+		{
+			"all day",
+			[]string{"SUMMARY:holiday", "DTSTART;VALUE=DATE:20300102", "RRULE:FREQ=YEARLY"},
+			"holiday at 09:00 Yearly-2030/01/02", []string{"all-day, reminds at 09:00"},
+		},
+		{
+			"escaped text",
+			[]string{`SUMMARY:pack\, label\; ship\nthen rest`, "DTSTART:20300102T093000"},
+			"pack, label; ship\nthen rest at 09:30 Once-2030/01/02", nil,
+		},
+		{
+			"exceptions",
+			[]string{"SUMMARY:gym", "DTSTART:20300102T070000", "RRULE:FREQ=DAILY", "EXDATE:20300103T070000", "RDATE:20300110T070000"},
+			"gym at 07:00 Daily", []string{"EXDATE is ignored", "RDATE is ignored"},
+		},
+	} {
+		components, err := parseICS(icsCalendar(append(append([]string{"BEGIN:VEVENT"}, test.lines...), "END:VEVENT")...))
+		if err != nil {
+			t.Fatal(err)
+		}
+		reminder, notes, err := icsComponentToReminder(components[0], singapore)
+		if err != nil {
+			t.Errorf("%v: %v", test.name, err)
+			continue
+		}
+		if got := fmt.Sprintf("%v at %v %v", reminder.ReminderText, reminder.Time, reminder.Frequency); got != test.want {
+			t.Errorf("%v: got %q, want %q", test.name, got, test.want)
+		}
+		if strings.Join(notes, "; ") != strings.Join(test.wantNotes, "; ") {
+			t.Errorf("%v: notes are %q, want %q", test.name, notes, test.wantNotes)
+		}
+	}
+
+	components, err := parseICS(icsCalendar("BEGIN:VTODO", "SUMMARY:file taxes", "DTSTART:20300101T090000", "DUE;TZID=Asia/Singapore:20300415T180000", "END:VTODO"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	reminder, _, err := icsComponentToReminder(components[0], singapore)
+	if err != nil || reminder.Time != "18:00" || reminder.Frequency.String() != "Once-2030/04/15" {
+		t.Errorf("task is %+v (%v), want a reminder when it is due", reminder, err)
+	}
+
+	for name, lines := range map[string][]string{
+		"occurrence change": {"SUMMARY:moved", "DTSTART:20300102T093000", "RECURRENCE-ID:20300102T093000"},
+		"cancelled":         {"SUMMARY:off", "DTSTART:20300102T093000", "STATUS:CANCELLED"},
+		"no start":          {"SUMMARY:whenever"},
+		"no summary":        {"DTSTART:20300102T093000"},
+		"unknown timezone":  {"SUMMARY:custom", "DTSTART;TZID=Eastern Standard Time:20300102T093000"},
+		"bad date":          {"SUMMARY:bad", "DTSTART:2030-01-02"},
+		"shifted by days":   {"SUMMARY:shifted", "DTSTART;TZID=America/New_York:20300107T200000", "RRULE:FREQ=WEEKLY;BYDAY=MO,WE"},
+	} {
+		components, err := parseICS(icsCalendar(append(append([]string{"BEGIN:VEVENT"}, lines...), "END:VEVENT")...))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if reminder, _, err := icsComponentToReminder(components[0], singapore); err == nil {
+			t.Errorf("%v: imported as %+v", name, reminder)
+		}
+	}
+}
+
+func TestParseICSCalendarReportsSkippedEvents(t *testing.T) {
+	plan, err := parseICSCalendar(icsCalendar(
+		"BEGIN:VEVENT", "SUMMARY:kept", "DTSTART:20300102T093000", "RRULE:FREQ=DAILY", "EXDATE:20300103T093000", "END:VEVENT",
+		"BEGIN:VEVENT", "SUMMARY:past", "DTSTART:20010102T093000", "END:VEVENT",
+		"BEGIN:VEVENT", "DTSTART:20300102T093000", "END:VEVENT",
+	), &schemas.ChatSettings{ChatId: 1, Timezone: "UTC"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan.Reminders) != 1 || plan.Reminders[0].ReminderText != "kept" {
+		t.Errorf("imported %+v, want only the upcoming event", plan.Reminders)
+	}
+	wantSkipped := `"past": once-off reminder on Tue, 02 Jan 2001 has already passed` + "\n" + "event 3: has no SUMMARY"
+	if strings.Join(plan.Skipped, "\n") != wantSkipped {
+		t.Errorf("skipped %q, want %q", plan.Skipped, wantSkipped)
+	}
+	if strings.Join(plan.Warnings, "\n") != `"kept": EXDATE is ignored` {
+		t.Errorf("warnings are %q", plan.Warnings)
+	}
+}
+
+func TestICSExportImportRoundTrip(t *testing.T) {
+	setupTest(t)
+	ctx := context.Background()
+	chatSettings := &schemas.ChatSettings{ChatId: 1, Timezone: "America/New_York"}
+	var reminders []schemas.Reminder
+	for _, reminder := range exportedReminders(t) {
+		// calendars have no images, those come back as text reminders
+		if reminder.FileId == "" {
+			reminders = append(reminders, reminder)
+		}
+	}
+	createChatReminders(t, chatSettings, reminders)
+
+	data, err := BuildChatCalendar(ctx, 1, chatSettings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plan, err := parseICSCalendar(data, &schemas.ChatSettings{ChatId: 2, Timezone: "America/New_York"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan.Skipped) != 0 || len(plan.Warnings) != 0 {
+		t.Errorf("skipped %q with warnings %q", plan.Skipped, plan.Warnings)
+	}
+	// the calendar starts each reminder at its next occurrence, so yearly and
+	// RRULE frequencies are compared as they are shown to users
+	describe := func(reminders []schemas.Reminder) string {
+		var descriptions []string
+		for _, reminder := range reminders {
+			descriptions = append(descriptions, fmt.Sprintf("%q at %v, %v", reminder.ReminderText, reminder.Time, parseReminderFrequencyToText(reminder)))
+		}
+		sort.Strings(descriptions)
+		return strings.Join(descriptions, "\n")
+	}
+	if got, want := describe(plan.Reminders), describe(reminders); got != want {
+		t.Errorf("imported:\n%v\nwant:\n%v", got, want)
+	}
+	for _, reminder := range plan.Reminders {
+		if reminder.Frequency.Kind == utils.REMINDER_RRULE && !strings.Contains(reminder.Frequency.RRule, "COUNT=12") {
+			t.Errorf("RRULE is %v, want the 12 occurrences left", reminder.Frequency.RRule)
+		}
+	}
+}
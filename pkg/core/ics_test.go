@@ -0,0 +1,258 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/Jason-CKY/telegram-reminderbot/pkg/schemas"
+	"github.com/Jason-CKY/telegram-reminderbot/pkg/utils"
+)
+
+func TestICSScheduleFrequencies(t *testing.T) {
+	singapore, err := time.LoadLocation("Asia/Singapore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	inThreeDays := time.Now().In(singapore).AddDate(0, 0, 3)
+	for _, test := range []struct {
+		frequency schemas.Recurrence
+		rule      string
+		check     func(start time.Time) bool
+	}{
+		{schemas.NewDailyRecurrence(), "FREQ=DAILY", func(time.Time) bool { return true }},
+		{schemas.NewWeeklyRecurrence(time.Wednesday), "FREQ=WEEKLY;BYDAY=WE", func(start time.Time) bool { return start.Weekday() == time.Wednesday }},
+		{schemas.NewMonthlyRecurrence(15), "FREQ=MONTHLY;BYMONTHDAY=15", func(start time.Time) bool { return start.Day() == 15 }},
+		{schemas.NewYearlyRecurrence(time.Date(2024, 3, 15, 0, 0, 0, 0, time.UTC)), "FREQ=YEARLY", func(start time.Time) bool { return start.Month() == time.March && start.Day() == 15 }},
+		{schemas.NewOnceRecurrence(inThreeDays), "", func(start time.Time) bool { return start.Day() == inThreeDays.Day() }},
+	} {
+		reminder := schemas.Reminder{Time: "09:30", Frequency: test.frequency}
+		start, rule, err := icsSchedule(reminder, singapore)
+		if err != nil {
+			t.Errorf("%v: %v", test.frequency, err)
+			continue
+		}
+		if rule != test.rule {
+			t.Errorf("%v: rule is %q, want %q", test.frequency, rule, test.rule)
+		}
+		if start.Location() != singapore || start.Format(utils.TIME_ONLY_FORMAT) != "09:30" || !start.After(time.Now()) || !test.check(start) {
+			t.Errorf("%v: starts at %v", test.frequency, start)
+		}
+	}
+
+	_, _, err = icsSchedule(schemas.Reminder{Time: "09:30", Frequency: schemas.NewOnceRecurrence(time.Now().AddDate(0, 0, -1))}, singapore)
+	if err == nil {
+		t.Error("a once-off reminder that has passed was exported")
+	}
+}
+
+func TestICSScheduleCountsRemainingOccurrences(t *testing.T) {
+	now := time.Now().UTC()
+	dtstart := now.AddDate(0, 0, -3)
+	// occurrences on the four days before today, and possibly today, have passed
+	passed := 3
+	if !time.Date(now.Year(), now.Month(), now.Day(), 9, 0, 0, 0, time.UTC).After(now) {
+		passed++
+	}
+	for _, test := range []struct {
+		count, want int
+	}{
+		{10, 10 - passed},
+		{passed + 1, 1},
+	} {
+		frequency, err := schemas.NewRRuleRecurrence(fmt.Sprintf("FREQ=DAILY;COUNT=%v;DTSTART=%v", test.count, dtstart.Format(utils.RRULE_DATE_FORMAT)), "09:00", dtstart)
+		if err != nil {
+			t.Fatal(err)
+		}
+		start, rule, err := icsSchedule(schemas.Reminder{Time: "09:00", Frequency: frequency}, time.UTC)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := fmt.Sprintf("FREQ=DAILY;COUNT=%v", test.want); rule != want {
+			t.Errorf("COUNT=%v from %v is exported as %q, want %q", test.count, dtstart.Format(utils.RRULE_DATE_FORMAT), rule, want)
+		}
+		if !start.After(now) || now.Add(24*time.Hour).Before(start) {
+			t.Errorf("starts at %v, want the next occurrence", start)
+		}
+	}
+
+	// with every occurrence passed, the reminder is left out
+	frequency, err := schemas.NewRRuleRecurrence("FREQ=DAILY;COUNT=2;DTSTART="+dtstart.Format(utils.RRULE_DATE_FORMAT), "09:00", dtstart)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := icsSchedule(schemas.Reminder{Time: "09:00", Frequency: frequency}, time.UTC); err == nil {
+		t.Error("an ended recurrence was exported")
+	}
+}
+
+func TestICSScheduleUntilEndOfDay(t *testing.T) {
+	singapore, err := time.LoadLocation("Asia/Singapore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	until := time.Now().In(singapore).AddDate(0, 0, 7)
+	frequency, err := schemas.NewRRuleRecurrence("FREQ=DAILY;INTERVAL=2;UNTIL="+until.Format(utils.RRULE_DATE_FORMAT), "21:00", time.Now().In(singapore))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, rule, err := icsSchedule(schemas.Reminder{Time: "21:00", Frequency: frequency}, singapore)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the end of the day in Singapore is 15:59:59 UTC
+	if want := fmt.Sprintf("FREQ=DAILY;INTERVAL=2;UNTIL=%vT155959Z", until.Format(utils.RRULE_DATE_FORMAT)); rule != want {
+		t.Errorf("rule is %q, want %q", rule, want)
+	}
+}
+
+func TestEscapeICSText(t *testing.T) {
+	for text, want := range map[string]string{
+		"plain":                  "plain",
+		`back\slash`:             `back\\slash`,
+		"semi;colon, comma":      `semi\;colon\, comma`,
+		"two\nlines\r\nand more": `two\nlines\nand more`,
+	} {
+		if got := escapeICSText(text); got != want {
+			t.Errorf("escaped %q as %q, want %q", text, got, want)
+		}
+	}
+}
+
+func TestFoldICSLine(t *testing.T) {
+	for _, line := range []string{
+		"SUMMARY:short",
+		"SUMMARY:" + strings.Repeat("a", 67),
+		"SUMMARY:" + strings.Repeat("a", 200),
+		"SUMMARY:" + strings.Repeat("日本語", 40),
+		"SUMMARY:" + strings.Repeat("a", 66) + "🙂🙂",
+	} {
+		folded := foldICSLine(line)
+		for _, physical := range strings.Split(folded, "\r\n") {
+			if len(physical) > 75 {
+				t.Errorf("line of %v octets: %q", len(physical), physical)
+			}
+			if !utf8.ValidString(physical) {
+				t.Errorf("line breaks up a character: %q", physical)
+			}
+		}
+		if unfolded := strings.ReplaceAll(folded, "\r\n ", ""); unfolded != line {
+			t.Errorf("folded %q unfolds to %q", line, unfolded)
+		}
+		if len(line) <= 75 && folded != line {
+			t.Errorf("folded %q, which fits on a line", line)
+		}
+	}
+}
+
+func TestNthWeekday(t *testing.T) {
+	for _, test := range []struct {
+		year    int
+		month   time.Month
+		weekday time.Weekday
+		week    int
+		want    int
+	}{
+		{2024, time.March, time.Sunday, -1, 31},
+		{2025, time.March, time.Sunday, -1, 30},
+		{2024, time.March, time.Sunday, 2, 10},
+		{2024, time.November, time.Sunday, 1, 3},
+		{2024, time.February, time.Thursday, -1, 29},
+		{2024, time.February, time.Friday, 1, 2},
+	} {
+		if got := nthWeekday(test.year, test.month, test.weekday, test.week); got != test.want {
+			t.Errorf("week %v's %v of %v %v is the %v, want the %v", test.week, test.weekday, test.month, test.year, got, test.want)
+		}
+	}
+}
+
+func TestICSTimezone(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	for _, test := range []struct {
+		tzid string
+		want []string
+	}{
+		{"Asia/Singapore", []string{
+			"BEGIN:VTIMEZONE", "TZID:Asia/Singapore",
+			"BEGIN:STANDARD", "DTSTART:19700101T000000", "TZOFFSETFROM:+0800", "TZOFFSETTO:+0800", "TZNAME:+08", "END:STANDARD",
+			"END:VTIMEZONE",
+		}},
+		{"Europe/London", []string{
+			"BEGIN:VTIMEZONE", "TZID:Europe/London",
+			"BEGIN:DAYLIGHT", "DTSTART:20230326T010000", "TZOFFSETFROM:+0000", "TZOFFSETTO:+0100", "TZNAME:BST", "RRULE:FREQ=YEARLY;BYMONTH=3;BYDAY=-1SU", "END:DAYLIGHT",
+			"BEGIN:STANDARD", "DTSTART:20231029T020000", "TZOFFSETFROM:+0100", "TZOFFSETTO:+0000", "TZNAME:GMT", "RRULE:FREQ=YEARLY;BYMONTH=10;BYDAY=-1SU", "END:STANDARD",
+			"END:VTIMEZONE",
+		}},
+		{"America/New_York", []string{
+			"BEGIN:VTIMEZONE", "TZID:America/New_York",
+			"BEGIN:DAYLIGHT", "DTSTART:20230312T020000", "TZOFFSETFROM:-0500", "TZOFFSETTO:-0400", "TZNAME:EDT", "RRULE:FREQ=YEARLY;BYMONTH=3;BYDAY=2SU", "END:DAYLIGHT",
+			"BEGIN:STANDARD", "DTSTART:20231105T020000", "TZOFFSETFROM:-0400", "TZOFFSETTO:-0500", "TZNAME:EST", "RRULE:FREQ=YEARLY;BYMONTH=11;BYDAY=1SU", "END:STANDARD",
+			"END:VTIMEZONE",
+		}},
+		{"Australia/Sydney", []string{
+			"BEGIN:VTIMEZONE", "TZID:Australia/Sydney",
+			"BEGIN:STANDARD", "DTSTART:20230402T030000", "TZOFFSETFROM:+1100", "TZOFFSETTO:+1000", "TZNAME:AEST", "RRULE:FREQ=YEARLY;BYMONTH=4;BYDAY=1SU", "END:STANDARD",
+			"BEGIN:DAYLIGHT", "DTSTART:20231001T020000", "TZOFFSETFROM:+1000", "TZOFFSETTO:+1100", "TZNAME:AEDT", "RRULE:FREQ=YEARLY;BYMONTH=10;BYDAY=1SU", "END:DAYLIGHT",
+			"END:VTIMEZONE",
+		}},
+	} {
+		tz, err := time.LoadLocation(test.tzid)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := icsTimezone(tz, test.tzid, now)
+		if strings.Join(got, "\n") != strings.Join(test.want, "\n") {
+			t.Errorf("%v:\n%v\nwant:\n%v", test.tzid, strings.Join(got, "\n"), strings.Join(test.want, "\n"))
+		}
+	}
+}
+
+func TestICSUtcOffset(t *testing.T) {
+	for offset, want := range map[int]string{0: "+0000", 8 * 3600: "+0800", -(3*3600 + 30*60): "-0330", 12*3600 + 45*60: "+1245", -(17*60 + 30): "-001730"} {
+		if got := icsUtcOffset(offset); got != want {
+			t.Errorf("offset %v is %q, want %q", offset, got, want)
+		}
+	}
+}
+
+func TestBuildChatCalendar(t *testing.T) {
+	setupTest(t)
+	ctx := context.Background()
+	chatSettings := &schemas.ChatSettings{ChatId: 1, Timezone: "Europe/London"}
+	createChatReminders(t, chatSettings, exportedReminders(t))
+	// a once-off reminder whose time has passed will not fire again
+	err := schemas.Reminder{Id: "passed", ChatId: 1, ReminderText: "passed", Time: "09:00", Frequency: schemas.NewOnceRecurrence(time.Now().AddDate(0, 0, -2))}.Create(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := BuildChatCalendar(ctx, 1, chatSettings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	calendar := string(data)
+	if !strings.HasSuffix(calendar, "END:VCALENDAR\r\n") || strings.Contains(strings.ReplaceAll(calendar, "\r\n", ""), "\n") {
+		t.Errorf("lines are not ended with CRLF:\n%q", calendar)
+	}
+	if strings.Count(calendar, "BEGIN:VEVENT") != len(exportedReminders(t)) || strings.Contains(calendar, "SUMMARY:passed") {
+		t.Errorf("want every reminder but the passed one:\n%v", calendar)
+	}
+	if !strings.Contains(calendar, "BEGIN:VTIMEZONE\r\nTZID:Europe/London\r\n") {
+		t.Errorf("no VTIMEZONE for the chat's timezone:\n%v", calendar)
+	}
+	for _, want := range []string{
+		"DTSTART;TZID=Europe/London:",
+		"SUMMARY:stand-up\\; bring notes\\, please\r\n",
+		"RRULE:FREQ=WEEKLY;BYDAY=MO\r\n",
+		"SUMMARY:renew passport\\nbring photos\r\n",
+		"SUMMARY:Image reminder\r\n",
+		"RRULE:FREQ=MONTHLY;BYSETPOS=-1;BYDAY=FR;COUNT=12\r\n",
+	} {
+		if !strings.Contains(calendar, want) {
+			t.Errorf("calendar does not contain %q:\n%v", want, calendar)
+		}
+	}
+}
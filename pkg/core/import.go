@@ -0,0 +1,311 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Jason-CKY/telegram-reminderbot/pkg/schemas"
+	"github.com/Jason-CKY/telegram-reminderbot/pkg/utils"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/google/uuid"
+)
+
+// ChatExport is the JSON document sent by /export and read back by /import.
+type ChatExport struct {
+	Version    int                `json:"version"`
+	ExportedAt string             `json:"exported_at"`
+	Settings   ExportedSettings   `json:"settings"`
+	Reminders  []ExportedReminder `json:"reminders"`
+}
+
+type ExportedSettings struct {
+	Timezone string `json:"timezone"`
+}
+
+// ExportedReminder leaves out the ids and next_trigger_time, which are
+// generated again on import.
+type ExportedReminder struct {
+	ReminderText string             `json:"reminder_text"`
+	FileId       string             `json:"file_id,omitempty"`
+	Time         string             `json:"time"`
+	Frequency    schemas.Recurrence `json:"frequency"`
+}
+
+// ImportPlan holds the reminders read from an uploaded file, checked and ready
+// to be created once the user confirms the import. Reminders are created in
+// the chat the file was sent to, unless their ChatId is set.
+type ImportPlan struct {
+	Reminders []schemas.Reminder
+	Skipped   []string
+	// Warnings lists details of the file that are left out of reminders that are imported.
+	Warnings []string
+	// Strict plans are only imported when nothing was skipped.
+	Strict bool
+	// Timezone is set on the chat before the reminders are created, empty keeps the chat's timezone.
+	Timezone string
+}
+
+func SplitCallbackImportData(callbackData string) (string, string, int64) {
+	x := strings.Split(callbackData, "_")
+	format := x[1]
+	action := x[2]
+	userId, _ := strconv.ParseInt(x[3], 10, 64)
+	return format, action, userId
+}
+
+func GetCallbackImportData(format string, action string, userId int64) string {
+	return fmt.Sprintf("im_%v_%v_%v", format, action, userId)
+}
+
+func BuildChatExport(ctx context.Context, chatId int64, chatSettings *schemas.ChatSettings) ([]byte, error) {
+	reminders, err := schemas.ListChatReminders(ctx, chatId)
+	if err != nil {
+		return nil, err
+	}
+	chatExport := ChatExport{
+		Version:    utils.EXPORT_FORMAT_VERSION,
+		ExportedAt: time.Now().UTC().Format(time.RFC3339),
+		Settings:   ExportedSettings{Timezone: chatSettings.Timezone},
+		Reminders:  []ExportedReminder{},
+	}
+	for _, reminder := range reminders {
+		chatExport.Reminders = append(chatExport.Reminders, ExportedReminder{
+			ReminderText: reminder.ReminderText,
+			FileId:       reminder.FileId,
+			Time:         reminder.Time,
+			Frequency:    reminder.Frequency,
+		})
+	}
+	var buffer bytes.Buffer
+	encoder := json.NewEncoder(&buffer)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(chatExport); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+// DownloadDocument reads a document sent to the bot, refusing files larger than MAX_IMPORT_FILE_SIZE.
+func DownloadDocument(ctx context.Context, document *tgbotapi.Document) ([]byte, error) {
+	if document.FileSize > utils.MAX_IMPORT_FILE_SIZE {
+		return nil, fmt.Errorf("the file is larger than %v KB", utils.MAX_IMPORT_FILE_SIZE/1024)
+	}
+	fileUrl, err := Outbox.GetFileDirectURL(ctx, document.FileID, utils.PRIORITY_INTERACTIVE)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fileUrl, nil)
+	if err != nil {
+		return nil, err
+	}
+	client := &http.Client{Timeout: utils.IMPORT_DOWNLOAD_TIMEOUT}
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("downloading file: status %v", res.StatusCode)
+	}
+	data, err := io.ReadAll(io.LimitReader(res.Body, utils.MAX_IMPORT_FILE_SIZE+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > utils.MAX_IMPORT_FILE_SIZE {
+		return nil, fmt.Errorf("the file is larger than %v KB", utils.MAX_IMPORT_FILE_SIZE/1024)
+	}
+	return data, nil
+}
+
+// BuildImportPlan parses an uploaded file in the given import format. The error
+// is only set when the file cannot be read at all; reminders that cannot be
+// imported are listed in ImportPlan.Skipped. userId is the user importing the
+// file, whose rights in other chats are checked.
+func BuildImportPlan(ctx context.Context, format string, data []byte, chatSettings *schemas.ChatSettings, userId int64) (ImportPlan, error) {
+	switch format {
+	case utils.IMPORT_FORMAT_JSON:
+		return parseChatExport(data, chatSettings)
+	case utils.IMPORT_FORMAT_ICS:
+		return parseICSCalendar(data, chatSettings)
+	case utils.IMPORT_FORMAT_CSV:
+		return parseReminderCSV(ctx, data, chatSettings, userId)
+	default:
+		return ImportPlan{}, fmt.Errorf("unknown import format: %v", format)
+	}
+}
+
+func parseChatExport(data []byte, chatSettings *schemas.ChatSettings) (ImportPlan, error) {
+	var chatExport ChatExport
+	if err := json.Unmarshal(data, &chatExport); err != nil {
+		return ImportPlan{}, fmt.Errorf("not a reminder export: %v", err)
+	}
+	if chatExport.Version != utils.EXPORT_FORMAT_VERSION {
+		return ImportPlan{}, fmt.Errorf("unsupported export version %v", chatExport.Version)
+	}
+	if len(chatExport.Reminders) > utils.MAX_IMPORT_REMINDERS {
+		return ImportPlan{}, fmt.Errorf("the file has %v reminders, at most %v can be imported at once", len(chatExport.Reminders), utils.MAX_IMPORT_REMINDERS)
+	}
+
+	var plan ImportPlan
+	timezone := chatSettings.Timezone
+	if chatExport.Settings.Timezone != "" && chatExport.Settings.Timezone != chatSettings.Timezone {
+		if _, err := time.LoadLocation(chatExport.Settings.Timezone); err != nil {
+			plan.Skipped = append(plan.Skipped, fmt.Sprintf("settings: unknown timezone %q, keeping %v", chatExport.Settings.Timezone, chatSettings.Timezone))
+		} else {
+			plan.Timezone = chatExport.Settings.Timezone
+			timezone = plan.Timezone
+		}
+	}
+	for i, exportedReminder := range chatExport.Reminders {
+		reminder := schemas.Reminder{
+			ReminderText: exportedReminder.ReminderText,
+			FileId:       exportedReminder.FileId,
+			Time:         exportedReminder.Time,
+			Frequency:    exportedReminder.Frequency,
+		}
+		if err := checkImportedReminder(reminder, timezone); err != nil {
+			plan.Skipped = append(plan.Skipped, fmt.Sprintf("reminder %v: %v", i+1, err))
+			continue
+		}
+		plan.Reminders = append(plan.Reminders, reminder)
+	}
+	return plan, nil
+}
+
+// checkImportedReminder reports why a reminder read from a file cannot be scheduled in the timezone.
+func checkImportedReminder(reminder schemas.Reminder, timezone string) error {
+	if reminder.ReminderText == "" && reminder.FileId == "" {
+		return errors.New("reminder has no text or image")
+	}
+	if !utils.IsValidTime(reminder.Time) {
+		return fmt.Errorf("invalid time %q, expected <HH>:<MM>", reminder.Time)
+	}
+	if err := reminder.Frequency.Validate(); err != nil {
+		return err
+	}
+	nextTriggerTime, err := reminder.CalculateNextTriggerTime(&schemas.ChatSettings{Timezone: timezone})
+	if errors.Is(err, schemas.ErrRecurrenceEnded) {
+		return errors.New("recurrence has no upcoming occurrences left")
+	} else if err != nil {
+		return err
+	}
+	if !nextTriggerTime.After(time.Now()) {
+		return fmt.Errorf("once-off reminder on %v has already passed", reminder.Frequency.Date.Format(utils.PRETTY_DATE_FORMAT))
+	}
+	return nil
+}
+
+// appendImportLines adds a section of the import report, showing at most MAX_IMPORT_LINES_SHOWN entries.
+func appendImportLines(lines []string, title string, entries []string) []string {
+	if len(entries) == 0 {
+		return lines
+	}
+	lines = append(lines, "", title)
+	for i, entry := range entries {
+		if i == utils.MAX_IMPORT_LINES_SHOWN {
+			lines = append(lines, fmt.Sprintf("... and %v more", len(entries)-i))
+			break
+		}
+		lines = append(lines, "- "+html.EscapeString(entry))
+	}
+	return lines
+}
+
+func BuildImportPlanTextAndMarkup(plan ImportPlan, format string, userId int64) (string, tgbotapi.InlineKeyboardMarkup) {
+	var lines []string
+	lines = append(lines, fmt.Sprintf("<b>%v reminders can be imported.</b>", len(plan.Reminders)))
+	if plan.Timezone != "" {
+		lines = append(lines, fmt.Sprintf("The chat timezone will be changed to %v.", html.EscapeString(plan.Timezone)))
+	}
+	var previews []string
+	for _, reminder := range plan.Reminders {
+		text := reminder.ReminderText
+		if text == "" {
+			text = "Image reminder"
+		}
+		preview := fmt.Sprintf("%v (%v at %v)", truncateText(text, utils.IMPORT_TEXT_LENGTH), parseReminderFrequencyToText(reminder), reminder.Time)
+		if reminder.ChatId != 0 {
+			preview += fmt.Sprintf(" in chat %v", reminder.ChatId)
+		}
+		previews = append(previews, preview)
+	}
+	lines = appendImportLines(lines, "Reminders:", previews)
+	lines = appendImportLines(lines, "Imported with changes:", plan.Warnings)
+	lines = appendImportLines(lines, fmt.Sprintf("Not imported (%v):", len(plan.Skipped)), plan.Skipped)
+	blocked := plan.Strict && len(plan.Skipped) > 0
+	if blocked {
+		lines = append(lines, "", "Nothing is imported until every line is valid. Fix these lines and send the file again.")
+	}
+
+	var buttons []tgbotapi.InlineKeyboardButton
+	if len(plan.Reminders) > 0 && !blocked {
+		buttons = append(buttons, tgbotapi.NewInlineKeyboardButtonData(
+			fmt.Sprintf("Import %v reminders", len(plan.Reminders)),
+			GetCallbackImportData(format, utils.CALLBACK_CONFIRM, userId),
+		))
+	}
+	buttons = append(buttons, tgbotapi.NewInlineKeyboardButtonData(
+		"Cancel",
+		GetCallbackImportData(format, utils.CALLBACK_CANCEL, userId),
+	))
+	return strings.Join(lines, "\n"), tgbotapi.NewInlineKeyboardMarkup(buttons)
+}
+
+// ApplyImportPlan creates the planned reminders as the given user, with fresh
+// ids and next trigger times, and returns how many were created.
+func ApplyImportPlan(ctx context.Context, plan ImportPlan, chatSettings *schemas.ChatSettings, user *tgbotapi.User) (int, error) {
+	if plan.Strict && len(plan.Skipped) > 0 {
+		return 0, errors.New("import plan has invalid entries")
+	}
+	if plan.Timezone != "" && plan.Timezone != chatSettings.Timezone {
+		previousChatSettings := *chatSettings
+		chatSettings.Timezone = plan.Timezone
+		err := chatSettings.Update(ctx)
+		if err != nil {
+			return 0, err
+		}
+		RecordAudit(ctx, chatSettings.ChatId, user, utils.AUDIT_UPDATE, utils.AUDIT_ENTITY_CHAT_SETTINGS, fmt.Sprint(chatSettings.ChatId), previousChatSettings, *chatSettings)
+	}
+	created := 0
+	for _, reminder := range plan.Reminders {
+		targetChatSettings := chatSettings
+		if reminder.ChatId != 0 && reminder.ChatId != chatSettings.ChatId {
+			var err error
+			targetChatSettings, err = schemas.GetChatSettings(ctx, reminder.ChatId)
+			if err != nil {
+				return created, err
+			}
+			if targetChatSettings == nil {
+				return created, fmt.Errorf("no chat settings for chat %v", reminder.ChatId)
+			}
+		}
+		reminder.Id = uuid.New().String()
+		reminder.ChatId = targetChatSettings.ChatId
+		reminder.FromUserId = user.ID
+		reminder.InConstruction = false
+		nextTriggerTime, err := reminder.CalculateNextTriggerTime(targetChatSettings)
+		if errors.Is(err, schemas.ErrRecurrenceEnded) {
+			continue
+		} else if err != nil {
+			return created, err
+		}
+		reminder.NextTriggerTime = nextTriggerTime.Format(utils.DIRECTUS_DATETIME_FORMAT)
+		err = reminder.Create(ctx)
+		if err != nil {
+			return created, err
+		}
+		RecordAudit(ctx, reminder.ChatId, user, utils.AUDIT_CREATE, utils.AUDIT_ENTITY_REMINDER, reminder.Id, nil, reminder)
+		created++
+	}
+	return created, nil
+}
@@ -0,0 +1,173 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Jason-CKY/telegram-reminderbot/pkg/schemas"
+	"github.com/Jason-CKY/telegram-reminderbot/pkg/utils"
+)
+
+// exportedReminders are reminders of every frequency, to be created in a chat
+// in Asia/Singapore.
+func exportedReminders(t *testing.T) []schemas.Reminder {
+	t.Helper()
+	nextYear := time.Now().AddDate(1, 0, 0)
+	rrule, err := schemas.NewRRuleRecurrence("FREQ=MONTHLY;BYSETPOS=-1;BYDAY=FR;COUNT=12", "17:00", time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return []schemas.Reminder{
+		{ReminderText: "water the plants", Time: "08:00", Frequency: schemas.NewDailyRecurrence()},
+		{ReminderText: "stand-up; bring notes, please", Time: "09:30", Frequency: schemas.NewWeeklyRecurrence(time.Monday)},
+		{ReminderText: "pay rent", Time: "10:00", Frequency: schemas.NewMonthlyRecurrence(31)},
+		{ReminderText: "anniversary", Time: "19:00", Frequency: schemas.NewYearlyRecurrence(nextYear)},
+		{ReminderText: "renew passport\nbring photos", Time: "11:15", Frequency: schemas.NewOnceRecurrence(nextYear)},
+		{ReminderText: "retro", Time: "17:00", Frequency: rrule},
+		{FileId: "photo-file-id", Time: "12:00", Frequency: schemas.NewDailyRecurrence()},
+	}
+}
+
+func createChatReminders(t *testing.T, chatSettings *schemas.ChatSettings, reminders []schemas.Reminder) {
+	t.Helper()
+	ctx := context.Background()
+	err := chatSettings.Create(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, reminder := range reminders {
+		reminder.Id = fmt.Sprint("exported-", i)
+		reminder.ChatId = chatSettings.ChatId
+		nextTriggerTime, err := reminder.CalculateNextTriggerTime(chatSettings)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reminder.NextTriggerTime = nextTriggerTime.Format(utils.DIRECTUS_DATETIME_FORMAT)
+		err = reminder.Create(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// checkImportedReminders compares the reminders of a plan with the ones
+// exported, in any order.
+func checkImportedReminders(t *testing.T, got []schemas.Reminder, want []schemas.Reminder) {
+	t.Helper()
+	describe := func(reminder schemas.Reminder) string {
+		return fmt.Sprintf("%q %q %v %v", reminder.ReminderText, reminder.FileId, reminder.Time, reminder.Frequency)
+	}
+	remaining := map[string]int{}
+	for _, reminder := range want {
+		remaining[describe(reminder)]++
+	}
+	for _, reminder := range got {
+		if remaining[describe(reminder)] == 0 {
+			t.Errorf("imported unexpected reminder %v", describe(reminder))
+			continue
+		}
+		remaining[describe(reminder)]--
+	}
+	for description, count := range remaining {
+		if count > 0 {
+			t.Errorf("reminder %v was not imported", description)
+		}
+	}
+}
+
+func TestChatExportRoundTrip(t *testing.T) {
+	setupTest(t)
+	ctx := context.Background()
+	chatSettings := &schemas.ChatSettings{ChatId: 1, Timezone: "Asia/Singapore"}
+	reminders := exportedReminders(t)
+	createChatReminders(t, chatSettings, reminders)
+
+	data, err := BuildChatExport(ctx, 1, chatSettings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `"reminder_text": "stand-up; bring notes, please"`) {
+		t.Errorf("export does not keep the text as it is:\n%s", data)
+	}
+
+	plan, err := parseChatExport(data, &schemas.ChatSettings{ChatId: 2, Timezone: "UTC"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan.Skipped) != 0 || len(plan.Warnings) != 0 {
+		t.Errorf("skipped %q with warnings %q", plan.Skipped, plan.Warnings)
+	}
+	if plan.Timezone != "Asia/Singapore" {
+		t.Errorf("timezone is %q, want the exported chat's", plan.Timezone)
+	}
+	checkImportedReminders(t, plan.Reminders, reminders)
+
+	// into a chat in the same timezone, the timezone is left alone
+	plan, err = parseChatExport(data, &schemas.ChatSettings{ChatId: 3, Timezone: "Asia/Singapore"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plan.Timezone != "" {
+		t.Errorf("timezone is %q, want it kept", plan.Timezone)
+	}
+}
+
+func TestParseChatExportRejectsFiles(t *testing.T) {
+	tooMany := `{"version": 1, "reminders": [` + strings.Repeat(`{"reminder_text": "a", "time": "09:00", "frequency": "Daily"},`, utils.MAX_IMPORT_REMINDERS) +
+		`{"reminder_text": "a", "time": "09:00", "frequency": "Daily"}]}`
+	for name, data := range map[string]string{
+		"not json":          "text,time\nhello,09:00",
+		"unknown version":   `{"version": 2, "reminders": []}`,
+		"missing version":   `{"reminders": []}`,
+		"too many":          tooMany,
+		"reminders invalid": `{"version": 1, "reminders": {}}`,
+	} {
+		_, err := parseChatExport([]byte(data), &schemas.ChatSettings{ChatId: 1, Timezone: "UTC"})
+		if err == nil {
+			t.Errorf("%v: file was accepted", name)
+		}
+	}
+}
+
+func TestParseChatExportSkipsInvalidReminders(t *testing.T) {
+	data := `{
+  "version": 1,
+  "settings": {"timezone": "Mars/Olympus_Mons"},
+  "reminders": [
+    {"reminder_text": "valid", "time": "09:00", "frequency": "Daily"},
+    {"reminder_text": "", "time": "09:00", "frequency": "Daily"},
+    {"reminder_text": "bad time", "time": "9am", "frequency": "Daily"},
+    {"reminder_text": "bad frequency", "time": "09:00", "frequency": "Weekly-9"},
+    {"reminder_text": "unknown frequency", "time": "09:00", "frequency": "Hourly"},
+    {"reminder_text": "incomplete", "time": "09:00", "frequency": "Monthly"},
+    {"reminder_text": "passed", "time": "09:00", "frequency": "Once-2001/01/01"},
+    {"reminder_text": "ended", "time": "09:00", "frequency": "RRule-FREQ=DAILY;COUNT=2;DTSTART=20010101"}
+  ]
+}`
+	plan, err := parseChatExport([]byte(data), &schemas.ChatSettings{ChatId: 1, Timezone: "UTC"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan.Reminders) != 1 || plan.Reminders[0].ReminderText != "valid" {
+		t.Errorf("imported %+v, want only the valid reminder", plan.Reminders)
+	}
+	if plan.Timezone != "" {
+		t.Errorf("timezone is %q, want the chat's kept", plan.Timezone)
+	}
+	want := []string{
+		`settings: unknown timezone "Mars/Olympus_Mons", keeping UTC`,
+		"reminder 2: reminder has no text or image",
+		`reminder 3: invalid time "9am", expected <HH>:<MM>`,
+		`reminder 4: invalid frequency "Weekly-9"`,
+		`reminder 5: invalid frequency "Hourly"`,
+		"reminder 6: Monthly frequency is incomplete",
+		"reminder 7: once-off reminder on Mon, 01 Jan 2001 has already passed",
+		"reminder 8: recurrence has no upcoming occurrences left",
+	}
+	if strings.Join(plan.Skipped, "\n") != strings.Join(want, "\n") {
+		t.Errorf("skipped:\n%v\nwant:\n%v", strings.Join(plan.Skipped, "\n"), strings.Join(want, "\n"))
+	}
+}
@@ -3,6 +3,7 @@ package core
 import (
 	"errors"
 	"fmt"
+	"html"
 	"strconv"
 	"strings"
 	"time"
@@ -25,23 +26,24 @@ func GetCallbackListReminderData(action string, step string, page int) string {
 }
 
 func parseReminderFrequencyToText(reminder schemas.Reminder) string {
-	frequencyText := strings.Split(reminder.Frequency, "-")
-	frequency := frequencyText[0]
+	frequency := reminder.Frequency
+	if frequency.Validate() != nil {
+		return ""
+	}
 
-	switch frequency {
+	switch frequency.Kind {
 	case utils.REMINDER_ONCE:
-		reminderTime, _ := time.Parse("2006/01/02 15:04", fmt.Sprintf("%v %v", frequencyText[1], reminder.Time))
-		return reminderTime.Format(utils.PRETTY_DATE_FORMAT)
+		return frequency.Date.Format(utils.PRETTY_DATE_FORMAT)
 	case utils.REMINDER_DAILY:
 		return "every day"
 	case utils.REMINDER_WEEKLY:
-		weekday, _ := strconv.Atoi(frequencyText[1])
-		return fmt.Sprintf("every %v", time.Weekday(weekday))
+		return fmt.Sprintf("every %v", frequency.Weekday)
 	case utils.REMINDER_MONTHLY:
-		return fmt.Sprintf("%v of every month", frequencyText[1])
+		return fmt.Sprintf("%v of every month", frequency.DayOfMonth)
 	case utils.REMINDER_YEARLY:
-		reminderTime, _ := time.Parse("2006/01/02 15:04", fmt.Sprintf("%v %v", frequencyText[1], reminder.Time))
-		return fmt.Sprintf("%v every year", reminderTime.Format(utils.PRETTY_DATE_FORMAT_WITHOUT_YEAR))
+		return fmt.Sprintf("%v every year", frequency.Date.Format(utils.PRETTY_DATE_FORMAT_WITHOUT_YEAR))
+	case utils.REMINDER_RRULE:
+		return frequency.Rule()
 	default:
 		return ""
 	}
@@ -143,6 +145,10 @@ func BuildReminderMenuTextAndMarkup(reminder schemas.Reminder, chatSettings *sch
 	}
 
 	editButtons = append(editButtons,
+		tgbotapi.NewInlineKeyboardButtonData(
+			"History",
+			GetCallbackListReminderData(utils.CALLBACK_HISTORY, reminder.Id, 0),
+		),
 		tgbotapi.NewInlineKeyboardButtonData(
 			"Delete",
 			GetCallbackListReminderData(utils.CALLBACK_DELETE, reminder.Id, 0),
@@ -161,3 +167,42 @@ func BuildReminderMenuTextAndMarkup(reminder schemas.Reminder, chatSettings *sch
 
 	return msgText, replyMarkup, nil
 }
+
+func BuildDeliveryHistoryTextAndMarkup(reminder schemas.Reminder, deliveries []schemas.Delivery, chatSettings *schemas.ChatSettings) (string, tgbotapi.InlineKeyboardMarkup, error) {
+	tz, err := time.LoadLocation(chatSettings.Timezone)
+	if err != nil {
+		return "", tgbotapi.InlineKeyboardMarkup{}, err
+	}
+	msgText := fmt.Sprintf("%v\n\n<b>Last deliveries:</b>\n", html.EscapeString(reminder.ReminderText))
+	if len(deliveries) == 0 {
+		msgText += "This reminder has not fired yet."
+	}
+	for _, delivery := range deliveries {
+		sentTime, err := time.ParseInLocation(utils.DIRECTUS_DATETIME_FORMAT, delivery.SentTime, time.UTC)
+		if err != nil {
+			return "", tgbotapi.InlineKeyboardMarkup{}, err
+		}
+		prefix := "✅"
+		if delivery.Outcome != utils.DELIVERY_SENT {
+			prefix = "❌"
+		}
+		msgText += fmt.Sprintf("%v %v: %v", prefix, sentTime.In(tz).Format(utils.DATE_AND_TIME_FORMAT), delivery.Outcome)
+		if scheduledTime, err := time.ParseInLocation(utils.DIRECTUS_DATETIME_FORMAT, delivery.ScheduledTime, time.UTC); err == nil {
+			msgText += fmt.Sprintf(" (due %v)", scheduledTime.In(tz).Format(utils.DATE_AND_TIME_FORMAT_WITHOUT_YEAR))
+		}
+		if delivery.Error != "" {
+			msgText += fmt.Sprintf("\n<i>%v</i>", html.EscapeString(delivery.Error))
+		}
+		msgText += "\n"
+	}
+
+	replyMarkup := tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(
+				"Back",
+				GetCallbackListReminderData(utils.CALLBACK_SELECT, reminder.Id, 1),
+			),
+		),
+	)
+	return msgText, replyMarkup, nil
+}
@@ -0,0 +1,73 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Jason-CKY/telegram-reminderbot/pkg/schemas"
+	"github.com/Jason-CKY/telegram-reminderbot/pkg/utils"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// errMissedReminderSkipped is recorded as the delivery error of reminders
+// skipped by the utils.MISSED_REMINDERS_SKIP policy.
+var errMissedReminderSkipped = errors.New("missed while the bot was down")
+
+// missedOccurrences returns the occurrences of a reminder from its scheduled
+// trigger time up to now, oldest first, together with how many there were.
+// Only the last utils.MISSED_REMINDER_MAX_LATE occurrences are returned.
+func missedOccurrences(reminder schemas.Reminder, scheduled time.Time, now time.Time, tz *time.Location) ([]time.Time, int) {
+	occurrences := []time.Time{scheduled}
+	total := 1
+	if reminder.Frequency.Kind == utils.REMINDER_ONCE {
+		return occurrences, total
+	}
+	for occurrence := scheduled; total < utils.MISSED_REMINDER_MAX_COUNTED; total++ {
+		next, err := reminder.Frequency.Next(reminder.Time, occurrence, tz)
+		if err != nil || !next.After(occurrence) || next.After(now) {
+			break
+		}
+		occurrence = next
+		occurrences = append(occurrences, occurrence)
+		if len(occurrences) > utils.MISSED_REMINDER_MAX_LATE {
+			occurrences = occurrences[1:]
+		}
+	}
+	return occurrences, total
+}
+
+// formatMissedTime shows the time of a missed occurrence, with its date when
+// it was not today.
+func formatMissedTime(occurrence time.Time, now time.Time, tz *time.Location) string {
+	occurrence, now = occurrence.In(tz), now.In(tz)
+	if occurrence.YearDay() == now.YearDay() && occurrence.Year() == now.Year() {
+		return occurrence.Format("15:04")
+	}
+	return occurrence.Format("02 Jan 15:04")
+}
+
+func BuildMissedRemindersText(chatSettings *schemas.ChatSettings) string {
+	return fmt.Sprintf(
+		"<b>Missed reminders</b>\n\nWhen the bot was down at the time of a reminder, it can send the reminder once even if it was missed several times, send each missed reminder late, or skip it.\n\nCurrently: %v",
+		utils.MISSED_REMINDERS_DESCRIPTIONS[chatSettings.MissedReminderPolicy()],
+	)
+}
+
+func GetCallbackMissedRemindersData(policy string) string {
+	return fmt.Sprintf("mr_%v", policy)
+}
+
+func BuildMissedRemindersMarkup(chatSettings *schemas.ChatSettings) tgbotapi.InlineKeyboardMarkup {
+	var rows [][]tgbotapi.InlineKeyboardButton
+	for _, policy := range []string{utils.MISSED_REMINDERS_COLLAPSE, utils.MISSED_REMINDERS_LATE, utils.MISSED_REMINDERS_SKIP} {
+		label := utils.MISSED_REMINDERS_DESCRIPTIONS[policy]
+		if policy == chatSettings.MissedReminderPolicy() {
+			label = "✅ " + label
+		}
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(label, GetCallbackMissedRemindersData(policy)),
+		))
+	}
+	return tgbotapi.NewInlineKeyboardMarkup(rows...)
+}
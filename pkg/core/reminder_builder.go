@@ -1,6 +1,8 @@
 package core
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"strconv"
 	"time"
@@ -12,7 +14,7 @@ import (
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 )
 
-func BuildReminder(reminderInConstruction *schemas.Reminder, chatSettings *schemas.ChatSettings, update *tgbotapi.Update, bot *tgbotapi.BotAPI) {
+func BuildReminder(ctx context.Context, reminderInConstruction *schemas.Reminder, chatSettings *schemas.ChatSettings, update *tgbotapi.Update, bot *tgbotapi.BotAPI) {
 	if reminderInConstruction.ReminderText == "" && reminderInConstruction.FileId == "" {
 		if len(update.Message.Photo) > 0 {
 			reminderInConstruction.ReminderText = update.Message.Caption
@@ -20,14 +22,14 @@ func BuildReminder(reminderInConstruction *schemas.Reminder, chatSettings *schem
 		} else {
 			reminderInConstruction.ReminderText = update.Message.Text
 		}
-		err := reminderInConstruction.Update()
+		err := reminderInConstruction.Update(ctx)
 		if err != nil {
 			log.Error(err)
 			return
 		}
 		msg := tgbotapi.NewMessage(reminderInConstruction.ChatId, "enter reminder time in <HH>:<MM> format.")
 		msg.ReplyToMessageID = update.Message.MessageID
-		if _, err := bot.Request(msg); err != nil {
+		if _, err := Outbox.Request(ctx, msg, utils.PRIORITY_INTERACTIVE); err != nil {
 			log.Error(err)
 			return
 		}
@@ -36,13 +38,13 @@ func BuildReminder(reminderInConstruction *schemas.Reminder, chatSettings *schem
 		if !utils.IsValidTime(reminderTime) {
 			msg := tgbotapi.NewMessage(reminderInConstruction.ChatId, "Failed to parse time. Please enter time again.")
 			msg.ReplyToMessageID = update.Message.MessageID
-			if _, err := bot.Request(msg); err != nil {
+			if _, err := Outbox.Request(ctx, msg, utils.PRIORITY_INTERACTIVE); err != nil {
 				log.Error(err)
 				return
 			}
 		} else {
 			reminderInConstruction.Time = reminderTime
-			err := reminderInConstruction.Update()
+			err := reminderInConstruction.Update(ctx)
 			if err != nil {
 				log.Error(err)
 				return
@@ -60,21 +62,24 @@ func BuildReminder(reminderInConstruction *schemas.Reminder, chatSettings *schem
 				),
 				tgbotapi.NewKeyboardButtonRow(
 					tgbotapi.NewKeyboardButton(utils.REMINDER_YEARLY),
+					tgbotapi.NewKeyboardButton(utils.REMINDER_RRULE_OPTION),
+				),
+				tgbotapi.NewKeyboardButtonRow(
 					tgbotapi.NewKeyboardButton(utils.CANCEL_MESSAGE),
 				),
 			)
 			keyboard.Selective = true
 			msg.ReplyMarkup = keyboard
-			if _, err := bot.Request(msg); err != nil {
+			if _, err := Outbox.Request(ctx, msg, utils.PRIORITY_INTERACTIVE); err != nil {
 				log.Error(err)
 				return
 			}
 		}
-	} else if reminderInConstruction.Frequency == "" {
+	} else if reminderInConstruction.Frequency.IsZero() {
 		switch update.Message.Text {
 		case utils.REMINDER_ONCE:
-			reminderInConstruction.Frequency = update.Message.Text
-			err := reminderInConstruction.Update()
+			reminderInConstruction.Frequency = schemas.NewPendingRecurrence(update.Message.Text)
+			err := reminderInConstruction.Update(ctx)
 			if err != nil {
 				log.Error(err)
 				return
@@ -83,7 +88,7 @@ func BuildReminder(reminderInConstruction *schemas.Reminder, chatSettings *schem
 			msg := tgbotapi.NewMessage(reminderInConstruction.ChatId, "once-off reminder selected.")
 			msg.ReplyToMessageID = update.Message.MessageID
 			msg.ReplyMarkup = tgbotapi.NewRemoveKeyboard(true)
-			if _, err := bot.Request(msg); err != nil {
+			if _, err := Outbox.Request(ctx, msg, utils.PRIORITY_INTERACTIVE); err != nil {
 				log.Error(err)
 				return
 			}
@@ -103,12 +108,12 @@ func BuildReminder(reminderInConstruction *schemas.Reminder, chatSettings *schem
 				),
 				tz,
 			)
-			if _, err := bot.Request(msg); err != nil {
+			if _, err := Outbox.Request(ctx, msg, utils.PRIORITY_INTERACTIVE); err != nil {
 				log.Error(err)
 				return
 			}
 		case utils.REMINDER_DAILY:
-			reminderInConstruction.Frequency = update.Message.Text
+			reminderInConstruction.Frequency = schemas.NewDailyRecurrence()
 			nextTriggerTime, err := reminderInConstruction.CalculateNextTriggerTime(chatSettings)
 			if err != nil {
 				log.Error(err)
@@ -116,21 +121,22 @@ func BuildReminder(reminderInConstruction *schemas.Reminder, chatSettings *schem
 			}
 			reminderInConstruction.NextTriggerTime = nextTriggerTime.Format(utils.DIRECTUS_DATETIME_FORMAT)
 			reminderInConstruction.InConstruction = false
-			err = reminderInConstruction.Update()
+			err = reminderInConstruction.Update(ctx)
 			if err != nil {
 				log.Error(err)
 				return
 			}
+			RecordAudit(ctx, reminderInConstruction.ChatId, update.Message.From, utils.AUDIT_CREATE, utils.AUDIT_ENTITY_REMINDER, reminderInConstruction.Id, nil, *reminderInConstruction)
 			msg := tgbotapi.NewMessage(reminderInConstruction.ChatId, fmt.Sprintf("✅ Reminder set for every day at %v", reminderInConstruction.Time))
 			msg.ReplyToMessageID = update.Message.MessageID
 			msg.ReplyMarkup = tgbotapi.NewRemoveKeyboard(true)
-			if _, err := bot.Request(msg); err != nil {
+			if _, err := Outbox.Request(ctx, msg, utils.PRIORITY_INTERACTIVE); err != nil {
 				log.Error(err)
 				return
 			}
 		case utils.REMINDER_WEEKLY:
-			reminderInConstruction.Frequency = update.Message.Text
-			err := reminderInConstruction.Update()
+			reminderInConstruction.Frequency = schemas.NewPendingRecurrence(update.Message.Text)
+			err := reminderInConstruction.Update(ctx)
 			if err != nil {
 				log.Error(err)
 				return
@@ -158,13 +164,13 @@ func BuildReminder(reminderInConstruction *schemas.Reminder, chatSettings *schem
 			keyboard.Selective = true
 			msg.ReplyMarkup = keyboard
 			msg.ReplyToMessageID = update.Message.MessageID
-			if _, err := bot.Request(msg); err != nil {
+			if _, err := Outbox.Request(ctx, msg, utils.PRIORITY_INTERACTIVE); err != nil {
 				log.Error(err)
 				return
 			}
 		case utils.REMINDER_MONTHLY:
-			reminderInConstruction.Frequency = update.Message.Text
-			err := reminderInConstruction.Update()
+			reminderInConstruction.Frequency = schemas.NewPendingRecurrence(update.Message.Text)
+			err := reminderInConstruction.Update(ctx)
 			if err != nil {
 				log.Error(err)
 				return
@@ -173,13 +179,13 @@ func BuildReminder(reminderInConstruction *schemas.Reminder, chatSettings *schem
 			msg := tgbotapi.NewMessage(reminderInConstruction.ChatId, "Which day of the month do you want to set your monthly reminder? (1-31)")
 			msg.ReplyToMessageID = update.Message.MessageID
 			msg.ReplyMarkup = tgbotapi.NewRemoveKeyboard(true)
-			if _, err := bot.Request(msg); err != nil {
+			if _, err := Outbox.Request(ctx, msg, utils.PRIORITY_INTERACTIVE); err != nil {
 				log.Error(err)
 				return
 			}
 		case utils.REMINDER_YEARLY:
-			reminderInConstruction.Frequency = update.Message.Text
-			err := reminderInConstruction.Update()
+			reminderInConstruction.Frequency = schemas.NewPendingRecurrence(update.Message.Text)
+			err := reminderInConstruction.Update(ctx)
 			if err != nil {
 				log.Error(err)
 				return
@@ -188,7 +194,7 @@ func BuildReminder(reminderInConstruction *schemas.Reminder, chatSettings *schem
 			msg := tgbotapi.NewMessage(reminderInConstruction.ChatId, "yearly reminder selected.")
 			msg.ReplyToMessageID = update.Message.MessageID
 			msg.ReplyMarkup = tgbotapi.NewRemoveKeyboard(true)
-			if _, err := bot.Request(msg); err != nil {
+			if _, err := Outbox.Request(ctx, msg, utils.PRIORITY_INTERACTIVE); err != nil {
 				log.Error(err)
 				return
 			}
@@ -207,17 +213,84 @@ func BuildReminder(reminderInConstruction *schemas.Reminder, chatSettings *schem
 				),
 				tz,
 			)
-			if _, err := bot.Request(msg); err != nil {
+			if _, err := Outbox.Request(ctx, msg, utils.PRIORITY_INTERACTIVE); err != nil {
+				log.Error(err)
+				return
+			}
+		case utils.REMINDER_RRULE_OPTION:
+			reminderInConstruction.Frequency = schemas.NewPendingRecurrence(utils.REMINDER_RRULE)
+			err := reminderInConstruction.Update(ctx)
+			if err != nil {
+				log.Error(err)
+				return
+			}
+
+			msg := tgbotapi.NewMessage(reminderInConstruction.ChatId, utils.RRULE_BUILDER_MESSAGE)
+			msg.ReplyToMessageID = update.Message.MessageID
+			msg.ReplyMarkup = tgbotapi.NewRemoveKeyboard(true)
+			if _, err := Outbox.Request(ctx, msg, utils.PRIORITY_INTERACTIVE); err != nil {
 				log.Error(err)
 				return
 			}
 		default:
 			return
 		}
-	} else if reminderInConstruction.Frequency == utils.REMINDER_WEEKLY {
+	} else if reminderInConstruction.Frequency.Kind == utils.REMINDER_RRULE && reminderInConstruction.Frequency.IsPending() {
+		tz, err := time.LoadLocation(chatSettings.Timezone)
+		if err != nil {
+			log.Error(err)
+			return
+		}
+		frequency, err := schemas.NewRRuleRecurrence(update.Message.Text, reminderInConstruction.Time, time.Now().In(tz))
+		if err != nil {
+			msg := tgbotapi.NewMessage(reminderInConstruction.ChatId, fmt.Sprintf("%v. Please enter the RRULE again.", err))
+			msg.ReplyToMessageID = update.Message.MessageID
+			if _, err := Outbox.Request(ctx, msg, utils.PRIORITY_INTERACTIVE); err != nil {
+				log.Error(err)
+				return
+			}
+			return
+		}
+		reminderInConstruction.Frequency = frequency
+		nextTriggerTime, err := reminderInConstruction.CalculateNextTriggerTime(chatSettings)
+		if errors.Is(err, schemas.ErrRecurrenceEnded) {
+			msg := tgbotapi.NewMessage(reminderInConstruction.ChatId, "This RRULE has no upcoming occurrences. Please enter the RRULE again.")
+			msg.ReplyToMessageID = update.Message.MessageID
+			if _, err := Outbox.Request(ctx, msg, utils.PRIORITY_INTERACTIVE); err != nil {
+				log.Error(err)
+				return
+			}
+			return
+		} else if err != nil {
+			log.Error(err)
+			return
+		}
+		reminderInConstruction.NextTriggerTime = nextTriggerTime.Format(utils.DIRECTUS_DATETIME_FORMAT)
+		reminderInConstruction.InConstruction = false
+		err = reminderInConstruction.Update(ctx)
+		if err != nil {
+			log.Error(err)
+			return
+		}
+		RecordAudit(ctx, reminderInConstruction.ChatId, update.Message.From, utils.AUDIT_CREATE, utils.AUDIT_ENTITY_REMINDER, reminderInConstruction.Id, nil, *reminderInConstruction)
+		msg := tgbotapi.NewMessage(
+			reminderInConstruction.ChatId,
+			fmt.Sprintf(
+				"✅ Reminder set for %v at %v, next on %v",
+				frequency.Rule(),
+				reminderInConstruction.Time,
+				nextTriggerTime.In(tz).Format(utils.PRETTY_DATE_FORMAT),
+			),
+		)
+		msg.ReplyToMessageID = update.Message.MessageID
+		if _, err := Outbox.Request(ctx, msg, utils.PRIORITY_INTERACTIVE); err != nil {
+			log.Error(err)
+			return
+		}
+	} else if reminderInConstruction.Frequency.Kind == utils.REMINDER_WEEKLY && reminderInConstruction.Frequency.IsPending() {
 		val, ok := utils.DAY_OF_WEEK[update.Message.Text]
 		if ok {
-			reminderInConstruction.Frequency = fmt.Sprintf("%v-%v", utils.REMINDER_WEEKLY, val)
+			reminderInConstruction.Frequency = schemas.NewWeeklyRecurrence(time.Weekday(val))
 			nextTriggerTime, err := reminderInConstruction.CalculateNextTriggerTime(chatSettings)
 			if err != nil {
 				log.Error(err)
@@ -225,11 +298,12 @@ func BuildReminder(reminderInConstruction *schemas.Reminder, chatSettings *schem
 			}
 			reminderInConstruction.NextTriggerTime = nextTriggerTime.Format(utils.DIRECTUS_DATETIME_FORMAT)
 			reminderInConstruction.InConstruction = false
-			err = reminderInConstruction.Update()
+			err = reminderInConstruction.Update(ctx)
 			if err != nil {
 				log.Error(err)
 				return
 			}
+			RecordAudit(ctx, reminderInConstruction.ChatId, update.Message.From, utils.AUDIT_CREATE, utils.AUDIT_ENTITY_REMINDER, reminderInConstruction.Id, nil, *reminderInConstruction)
 			msg := tgbotapi.NewMessage(
 				reminderInConstruction.ChatId,
 				fmt.Sprintf(
@@ -240,18 +314,18 @@ func BuildReminder(reminderInConstruction *schemas.Reminder, chatSettings *schem
 			)
 			msg.ReplyToMessageID = update.Message.MessageID
 			msg.ReplyMarkup = tgbotapi.NewRemoveKeyboard(true)
-			if _, err := bot.Request(msg); err != nil {
+			if _, err := Outbox.Request(ctx, msg, utils.PRIORITY_INTERACTIVE); err != nil {
 				log.Error(err)
 				return
 			}
 		}
-	} else if reminderInConstruction.Frequency == utils.REMINDER_MONTHLY {
+	} else if reminderInConstruction.Frequency.Kind == utils.REMINDER_MONTHLY && reminderInConstruction.Frequency.IsPending() {
 		day_of_month, err := strconv.Atoi(update.Message.Text)
 		if err != nil {
 			return
 		}
 		if day_of_month >= 1 && day_of_month <= 31 {
-			reminderInConstruction.Frequency = fmt.Sprintf("%v-%v", utils.REMINDER_MONTHLY, day_of_month)
+			reminderInConstruction.Frequency = schemas.NewMonthlyRecurrence(day_of_month)
 			nextTriggerTime, err := reminderInConstruction.CalculateNextTriggerTime(chatSettings)
 			if err != nil {
 				log.Error(err)
@@ -259,11 +333,12 @@ func BuildReminder(reminderInConstruction *schemas.Reminder, chatSettings *schem
 			}
 			reminderInConstruction.NextTriggerTime = nextTriggerTime.Format(utils.DIRECTUS_DATETIME_FORMAT)
 			reminderInConstruction.InConstruction = false
-			err = reminderInConstruction.Update()
+			err = reminderInConstruction.Update(ctx)
 			if err != nil {
 				log.Error(err)
 				return
 			}
+			RecordAudit(ctx, reminderInConstruction.ChatId, update.Message.From, utils.AUDIT_CREATE, utils.AUDIT_ENTITY_REMINDER, reminderInConstruction.Id, nil, *reminderInConstruction)
 			msg := tgbotapi.NewMessage(
 				reminderInConstruction.ChatId,
 				fmt.Sprintf(
@@ -273,14 +348,14 @@ func BuildReminder(reminderInConstruction *schemas.Reminder, chatSettings *schem
 				),
 			)
 			msg.ReplyToMessageID = update.Message.MessageID
-			if _, err := bot.Request(msg); err != nil {
+			if _, err := Outbox.Request(ctx, msg, utils.PRIORITY_INTERACTIVE); err != nil {
 				log.Error(err)
 				return
 			}
 		} else {
 			msg := tgbotapi.NewMessage(reminderInConstruction.ChatId, "Invalid day of month [1-31]")
 			msg.ReplyToMessageID = update.Message.MessageID
-			if _, err := bot.Request(msg); err != nil {
+			if _, err := Outbox.Request(ctx, msg, utils.PRIORITY_INTERACTIVE); err != nil {
 				log.Error(err)
 				return
 			}
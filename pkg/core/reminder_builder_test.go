@@ -0,0 +1,108 @@
+package core
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Jason-CKY/telegram-reminderbot/pkg/schemas"
+	"github.com/Jason-CKY/telegram-reminderbot/pkg/utils"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// buildStep answers the reminder being built in chat 10 with text, the way
+// the handler does for every message during /remind.
+func buildStep(t *testing.T, bot *tgbotapi.BotAPI, chatSettings *schemas.ChatSettings, text string) *schemas.Reminder {
+	t.Helper()
+	ctx := context.Background()
+	reminder, err := schemas.GetReminderInConstruction(ctx, 10, 20)
+	if err != nil || reminder == nil {
+		t.Fatalf("no reminder in construction: %v", err)
+	}
+	update := &tgbotapi.Update{Message: &tgbotapi.Message{
+		MessageID: 1,
+		From:      &tgbotapi.User{ID: 20},
+		Chat:      &tgbotapi.Chat{ID: 10},
+		Text:      text,
+	}}
+	BuildReminder(ctx, reminder, chatSettings, update, bot)
+	built, err := schemas.GetReminderById(ctx, reminder.Id)
+	if err != nil || built == nil {
+		t.Fatalf("reminder is gone after %q: %v", text, err)
+	}
+	return built
+}
+
+func startBuilding(t *testing.T) *schemas.ChatSettings {
+	t.Helper()
+	ctx := context.Background()
+	chatSettings := &schemas.ChatSettings{ChatId: 10, Timezone: "Asia/Singapore"}
+	err := chatSettings.Create(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = schemas.Reminder{Id: "built", ChatId: 10, FromUserId: 20, InConstruction: true}.Create(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return chatSettings
+}
+
+func TestBuildDailyReminder(t *testing.T) {
+	bot, telegram := setupTest(t)
+	chatSettings := startBuilding(t)
+
+	reminder := buildStep(t, bot, chatSettings, "drink water")
+	if reminder.ReminderText != "drink water" || !reminder.InConstruction {
+		t.Errorf("after the text got %+v", reminder)
+	}
+	reminder = buildStep(t, bot, chatSettings, "25:00")
+	if reminder.Time != "" {
+		t.Errorf("invalid time %q was accepted", reminder.Time)
+	}
+	reminder = buildStep(t, bot, chatSettings, "09:30")
+	if reminder.Time != "09:30" {
+		t.Errorf("time is %q, want 09:30", reminder.Time)
+	}
+	reminder = buildStep(t, bot, chatSettings, utils.REMINDER_DAILY)
+	if reminder.InConstruction || reminder.Frequency.Kind != utils.REMINDER_DAILY {
+		t.Fatalf("daily reminder was not completed: %+v", reminder)
+	}
+
+	tz, _ := time.LoadLocation(chatSettings.Timezone)
+	next, err := time.Parse(utils.DIRECTUS_DATETIME_FORMAT, reminder.NextTriggerTime)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if local := next.In(tz); local.Format("15:04") != "09:30" || !next.After(time.Now()) || next.After(time.Now().Add(24*time.Hour)) {
+		t.Errorf("next trigger time is %v, want the next 09:30 in %v", local, tz)
+	}
+	if texts := telegram.sent(10); len(texts) != 4 || texts[3] != "✅ Reminder set for every day at 09:30" {
+		t.Errorf("replies were %q", texts)
+	}
+}
+
+func TestBuildWeeklyReminder(t *testing.T) {
+	bot, _ := setupTest(t)
+	chatSettings := startBuilding(t)
+
+	buildStep(t, bot, chatSettings, "stand up")
+	buildStep(t, bot, chatSettings, "10:00")
+	reminder := buildStep(t, bot, chatSettings, utils.REMINDER_WEEKLY)
+	if !reminder.Frequency.IsPending() || !reminder.InConstruction {
+		t.Fatalf("weekly reminder should wait for its day: %+v", reminder)
+	}
+	reminder = buildStep(t, bot, chatSettings, time.Wednesday.String())
+	if reminder.InConstruction || reminder.Frequency.Weekday != time.Wednesday {
+		t.Fatalf("weekly reminder was not completed: %+v", reminder)
+	}
+
+	tz, _ := time.LoadLocation(chatSettings.Timezone)
+	next, err := time.Parse(utils.DIRECTUS_DATETIME_FORMAT, reminder.NextTriggerTime)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if local := next.In(tz); local.Weekday() != time.Wednesday || local.Format("15:04") != "10:00" {
+		t.Errorf("next trigger time is %v, want a Wednesday at 10:00", local)
+	}
+}
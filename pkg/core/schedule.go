@@ -0,0 +1,208 @@
+package core
+
+import (
+	"container/heap"
+	"context"
+	"sync"
+	"time"
+
+	"github.com/Jason-CKY/telegram-reminderbot/pkg/schemas"
+	"github.com/Jason-CKY/telegram-reminderbot/pkg/utils"
+)
+
+type scheduledReminder struct {
+	id          string
+	triggerTime time.Time
+	index       int
+}
+
+// reminderHeap is a min-heap of reminders by trigger time, for container/heap.
+type reminderHeap []*scheduledReminder
+
+func (h reminderHeap) Len() int           { return len(h) }
+func (h reminderHeap) Less(i, j int) bool { return h[i].triggerTime.Before(h[j].triggerTime) }
+func (h reminderHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *reminderHeap) Push(x any) {
+	item := x.(*scheduledReminder)
+	item.index = len(*h)
+	*h = append(*h, item)
+}
+
+func (h *reminderHeap) Pop() any {
+	old := *h
+	item := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	return item
+}
+
+// Schedule keeps the reminders that are due before its horizon, so the
+// scheduler can sleep until the earliest of them instead of polling the store.
+// It is filled from the store by Reconcile, and kept up to date in between by
+// reminder change events.
+type Schedule struct {
+	mu      sync.Mutex
+	heap    reminderHeap
+	byId    map[string]*scheduledReminder
+	horizon time.Time
+	// changed wakes up the scheduler when the earliest trigger time may have moved
+	changed chan struct{}
+	resync  bool
+	// pending records the changes made while Reconcile reads the store, to be
+	// applied over what it read. A zero time means the reminder was removed.
+	pending map[string]time.Time
+}
+
+func NewSchedule() *Schedule {
+	return &Schedule{
+		byId:    map[string]*scheduledReminder{},
+		changed: make(chan struct{}, 1),
+	}
+}
+
+// reminderSchedule is the schedule run by ScheduledReminderTrigger.
+var reminderSchedule = NewSchedule()
+
+func (schedule *Schedule) notify() {
+	select {
+	case schedule.changed <- struct{}{}:
+	default:
+		// a wakeup is already pending
+	}
+}
+
+// Set schedules a reminder at its next trigger time, or takes it off the
+// schedule if it is not due before the horizon.
+func (schedule *Schedule) Set(reminder schemas.Reminder) {
+	var triggerTime time.Time
+	if !reminder.InConstruction && reminder.NextTriggerTime != "" {
+		parsed, err := time.Parse(utils.DIRECTUS_DATETIME_FORMAT, reminder.NextTriggerTime)
+		if err == nil {
+			triggerTime = parsed
+		}
+	}
+	schedule.mu.Lock()
+	defer schedule.mu.Unlock()
+	if schedule.pending != nil {
+		schedule.pending[reminder.Id] = triggerTime
+	}
+	schedule.set(reminder.Id, triggerTime)
+	schedule.notify()
+}
+
+func (schedule *Schedule) Remove(id string) {
+	schedule.mu.Lock()
+	defer schedule.mu.Unlock()
+	if schedule.pending != nil {
+		schedule.pending[id] = time.Time{}
+	}
+	schedule.set(id, time.Time{})
+}
+
+func (schedule *Schedule) set(id string, triggerTime time.Time) {
+	item, scheduled := schedule.byId[id]
+	if triggerTime.IsZero() || !triggerTime.Before(schedule.horizon) {
+		if scheduled {
+			heap.Remove(&schedule.heap, item.index)
+			delete(schedule.byId, id)
+		}
+		return
+	}
+	if scheduled {
+		item.triggerTime = triggerTime
+		heap.Fix(&schedule.heap, item.index)
+		return
+	}
+	item = &scheduledReminder{id: id, triggerTime: triggerTime}
+	heap.Push(&schedule.heap, item)
+	schedule.byId[id] = item
+}
+
+// Resync asks the scheduler to reload the schedule from the store.
+func (schedule *Schedule) Resync() {
+	schedule.mu.Lock()
+	defer schedule.mu.Unlock()
+	schedule.resync = true
+	schedule.notify()
+}
+
+func (schedule *Schedule) takeResync() bool {
+	schedule.mu.Lock()
+	defer schedule.mu.Unlock()
+	resync := schedule.resync
+	schedule.resync = false
+	return resync
+}
+
+// Reconcile replaces the schedule with the reminders the store has due before
+// now plus utils.SCHEDULE_HORIZON. This catches changes that were not
+// published, such as those made by other instances of the bot.
+func (schedule *Schedule) Reconcile(ctx context.Context, now time.Time) error {
+	horizon := now.Add(utils.SCHEDULE_HORIZON)
+	schedule.mu.Lock()
+	schedule.pending = map[string]time.Time{}
+	schedule.mu.Unlock()
+
+	var reminders []schemas.Reminder
+	cursor := ""
+	for {
+		page, err := schemas.GetDueRemindersPage(ctx, horizon, cursor, utils.DUE_REMINDERS_BATCH_SIZE)
+		if err != nil {
+			schedule.mu.Lock()
+			schedule.pending = nil
+			schedule.mu.Unlock()
+			return err
+		}
+		reminders = append(reminders, page...)
+		if len(page) < utils.DUE_REMINDERS_BATCH_SIZE {
+			break
+		}
+		cursor = page[len(page)-1].Id
+	}
+
+	schedule.mu.Lock()
+	defer schedule.mu.Unlock()
+	schedule.heap = nil
+	schedule.byId = map[string]*scheduledReminder{}
+	schedule.horizon = horizon
+	for _, reminder := range reminders {
+		triggerTime, err := time.Parse(utils.DIRECTUS_DATETIME_FORMAT, reminder.NextTriggerTime)
+		if err == nil {
+			schedule.set(reminder.Id, triggerTime)
+		}
+	}
+	for id, triggerTime := range schedule.pending {
+		schedule.set(id, triggerTime)
+	}
+	schedule.pending = nil
+	return nil
+}
+
+// next returns the earliest trigger time on the schedule.
+func (schedule *Schedule) next() (time.Time, bool) {
+	schedule.mu.Lock()
+	defer schedule.mu.Unlock()
+	if len(schedule.heap) == 0 {
+		return time.Time{}, false
+	}
+	return schedule.heap[0].triggerTime, true
+}
+
+// popDue takes the reminders due at now off the schedule and returns how many
+// there were. Once fired they are put back by the update that reschedules them.
+func (schedule *Schedule) popDue(now time.Time) int {
+	schedule.mu.Lock()
+	defer schedule.mu.Unlock()
+	due := 0
+	for len(schedule.heap) > 0 && !schedule.heap[0].triggerTime.After(now) {
+		item := heap.Pop(&schedule.heap).(*scheduledReminder)
+		delete(schedule.byId, item.id)
+		due++
+	}
+	return due
+}
@@ -0,0 +1,210 @@
+package core
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Jason-CKY/telegram-reminderbot/pkg/schemas"
+	"github.com/Jason-CKY/telegram-reminderbot/pkg/utils"
+)
+
+func testReminder(id string, triggerTime time.Time) schemas.Reminder {
+	return schemas.Reminder{
+		Id:              id,
+		ChatId:          1,
+		Frequency:       schemas.NewDailyRecurrence(),
+		Time:            triggerTime.UTC().Format("15:04"),
+		NextTriggerTime: triggerTime.UTC().Format(utils.DIRECTUS_DATETIME_FORMAT),
+	}
+}
+
+// emptySchedule returns a schedule reconciled against an empty store, so that
+// its horizon is set.
+func emptySchedule(t *testing.T, now time.Time) *Schedule {
+	t.Helper()
+	setupTest(t)
+	schedule := NewSchedule()
+	err := schedule.Reconcile(context.Background(), now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return schedule
+}
+
+func checkNext(t *testing.T, schedule *Schedule, want time.Time) {
+	t.Helper()
+	next, ok := schedule.next()
+	if want.IsZero() {
+		if ok {
+			t.Errorf("next trigger time is %v, want an empty schedule", next)
+		}
+		return
+	}
+	if !ok || !next.Equal(want) {
+		t.Errorf("next trigger time is %v (scheduled %v), want %v", next, ok, want)
+	}
+}
+
+func TestScheduleSetAndPopDue(t *testing.T) {
+	now := time.Now().UTC().Truncate(time.Second)
+	schedule := emptySchedule(t, now)
+
+	schedule.Set(testReminder("later", now.Add(30*time.Second)))
+	schedule.Set(testReminder("due", now.Add(-time.Second)))
+	schedule.Set(testReminder("due-now", now))
+	checkNext(t, schedule, now.Add(-time.Second))
+
+	if due := schedule.popDue(now); due != 2 {
+		t.Errorf("popDue returned %v, want 2", due)
+	}
+	checkNext(t, schedule, now.Add(30*time.Second))
+	if due := schedule.popDue(now); due != 0 {
+		t.Errorf("popDue returned %v after the due reminders were taken, want 0", due)
+	}
+}
+
+func TestScheduleSetMovesAndDropsReminders(t *testing.T) {
+	now := time.Now().UTC().Truncate(time.Second)
+	schedule := emptySchedule(t, now)
+
+	schedule.Set(testReminder("moved", now.Add(time.Minute)))
+	schedule.Set(testReminder("other", now.Add(30*time.Second)))
+	schedule.Set(testReminder("moved", now.Add(10*time.Second)))
+	checkNext(t, schedule, now.Add(10*time.Second))
+
+	// rescheduled past the horizon, it is left to a later reconciliation
+	schedule.Set(testReminder("moved", now.Add(utils.SCHEDULE_HORIZON+time.Second)))
+	checkNext(t, schedule, now.Add(30*time.Second))
+
+	inConstruction := testReminder("other", now.Add(30*time.Second))
+	inConstruction.InConstruction = true
+	schedule.Set(inConstruction)
+	checkNext(t, schedule, time.Time{})
+}
+
+func TestScheduleRemove(t *testing.T) {
+	now := time.Now().UTC().Truncate(time.Second)
+	schedule := emptySchedule(t, now)
+
+	schedule.Set(testReminder("first", now.Add(10*time.Second)))
+	schedule.Set(testReminder("second", now.Add(20*time.Second)))
+	schedule.Remove("first")
+	checkNext(t, schedule, now.Add(20*time.Second))
+	schedule.Remove("unknown")
+	schedule.Remove("second")
+	checkNext(t, schedule, time.Time{})
+}
+
+func TestScheduleReconcile(t *testing.T) {
+	setupTest(t)
+	ctx := context.Background()
+	now := time.Now().UTC().Truncate(time.Second)
+	for _, reminder := range []schemas.Reminder{
+		testReminder("overdue", now.Add(-time.Hour)),
+		testReminder("soon", now.Add(time.Minute)),
+		testReminder("beyond-horizon", now.Add(utils.SCHEDULE_HORIZON+time.Minute)),
+	} {
+		if err := reminder.Create(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	inConstruction := testReminder("in-construction", now.Add(-time.Minute))
+	inConstruction.InConstruction = true
+	if err := inConstruction.Create(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	schedule := NewSchedule()
+	schedule.horizon = now.Add(utils.SCHEDULE_HORIZON)
+	schedule.Set(testReminder("gone-from-store", now.Add(-2*time.Hour)))
+	err := schedule.Reconcile(ctx, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkNext(t, schedule, now.Add(-time.Hour))
+	if due := schedule.popDue(now); due != 1 {
+		t.Errorf("popDue returned %v, want only the overdue reminder from the store", due)
+	}
+	checkNext(t, schedule, now.Add(time.Minute))
+	if due := schedule.popDue(now.Add(utils.SCHEDULE_HORIZON + time.Hour)); due != 1 {
+		t.Errorf("popDue returned %v, want the reminders within the horizon only", due)
+	}
+}
+
+// changingStore changes the schedule while Reconcile reads the store, as a
+// change event arriving at that moment would.
+type changingStore struct {
+	schemas.ReminderStore
+	change func()
+}
+
+func (store *changingStore) GetDueReminders(ctx context.Context, before time.Time, cursor string, limit int) ([]schemas.Reminder, error) {
+	reminders, err := store.ReminderStore.GetDueReminders(ctx, before, cursor, limit)
+	if store.change != nil {
+		store.change()
+		store.change = nil
+	}
+	return reminders, err
+}
+
+func TestScheduleReconcileKeepsConcurrentChanges(t *testing.T) {
+	setupTest(t)
+	ctx := context.Background()
+	now := time.Now().UTC().Truncate(time.Second)
+	for _, reminder := range []schemas.Reminder{
+		testReminder("moved", now.Add(time.Minute)),
+		testReminder("removed", now.Add(10*time.Second)),
+	} {
+		if err := reminder.Create(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	schedule := NewSchedule()
+	schemas.Store = &changingStore{ReminderStore: schemas.Store, change: func() {
+		schedule.Set(testReminder("moved", now.Add(20*time.Second)))
+		schedule.Remove("removed")
+	}}
+
+	err := schedule.Reconcile(ctx, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the store was read before the changes, they win over what it returned
+	checkNext(t, schedule, now.Add(20*time.Second))
+	if due := schedule.popDue(now.Add(utils.SCHEDULE_HORIZON)); due != 1 {
+		t.Errorf("popDue returned %v, want only the moved reminder", due)
+	}
+}
+
+func TestScheduleFollowsPublishedChanges(t *testing.T) {
+	setupTest(t)
+	ctx := context.Background()
+	now := time.Now().UTC().Truncate(time.Second)
+	schedule := reminderSchedule
+	t.Cleanup(func() { reminderSchedule = schedule })
+	reminderSchedule = NewSchedule()
+	bus := &schemas.EventBus{}
+	bus.Subscribe(HandleChangeEvent)
+	schemas.Store = schemas.NewPublishingStore(schemas.Store, bus)
+	err := reminderSchedule.Reconcile(ctx, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the bot's own writes reach the schedule without reading the store again
+	reminder := testReminder("published", now.Add(time.Minute))
+	if err := reminder.Create(ctx); err != nil {
+		t.Fatal(err)
+	}
+	checkNext(t, reminderSchedule, now.Add(time.Minute))
+	reminder.NextTriggerTime = now.Add(10 * time.Second).Format(utils.DIRECTUS_DATETIME_FORMAT)
+	if err := reminder.Update(ctx); err != nil {
+		t.Fatal(err)
+	}
+	checkNext(t, reminderSchedule, now.Add(10*time.Second))
+	if err := reminder.Delete(ctx); err != nil {
+		t.Fatal(err)
+	}
+	checkNext(t, reminderSchedule, time.Time{})
+}
@@ -1,155 +1,315 @@
 package core
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
-	"strings"
 	"sync"
 	"time"
 
 	"github.com/Jason-CKY/telegram-reminderbot/pkg/schemas"
 	"github.com/Jason-CKY/telegram-reminderbot/pkg/utils"
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/google/uuid"
 	log "github.com/sirupsen/logrus"
 )
 
-func HandleErrorSendingReminder(reminder schemas.Reminder) error {
+// HandleErrorSendingReminder gives up on a reminder that could not be sent for
+// a day. Recurring reminders move on to their next occurrence, and the others
+// are deleted.
+func HandleErrorSendingReminder(ctx context.Context, reminder schemas.Reminder, chatSettings *schemas.ChatSettings) error {
 	reminderTriggerTime, err := time.ParseInLocation(utils.DIRECTUS_DATETIME_FORMAT, reminder.NextTriggerTime, time.UTC)
 	if err != nil {
 		return err
 	}
 	if reminderTriggerTime.Add(24 * time.Hour).Before(time.Now()) {
-		err = reminder.Delete()
-		if err != nil {
-			return err
-		}
+		return rescheduleReminder(ctx, reminder, chatSettings)
 	}
 	return nil
 }
 
-func TriggerReminder(reminder schemas.Reminder, bot *tgbotapi.BotAPI) {
-	chatSettings, _, err := schemas.InsertChatSettingsIfNotPresent(reminder.ChatId)
+// RecordDelivery stores the outcome of sending a reminder, for its delivery history.
+func RecordDelivery(ctx context.Context, reminder schemas.Reminder, res *tgbotapi.APIResponse, sendErr error) {
+	delivery := schemas.Delivery{
+		Id:            uuid.New().String(),
+		ReminderId:    reminder.Id,
+		ChatId:        reminder.ChatId,
+		ScheduledTime: reminder.NextTriggerTime,
+		SentTime:      time.Now().UTC().Format(utils.DIRECTUS_DATETIME_FORMAT),
+		Outcome:       utils.DELIVERY_SENT,
+	}
+	if errors.Is(sendErr, errMissedReminderSkipped) {
+		delivery.Outcome = utils.DELIVERY_SKIPPED
+		delivery.Error = sendErr.Error()
+	} else if sendErr != nil {
+		delivery.Outcome = utils.DELIVERY_FAILED
+		if res != nil && res.ErrorCode == 403 {
+			delivery.Outcome = utils.DELIVERY_BLOCKED
+		}
+		delivery.Error = sendErr.Error()
+	} else if res != nil {
+		var message tgbotapi.Message
+		if err := json.Unmarshal(res.Result, &message); err == nil {
+			delivery.MessageId = message.MessageID
+		}
+	}
+	err := delivery.Create(ctx)
+	if err != nil {
+		log.Error(err)
+	}
+}
+
+// TriggerReminder sends a reminder and reschedules it. The error is only set
+// when the store could not be reached, and the reminder is then left to be
+// fired again once its lease expires.
+func TriggerReminder(ctx context.Context, reminder schemas.Reminder, bot *tgbotapi.BotAPI) error {
+	chatSettings, _, err := schemas.InsertChatSettingsIfNotPresent(ctx, reminder.ChatId)
+	if err != nil {
+		return err
+	}
+
+	// a reminder with a malformed frequency can't be rescheduled, so park it instead of firing it on every poll
+	if err := reminder.Frequency.Validate(); err != nil {
+		log.Errorf("reminder %v has an invalid frequency, unscheduling it: %v", reminder.Id, err)
+		RecordDelivery(ctx, reminder, nil, err)
+		reminder.NextTriggerTime = ""
+		err = reminder.Update(ctx)
+		if err != nil {
+			log.Error(err)
+		}
+		return nil
+	}
+
+	tz, err := time.LoadLocation(chatSettings.Timezone)
 	if err != nil {
-		panic(err)
+		log.Error(err)
+		tz = time.UTC
+	}
+	prefix := utils.REMINDER_PREFIX
+	// set when older missed occurrences are sent one by one, to the occurrence after this one
+	var nextMissed time.Time
+	now := time.Now()
+	scheduled, err := time.ParseInLocation(utils.DIRECTUS_DATETIME_FORMAT, reminder.NextTriggerTime, time.UTC)
+	if err == nil && now.Sub(scheduled) > utils.MISSED_REMINDER_GRACE {
+		occurrences, total := missedOccurrences(reminder, scheduled, now, tz)
+		switch chatSettings.MissedReminderPolicy() {
+		case utils.MISSED_REMINDERS_SKIP:
+			log.Infof("skipping reminder %v, missed %v times since %v", reminder.Id, total, reminder.NextTriggerTime)
+			RecordDelivery(ctx, reminder, nil, errMissedReminderSkipped)
+			return rescheduleReminder(ctx, reminder, chatSettings)
+		case utils.MISSED_REMINDERS_LATE:
+			prefix = fmt.Sprintf("⏰ Missed at %v\n%v", formatMissedTime(occurrences[0], now, tz), prefix)
+			if len(occurrences) > 1 {
+				nextMissed = occurrences[1]
+			}
+		default:
+			if total > 1 {
+				prefix = fmt.Sprintf("⏰ Missed %v times, last at %v\n%v", total, formatMissedTime(occurrences[len(occurrences)-1], now, tz), prefix)
+			} else {
+				prefix = fmt.Sprintf("⏰ Missed at %v\n%v", formatMissedTime(scheduled, now, tz), prefix)
+			}
+		}
 	}
 
+	markup := tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("15m", utils.RENEW_REMINDER_15M),
+			tgbotapi.NewInlineKeyboardButtonData("30m", utils.RENEW_REMINDER_30M),
+			tgbotapi.NewInlineKeyboardButtonData("1h", utils.RENEW_REMINDER_1H),
+			tgbotapi.NewInlineKeyboardButtonData("3h", utils.RENEW_REMINDER_3H),
+			tgbotapi.NewInlineKeyboardButtonData("1d", utils.RENEW_REMINDER_1D),
+		),
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("Enter Time", utils.RENEW_REMINDER_CUSTOM),
+			tgbotapi.NewInlineKeyboardButtonData("Cancel", utils.RENEW_REMINDER_CANCEL),
+		),
+	)
+	var reminderMsg tgbotapi.Chattable
 	if reminder.FileId != "" {
 		photo_msg := tgbotapi.NewPhoto(
 			reminder.ChatId,
 			tgbotapi.FileID(reminder.FileId),
 		)
 		if reminder.ReminderText != "" {
-			photo_msg.Caption = fmt.Sprintf("%v%v%v", utils.REMINDER_PREFIX, reminder.ReminderText, utils.RENEW_REMINDER_TEXT)
+			photo_msg.Caption = fmt.Sprintf("%v%v%v", prefix, reminder.ReminderText, utils.RENEW_REMINDER_TEXT)
 		} else {
-			photo_msg.Caption = fmt.Sprintf("%v%v", utils.REMINDER_PREFIX, utils.RENEW_REMINDER_TEXT)
-		}
-		photo_msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(
-			tgbotapi.NewInlineKeyboardRow(
-				tgbotapi.NewInlineKeyboardButtonData("15m", utils.RENEW_REMINDER_15M),
-				tgbotapi.NewInlineKeyboardButtonData("30m", utils.RENEW_REMINDER_30M),
-				tgbotapi.NewInlineKeyboardButtonData("1h", utils.RENEW_REMINDER_1H),
-				tgbotapi.NewInlineKeyboardButtonData("3h", utils.RENEW_REMINDER_3H),
-				tgbotapi.NewInlineKeyboardButtonData("1d", utils.RENEW_REMINDER_1D),
-			),
-			tgbotapi.NewInlineKeyboardRow(
-				tgbotapi.NewInlineKeyboardButtonData("Enter Time", utils.RENEW_REMINDER_CUSTOM),
-				tgbotapi.NewInlineKeyboardButtonData("Cancel", utils.RENEW_REMINDER_CANCEL),
-			),
-		)
-		if res, err := bot.Request(photo_msg); err != nil {
-			log.Error(err)
-			// Check if user has blocked the bot (Forbidden error)
-			if res.ErrorCode == 403 {
-				log.Warnf("User %d has blocked the bot. Deleting reminder.", reminder.ChatId)
-				delErr := reminder.Delete()
-				if delErr != nil {
-					log.Error(delErr)
-				}
-				return
-			}
-			err = HandleErrorSendingReminder(reminder)
-			if err != nil {
-				log.Error(err)
-			}
-			return
+			photo_msg.Caption = fmt.Sprintf("%v%v", prefix, utils.RENEW_REMINDER_TEXT)
 		}
+		photo_msg.ReplyMarkup = markup
+		reminderMsg = photo_msg
 	} else {
 		msg := tgbotapi.NewMessage(
 			reminder.ChatId,
-			fmt.Sprintf("%v%v%v", utils.REMINDER_PREFIX, reminder.ReminderText, utils.RENEW_REMINDER_TEXT),
+			fmt.Sprintf("%v%v%v", prefix, reminder.ReminderText, utils.RENEW_REMINDER_TEXT),
 		)
-		msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(
-			tgbotapi.NewInlineKeyboardRow(
-				tgbotapi.NewInlineKeyboardButtonData("15m", utils.RENEW_REMINDER_15M),
-				tgbotapi.NewInlineKeyboardButtonData("30m", utils.RENEW_REMINDER_30M),
-				tgbotapi.NewInlineKeyboardButtonData("1h", utils.RENEW_REMINDER_1H),
-				tgbotapi.NewInlineKeyboardButtonData("3h", utils.RENEW_REMINDER_3H),
-				tgbotapi.NewInlineKeyboardButtonData("1d", utils.RENEW_REMINDER_1D),
-			),
-			tgbotapi.NewInlineKeyboardRow(
-				tgbotapi.NewInlineKeyboardButtonData("Enter Time", utils.RENEW_REMINDER_CUSTOM),
-				tgbotapi.NewInlineKeyboardButtonData("Cancel", utils.RENEW_REMINDER_CANCEL),
-			),
-		)
-		if res, err := bot.Request(msg); err != nil {
-			// Check if user has blocked the bot (Forbidden error)
-			if res.ErrorCode == 403 {
-				log.Warnf("User %d has blocked the bot. Deleting reminder.", reminder.ChatId)
-				delErr := reminder.Delete()
-				if delErr != nil {
-					log.Error(delErr)
-				}
-				return
-			}
-			err = HandleErrorSendingReminder(reminder)
-			if err != nil {
-				log.Error(err)
-			}
-			return
-		}
+		msg.ReplyMarkup = markup
+		reminderMsg = msg
 	}
-	frequencyText := strings.Split(reminder.Frequency, "-")
-	frequency := frequencyText[0]
-	if frequency == utils.REMINDER_ONCE {
-		err := reminder.Delete()
-		if err != nil {
-			log.Error(err)
-			err = HandleErrorSendingReminder(reminder)
-			if err != nil {
-				log.Error(err)
+	res, err := Outbox.Request(ctx, reminderMsg, utils.PRIORITY_REMINDER)
+	// whatever happened to the send, the reminder is recorded and rescheduled even if ctx has run out meanwhile
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), utils.REMINDER_RESCHEDULE_TIMEOUT)
+	defer cancel()
+	RecordDelivery(ctx, reminder, res, err)
+	if err != nil {
+		log.Error(err)
+		// Check if user has blocked the bot (Forbidden error)
+		if res != nil && res.ErrorCode == 403 {
+			log.Warnf("User %d has blocked the bot. Deleting reminder.", reminder.ChatId)
+			delErr := reminder.Delete(ctx)
+			if delErr != nil {
+				log.Error(delErr)
 			}
-			return
+			return nil
 		}
-	} else {
-		nextTriggerTime, err := reminder.CalculateNextTriggerTime(chatSettings)
-		if err != nil {
-			log.Error(err)
-			return
-		}
-		reminder.NextTriggerTime = nextTriggerTime.Format(utils.DIRECTUS_DATETIME_FORMAT)
-		err = reminder.Update()
+		err = HandleErrorSendingReminder(ctx, reminder, chatSettings)
 		if err != nil {
 			log.Error(err)
-			return
 		}
+		return nil
+	}
+	if !nextMissed.IsZero() {
+		// the next missed occurrence is due already, so it is sent straight after this one
+		reminder.NextTriggerTime = nextMissed.Format(utils.DIRECTUS_DATETIME_FORMAT)
+		return reminder.Update(ctx)
+	}
+	return rescheduleReminder(ctx, reminder, chatSettings)
+}
+
+// rescheduleReminder moves a reminder that has been sent on to its next
+// occurrence after now, or deletes it when it will not fire again.
+func rescheduleReminder(ctx context.Context, reminder schemas.Reminder, chatSettings *schemas.ChatSettings) error {
+	if reminder.Frequency.Kind == utils.REMINDER_ONCE {
+		return reminder.Delete(ctx)
 	}
+	nextTriggerTime, err := reminder.CalculateNextTriggerTime(chatSettings)
+	if errors.Is(err, schemas.ErrRecurrenceEnded) {
+		// the RRULE's COUNT or UNTIL has been reached, so this was its last occurrence
+		return reminder.Delete(ctx)
+	} else if err != nil {
+		log.Error(err)
+		return nil
+	}
+	reminder.NextTriggerTime = nextTriggerTime.Format(utils.DIRECTUS_DATETIME_FORMAT)
+	return reminder.Update(ctx)
 }
 
-func ScheduledReminderTrigger(bot *tgbotapi.BotAPI) {
+// TriggerDueReminders fires every reminder due at the time of the call, one
+// batch at a time so that a large backlog does not spawn a goroutine per reminder.
+// Reminders are claimed before they are fired, so several instances of the bot
+// can share the work without sending a reminder twice.
+// Once ctx is done no more batches are claimed, but the reminders already
+// claimed are still fired.
+func TriggerDueReminders(ctx context.Context, bot *tgbotapi.BotAPI) error {
 	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var triggerErr error
+	// trigger times are stored to the second, so this takes every reminder whose second has started
+	before := time.Now().Truncate(time.Second).Add(time.Second)
 	for {
-		dueReminders, err := schemas.GetDueReminders()
+		dueReminders, err := schemas.ClaimDueReminders(ctx, utils.InstanceId, before, utils.DUE_REMINDERS_BATCH_SIZE)
 		if err != nil {
-			panic(err)
+			return err
 		}
 		for i := 0; i < len(dueReminders); i++ {
 			wg.Add(1)
 			reminder := dueReminders[i]
 			go func(reminder schemas.Reminder, bot *tgbotapi.BotAPI) {
 				defer wg.Done()
-				TriggerReminder(reminder, bot)
+				// deliveries in flight are finished during a shutdown, within SHUTDOWN_TIMEOUT
+				deliveryCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), utils.REMINDER_DELIVERY_TIMEOUT)
+				defer cancel()
+				err := TriggerReminder(deliveryCtx, reminder, bot)
+				if err != nil {
+					log.Errorf("error firing reminder %v: %v", reminder.Id, err)
+					mu.Lock()
+					triggerErr = err
+					mu.Unlock()
+				}
 			}(reminder, bot)
 		}
 		wg.Wait()
-		time.Sleep(2 * time.Second)
+		if triggerErr != nil {
+			return triggerErr
+		}
+		if len(dueReminders) < utils.DUE_REMINDERS_BATCH_SIZE || ctx.Err() != nil {
+			return nil
+		}
+	}
+}
+
+// ScheduledReminderTrigger sleeps until the earliest reminder on the schedule
+// is due, then fires every due reminder. The schedule is reloaded from the store
+// every utils.SCHEDULE_RECONCILE_INTERVAL, and when a resync is requested.
+// It returns when ctx is done, after the reminders being fired have been sent.
+//
+// While the store cannot be reached, SchedulerHealth is degraded and the
+// scheduler retries with a backoff. Every retry starts with a reconciliation,
+// which also picks up the reminders that fell due during the outage.
+func ScheduledReminderTrigger(ctx context.Context, bot *tgbotapi.BotAPI) {
+	var nextReconcile time.Time
+	for ctx.Err() == nil {
+		now := time.Now()
+		var err error
+		reconciled, triggered := false, false
+		if reminderSchedule.takeResync() || !now.Before(nextReconcile) {
+			reconciled = true
+			err = reminderSchedule.Reconcile(ctx, now)
+		}
+		if err == nil && reminderSchedule.popDue(now) > 0 {
+			triggered = true
+			err = TriggerDueReminders(ctx, bot)
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			backoff := SchedulerHealth.Fail(err)
+			log.Warnf("scheduler cannot reach the %v store, retrying in %v: %v", utils.StoreBackend, backoff.Round(time.Millisecond), err)
+			nextReconcile = time.Time{}
+			select {
+			case <-ctx.Done():
+			case <-time.After(backoff):
+			}
+			continue
+		}
+		if reconciled {
+			nextReconcile = now.Add(utils.SCHEDULE_RECONCILE_INTERVAL)
+		}
+		if reconciled || triggered {
+			SchedulerHealth.Succeed()
+		}
+		if triggered {
+			continue
+		}
+
+		wait := nextReconcile.Sub(now)
+		if next, ok := reminderSchedule.next(); ok && next.Sub(now) < wait {
+			wait = next.Sub(now)
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-reminderSchedule.changed:
+			timer.Stop()
+		case <-ctx.Done():
+			timer.Stop()
+		}
+	}
+}
+
+// ScheduledDeletedReminderPurge removes soft deleted reminders for good once
+// they are past the retention window, until ctx is done.
+func ScheduledDeletedReminderPurge(ctx context.Context) {
+	for {
+		err := schemas.PurgeDeletedReminders(ctx, time.Now().Add(-utils.DELETED_REMINDER_RETENTION))
+		if err != nil && ctx.Err() == nil {
+			log.Error(err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(utils.DELETED_REMINDER_PURGE_INTERVAL):
+		}
 	}
 }
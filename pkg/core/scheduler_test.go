@@ -0,0 +1,179 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Jason-CKY/telegram-reminderbot/pkg/schemas"
+	"github.com/Jason-CKY/telegram-reminderbot/pkg/utils"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// fakeTelegram is a local stand-in for the Telegram Bot API that accepts every
+// request and records the text of the messages sent.
+type fakeTelegram struct {
+	mu    sync.Mutex
+	texts map[int64][]string
+}
+
+func (telegram *fakeTelegram) sent(chatId int64) []string {
+	telegram.mu.Lock()
+	defer telegram.mu.Unlock()
+	return telegram.texts[chatId]
+}
+
+// setupTest points the store at a fresh MemoryStore and the Outbox at a fake
+// Telegram server, for the duration of the test.
+func setupTest(t *testing.T) (*tgbotapi.BotAPI, *fakeTelegram) {
+	telegram := &fakeTelegram{texts: map[int64][]string{}}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			t.Error(err)
+		}
+		if r.URL.Path == "/botTOKEN/getMe" {
+			fmt.Fprint(w, `{"ok":true,"result":{"id":1,"is_bot":true,"first_name":"bot","username":"bot"}}`)
+			return
+		}
+		var chatId int64
+		fmt.Sscan(r.Form.Get("chat_id"), &chatId)
+		telegram.mu.Lock()
+		telegram.texts[chatId] = append(telegram.texts[chatId], r.Form.Get("text"))
+		telegram.mu.Unlock()
+		fmt.Fprintf(w, `{"ok":true,"result":{"message_id":1,"chat":{"id":%v},"date":0}}`, chatId)
+	}))
+	t.Cleanup(server.Close)
+
+	bot, err := tgbotapi.NewBotAPIWithAPIEndpoint("TOKEN", server.URL+"/bot%s/%s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, outbox := schemas.Store, Outbox
+	t.Cleanup(func() { schemas.Store, Outbox = store, outbox })
+	schemas.Store = schemas.NewMemoryStore()
+	Outbox = NewDispatcher(bot)
+	return bot, telegram
+}
+
+func createTestReminder(t *testing.T, chatId int64, frequency schemas.Recurrence, nextTriggerTime time.Time) schemas.Reminder {
+	t.Helper()
+	ctx := context.Background()
+	err := schemas.ChatSettings{ChatId: chatId, Timezone: "UTC"}.Create(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reminder := schemas.Reminder{
+		Id:              fmt.Sprint("reminder-", chatId),
+		ChatId:          chatId,
+		Frequency:       frequency,
+		Time:            nextTriggerTime.UTC().Format("15:04"),
+		ReminderText:    fmt.Sprint("reminder for chat ", chatId),
+		NextTriggerTime: nextTriggerTime.UTC().Format(utils.DIRECTUS_DATETIME_FORMAT),
+	}
+	err = reminder.Create(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return reminder
+}
+
+func TestTriggerDueRemindersReschedulesRecurringReminders(t *testing.T) {
+	bot, telegram := setupTest(t)
+	ctx := context.Background()
+	due := time.Now().UTC().Truncate(time.Minute)
+	reminder := createTestReminder(t, 1, schemas.NewDailyRecurrence(), due)
+
+	err := TriggerDueReminders(ctx, bot)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if texts := telegram.sent(1); len(texts) != 1 || texts[0] != utils.REMINDER_PREFIX+reminder.ReminderText+utils.RENEW_REMINDER_TEXT {
+		t.Errorf("sent %q, want the reminder once", texts)
+	}
+	rescheduled, err := schemas.GetReminderById(ctx, reminder.Id)
+	if err != nil || rescheduled == nil {
+		t.Fatalf("reminder is gone after firing: %v", err)
+	}
+	if want := due.Add(24 * time.Hour).Format(utils.DIRECTUS_DATETIME_FORMAT); rescheduled.NextTriggerTime != want {
+		t.Errorf("rescheduled to %v, want %v", rescheduled.NextTriggerTime, want)
+	}
+
+	// the reminder is not due again until tomorrow
+	err = TriggerDueReminders(ctx, bot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if texts := telegram.sent(1); len(texts) != 1 {
+		t.Errorf("sent %v messages, want the reminder only once", len(texts))
+	}
+}
+
+func TestTriggerDueRemindersDeletesOnceReminders(t *testing.T) {
+	bot, telegram := setupTest(t)
+	ctx := context.Background()
+	due := time.Now().UTC().Truncate(time.Minute)
+	reminder := createTestReminder(t, 2, schemas.NewOnceRecurrence(due.Truncate(24*time.Hour)), due)
+
+	err := TriggerDueReminders(ctx, bot)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if texts := telegram.sent(2); len(texts) != 1 {
+		t.Errorf("sent %v messages, want the reminder once", len(texts))
+	}
+	deleted, err := schemas.GetReminderById(ctx, reminder.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deleted != nil {
+		t.Errorf("once reminder is still scheduled at %v after firing", deleted.NextTriggerTime)
+	}
+}
+
+func TestTriggerDueRemindersSkipsRemindersNotDue(t *testing.T) {
+	bot, telegram := setupTest(t)
+	ctx := context.Background()
+	createTestReminder(t, 3, schemas.NewDailyRecurrence(), time.Now().Add(time.Hour))
+
+	err := TriggerDueReminders(ctx, bot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if texts := telegram.sent(3); len(texts) != 0 {
+		t.Errorf("sent %q before the reminder was due", texts)
+	}
+}
+
+func TestTriggerDueRemindersSendsMissedRemindersOnce(t *testing.T) {
+	bot, telegram := setupTest(t)
+	ctx := context.Background()
+	// the bot was down for three days, so four daily occurrences were missed
+	missed := time.Now().UTC().Truncate(time.Minute).Add(-3*24*time.Hour - time.Hour)
+	reminder := createTestReminder(t, 4, schemas.NewDailyRecurrence(), missed)
+
+	for i := 0; i < 3; i++ {
+		err := TriggerDueReminders(ctx, bot)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	lastMissed := formatMissedTime(missed.Add(3*24*time.Hour), time.Now(), time.UTC)
+	if texts := telegram.sent(4); len(texts) != 1 || !strings.HasPrefix(texts[0], "⏰ Missed 4 times, last at "+lastMissed+"\n") {
+		t.Errorf("sent %q, want the reminder once with a note of the missed occurrences", texts)
+	}
+	rescheduled, err := schemas.GetReminderById(ctx, reminder.Id)
+	if err != nil || rescheduled == nil {
+		t.Fatalf("reminder is gone after firing: %v", err)
+	}
+	if want := missed.Add(4 * 24 * time.Hour).Format(utils.DIRECTUS_DATETIME_FORMAT); rescheduled.NextTriggerTime != want {
+		t.Errorf("rescheduled to %v, want %v", rescheduled.NextTriggerTime, want)
+	}
+}
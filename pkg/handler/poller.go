@@ -0,0 +1,83 @@
+package handler
+
+import (
+	"context"
+	"time"
+
+	"github.com/Jason-CKY/telegram-reminderbot/pkg/core"
+	"github.com/Jason-CKY/telegram-reminderbot/pkg/utils"
+	log "github.com/sirupsen/logrus"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// PollUpdates long polls Telegram for updates and handles them one at a time
+// until ctx is done. An update that is being handled when ctx is done is
+// finished first, and the updates handled are confirmed to Telegram before
+// returning, so the next poller does not get them again.
+func PollUpdates(ctx context.Context, bot *tgbotapi.BotAPI) {
+	config := tgbotapi.NewUpdate(0)
+	config.Timeout = 60
+	for ctx.Err() == nil {
+		updates, err := getUpdates(ctx, bot, config)
+		if ctx.Err() != nil {
+			break
+		}
+		if err != nil {
+			log.Error(err)
+			select {
+			case <-ctx.Done():
+			case <-time.After(3 * time.Second):
+			}
+			continue
+		}
+		for _, update := range updates {
+			if ctx.Err() != nil {
+				break
+			}
+			if update.UpdateID < config.Offset {
+				continue
+			}
+			config.Offset = update.UpdateID + 1
+			timeout := utils.UPDATE_HANDLER_TIMEOUT
+			if core.SchedulerHealth.Degraded() {
+				// a store that is down may not answer at all, don't hold up the updates behind this one
+				timeout = utils.DEGRADED_UPDATE_HANDLER_TIMEOUT
+			}
+			updateCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), timeout)
+			HandleUpdate(updateCtx, &update, bot)
+			cancel()
+		}
+	}
+
+	if config.Offset != 0 {
+		// an offset confirms every update before it, without waiting for new ones
+		_, err := bot.GetUpdates(tgbotapi.UpdateConfig{Offset: config.Offset, Limit: 1})
+		if err != nil {
+			log.Error(err)
+		}
+	}
+}
+
+// getUpdates is bot.GetUpdates, given up on when ctx is done. The Telegram
+// client has no context support, so the request itself is left to run out.
+func getUpdates(ctx context.Context, bot *tgbotapi.BotAPI, config tgbotapi.UpdateConfig) ([]tgbotapi.Update, error) {
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	type result struct {
+		updates []tgbotapi.Update
+		err     error
+	}
+	done := make(chan result, 1)
+	go func() {
+		updates, err := bot.GetUpdates(config)
+		done <- result{updates, err}
+	}()
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case result := <-done:
+		return result.updates, result.err
+	}
+}
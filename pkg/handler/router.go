@@ -1,6 +1,8 @@
 package handler
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"strings"
 	"time"
@@ -14,11 +16,34 @@ import (
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 )
 
-func HandleUpdate(update *tgbotapi.Update, bot *tgbotapi.BotAPI) {
+func HandleUpdate(ctx context.Context, update *tgbotapi.Update, bot *tgbotapi.BotAPI) {
 	if update.Message != nil {
-		chatSettings, chatSettingsIsPresent, err := schemas.InsertChatSettingsIfNotPresent(update.Message.Chat.ID)
+		// Telegram sends both sides of a group to supergroup upgrade. Migrate on
+		// whichever arrives first; the second one finds nothing left to move.
+		if update.Message.MigrateToChatID != 0 {
+			err := schemas.MigrateChat(ctx, update.Message.Chat.ID, update.Message.MigrateToChatID)
+			if err != nil {
+				log.Error(err)
+			}
+			return
+		}
+		if update.Message.MigrateFromChatID != 0 {
+			err := schemas.MigrateChat(ctx, update.Message.MigrateFromChatID, update.Message.Chat.ID)
+			if err != nil {
+				log.Error(err)
+			}
+			return
+		}
+
+		chatSettings, chatSettingsIsPresent, err := schemas.InsertChatSettingsIfNotPresent(ctx, update.Message.Chat.ID)
 		if err != nil {
 			log.Error(err)
+			// answer straight away rather than leave the user waiting while the store is down
+			msg := tgbotapi.NewMessage(update.Message.Chat.ID, utils.STORE_UNAVAILABLE_MESSAGE)
+			msg.ReplyToMessageID = update.Message.MessageID
+			if _, err := core.Outbox.Request(ctx, msg, utils.PRIORITY_INTERACTIVE); err != nil {
+				log.Error(err)
+			}
 			return
 		}
 		if !chatSettingsIsPresent {
@@ -26,68 +51,61 @@ func HandleUpdate(update *tgbotapi.Update, bot *tgbotapi.BotAPI) {
 				update.Message.Chat.ID,
 				utils.DEFAULT_SETTINGS_MESSAGE,
 			)
-			if _, err := bot.Request(msg); err != nil {
+			if _, err := core.Outbox.Request(ctx, msg, utils.PRIORITY_INTERACTIVE); err != nil {
 				log.Error(err)
 				return
 			}
 		}
 
-		if update.Message.MigrateToChatID != 0 {
-			err = schemas.MigrateReminderChatId(update.Message.Chat.ID, update.Message.MigrateToChatID)
-			if err != nil {
-				log.Error(err)
-				return
-			}
-			err = schemas.MigrateChatSettingsChatId(update.Message.Chat.ID, update.Message.MigrateToChatID)
-			if err != nil {
-				log.Error(err)
-				return
-			}
-		} else if update.Message.IsCommand() {
-			HandleCommand(update, bot, chatSettings)
+		if update.Message.IsCommand() || captionCommand(update.Message) != "" {
+			HandleCommand(ctx, update, bot, chatSettings)
 		} else {
-			HandleMessage(update, bot, chatSettings)
+			HandleMessage(ctx, update, bot, chatSettings)
 		}
 	} else if update.CallbackQuery != nil {
-		chatSettings, chatSettingsIsPresent, err := schemas.InsertChatSettingsIfNotPresent(update.CallbackQuery.Message.Chat.ID)
+		chatSettings, chatSettingsIsPresent, err := schemas.InsertChatSettingsIfNotPresent(ctx, update.CallbackQuery.Message.Chat.ID)
 		if err != nil {
 			log.Error(err)
+			callback := tgbotapi.NewCallback(update.CallbackQuery.ID, utils.STORE_UNAVAILABLE_MESSAGE)
+			if _, err := core.Outbox.Request(ctx, callback, utils.PRIORITY_INTERACTIVE); err != nil {
+				log.Error(err)
+			}
 			return
 		}
 		if !chatSettingsIsPresent {
 			msg := tgbotapi.NewMessage(
-				update.Message.Chat.ID,
+				update.CallbackQuery.Message.Chat.ID,
 				utils.DEFAULT_SETTINGS_MESSAGE,
 			)
-			if _, err := bot.Request(msg); err != nil {
+			if _, err := core.Outbox.Request(ctx, msg, utils.PRIORITY_INTERACTIVE); err != nil {
 				log.Error(err)
 				return
 			}
 		}
 
-		HandleCallbackQuery(update, bot, chatSettings)
+		HandleCallbackQuery(ctx, update, bot, chatSettings)
 	}
 }
 
-func HandleMessage(update *tgbotapi.Update, bot *tgbotapi.BotAPI, chatSettings *schemas.ChatSettings) {
-	reminderInConstruction, _ := schemas.GetReminderInConstruction(update.Message.Chat.ID, update.Message.From.ID)
+func HandleMessage(ctx context.Context, update *tgbotapi.Update, bot *tgbotapi.BotAPI, chatSettings *schemas.ChatSettings) {
+	reminderInConstruction, _ := schemas.GetReminderInConstruction(ctx, update.Message.Chat.ID, update.Message.From.ID)
 
 	if update.Message.Text == utils.CANCEL_MESSAGE {
 		if reminderInConstruction != nil {
-			err := reminderInConstruction.Delete()
+			err := reminderInConstruction.Delete(ctx)
 			if err != nil {
 				log.Error(err)
 				return
 			}
 		}
 		chatSettings.Updating = false
-		err := chatSettings.Update()
+		err := chatSettings.Save(ctx)
 		if err != nil {
 			log.Error(err)
 			return
 		}
 		if reminderInConstruction != nil {
-			err := reminderInConstruction.DeleteReminderInConstruction()
+			err := reminderInConstruction.DeleteReminderInConstruction(ctx)
 			if err != nil {
 				log.Error(err)
 				return
@@ -97,16 +115,16 @@ func HandleMessage(update *tgbotapi.Update, bot *tgbotapi.BotAPI, chatSettings *
 		msg.ReplyToMessageID = update.Message.MessageID
 		msg.ReplyMarkup = tgbotapi.NewRemoveKeyboard(true)
 		if msg.Text != "" {
-			if _, err := bot.Request(msg); err != nil {
+			if _, err := core.Outbox.Request(ctx, msg, utils.PRIORITY_INTERACTIVE); err != nil {
 				log.Error(err)
 				return
 			}
 		}
 	} else if reminderInConstruction != nil {
-		core.BuildReminder(reminderInConstruction, chatSettings, update, bot)
+		core.BuildReminder(ctx, reminderInConstruction, chatSettings, update, bot)
 	} else if update.Message.Text == utils.SETTINGS_CHANGE_TIMEZONE {
 		chatSettings.Updating = true
-		err := chatSettings.Update()
+		err := chatSettings.Save(ctx)
 		if err != nil {
 			log.Error(err)
 			return
@@ -122,7 +140,16 @@ func HandleMessage(update *tgbotapi.Update, bot *tgbotapi.BotAPI, chatSettings *
 		msg.ReplyMarkup = cancelKeyboard
 		msg.ReplyToMessageID = update.Message.MessageID
 		msg.ParseMode = "html"
-		if _, err := bot.Request(msg); err != nil {
+		if _, err := core.Outbox.Request(ctx, msg, utils.PRIORITY_INTERACTIVE); err != nil {
+			log.Error(err)
+			return
+		}
+	} else if update.Message.Text == utils.SETTINGS_MISSED_REMINDERS {
+		msg := tgbotapi.NewMessage(update.Message.Chat.ID, core.BuildMissedRemindersText(chatSettings))
+		msg.ReplyToMessageID = update.Message.MessageID
+		msg.ReplyMarkup = core.BuildMissedRemindersMarkup(chatSettings)
+		msg.ParseMode = "html"
+		if _, err := core.Outbox.Request(ctx, msg, utils.PRIORITY_INTERACTIVE); err != nil {
 			log.Error(err)
 			return
 		}
@@ -132,22 +159,25 @@ func HandleMessage(update *tgbotapi.Update, bot *tgbotapi.BotAPI, chatSettings *
 			msg := tgbotapi.NewMessage(update.Message.Chat.ID, utils.INVALID_TIMEZONE_MESSAGE)
 			msg.ReplyToMessageID = update.Message.MessageID
 			msg.ParseMode = "html"
-			if _, err := bot.Request(msg); err != nil {
+			if _, err := core.Outbox.Request(ctx, msg, utils.PRIORITY_INTERACTIVE); err != nil {
 				log.Error(err)
 				return
 			}
 		} else {
+			previousChatSettings := *chatSettings
+			previousChatSettings.Updating = false
 			chatSettings.Timezone = update.Message.Text
 			chatSettings.Updating = false
-			err = chatSettings.Update()
+			err = chatSettings.Update(ctx)
 			if err != nil {
 				log.Error(err)
 				return
 			}
+			core.RecordAudit(ctx, chatSettings.ChatId, update.Message.From, utils.AUDIT_UPDATE, utils.AUDIT_ENTITY_CHAT_SETTINGS, fmt.Sprint(chatSettings.ChatId), previousChatSettings, *chatSettings)
 			msg := tgbotapi.NewMessage(update.Message.Chat.ID, "Timezone has been set")
 			msg.ReplyToMessageID = update.Message.MessageID
 			msg.ReplyMarkup = tgbotapi.NewRemoveKeyboard(true)
-			if _, err := bot.Request(msg); err != nil {
+			if _, err := core.Outbox.Request(ctx, msg, utils.PRIORITY_INTERACTIVE); err != nil {
 				log.Error(err)
 				return
 			}
@@ -155,12 +185,16 @@ func HandleMessage(update *tgbotapi.Update, bot *tgbotapi.BotAPI, chatSettings *
 	}
 }
 
-func HandleCommand(update *tgbotapi.Update, bot *tgbotapi.BotAPI, chatSettings *schemas.ChatSettings) {
+func HandleCommand(ctx context.Context, update *tgbotapi.Update, bot *tgbotapi.BotAPI, chatSettings *schemas.ChatSettings) {
 	// Create a new MessageConfig. We don't have text yet,
 	// so we leave it empty.
 	msg := tgbotapi.NewMessage(update.Message.Chat.ID, "")
 	// Extract the command from the Message.
-	switch update.Message.Command() {
+	command := update.Message.Command()
+	if command == "" {
+		command = captionCommand(update.Message)
+	}
+	switch command {
 	case "help":
 		msg.Text = utils.HELP_MESSAGE
 	case "start":
@@ -173,20 +207,20 @@ func HandleCommand(update *tgbotapi.Update, bot *tgbotapi.BotAPI, chatSettings *
 			ChatId:          update.Message.Chat.ID,
 			FromUserId:      update.Message.From.ID,
 			FileId:          "",
-			Frequency:       "",
+			Frequency:       schemas.Recurrence{},
 			Time:            "",
 			ReminderText:    "",
 			InConstruction:  true,
 			NextTriggerTime: "",
 		}
 		// delete previous reminders in construction to create a new one
-		err := reminder.DeleteReminderInConstruction()
+		err := reminder.DeleteReminderInConstruction(ctx)
 		if err != nil {
 			log.Error(err)
 			return
 		}
 		// create a new reminder
-		err = reminder.Create()
+		err = reminder.Create(ctx)
 		if err != nil {
 			log.Error(err)
 			return
@@ -204,7 +238,7 @@ func HandleCommand(update *tgbotapi.Update, bot *tgbotapi.BotAPI, chatSettings *
 
 		msg.ReplyToMessageID = update.Message.MessageID
 	case "list":
-		chatReminders, err := schemas.ListChatReminders(update.Message.Chat.ID)
+		chatReminders, err := schemas.ListChatReminders(ctx, update.Message.Chat.ID)
 		if err != nil {
 			log.Error(err)
 			return
@@ -221,13 +255,77 @@ func HandleCommand(update *tgbotapi.Update, bot *tgbotapi.BotAPI, chatSettings *
 				msg.ReplyMarkup = listReminderMarkup
 			}
 		}
+	case "audit":
+		auditEntries, err := schemas.ListChatAuditEntries(ctx, update.Message.Chat.ID, utils.MAX_AUDIT_ENTRIES_SHOWN)
+		if err != nil {
+			log.Error(err)
+			return
+		}
+		msg.Text, err = core.BuildAuditLogText(auditEntries, chatSettings)
+		if err != nil {
+			log.Error(err)
+			return
+		}
+		msg.ParseMode = "html"
+	case "export":
+		data, err := core.BuildChatExport(ctx, update.Message.Chat.ID, chatSettings)
+		if err != nil {
+			log.Error(err)
+			return
+		}
+		document := tgbotapi.NewDocument(update.Message.Chat.ID, tgbotapi.FileBytes{
+			Name:  fmt.Sprintf("reminders-%v.json", update.Message.Chat.ID),
+			Bytes: data,
+		})
+		document.Caption = "Send this file with /import to recreate these reminders in another chat."
+		document.ReplyToMessageID = update.Message.MessageID
+		if _, err := core.Outbox.Request(ctx, document, utils.PRIORITY_INTERACTIVE); err != nil {
+			log.Error(err)
+		}
+		return
+	case "ics":
+		data, err := core.BuildChatCalendar(ctx, update.Message.Chat.ID, chatSettings)
+		if err != nil {
+			log.Error(err)
+			return
+		}
+		document := tgbotapi.NewDocument(update.Message.Chat.ID, tgbotapi.FileBytes{
+			Name:  fmt.Sprintf("reminders-%v.ics", update.Message.Chat.ID),
+			Bytes: data,
+		})
+		document.ReplyToMessageID = update.Message.MessageID
+		if _, err := core.Outbox.Request(ctx, document, utils.PRIORITY_INTERACTIVE); err != nil {
+			log.Error(err)
+		}
+		return
+	case "import":
+		HandleImportCommand(ctx, utils.IMPORT_FORMAT_JSON, update, bot, chatSettings)
+		return
+	case "import_ics":
+		HandleImportCommand(ctx, utils.IMPORT_FORMAT_ICS, update, bot, chatSettings)
+		return
+	case "import_csv":
+		HandleImportCommand(ctx, utils.IMPORT_FORMAT_CSV, update, bot, chatSettings)
+		return
+	case "forgetme":
+		msg.Text = utils.FORGET_ME_MESSAGE
+		msg.ReplyMarkup = core.BuildForgetMeMarkup(update.Message.From.ID)
+		msg.ReplyToMessageID = update.Message.MessageID
 	case "settings":
 		tz, _ := time.LoadLocation(chatSettings.Timezone)
-		msg.Text = fmt.Sprintf("<b>Your current settings:</b>\n\n- timezone: %v\n- local time: %v", chatSettings.Timezone, time.Now().In(tz).Format(utils.DATE_AND_TIME_FORMAT_WITHOUT_YEAR))
+		msg.Text = fmt.Sprintf(
+			"<b>Your current settings:</b>\n\n- timezone: %v\n- local time: %v\n- missed reminders: %v",
+			chatSettings.Timezone,
+			time.Now().In(tz).Format(utils.DATE_AND_TIME_FORMAT_WITHOUT_YEAR),
+			utils.MISSED_REMINDERS_DESCRIPTIONS[chatSettings.MissedReminderPolicy()],
+		)
 		msg.ParseMode = "html"
 		keyboard := tgbotapi.NewOneTimeReplyKeyboard(
 			tgbotapi.NewKeyboardButtonRow(
 				tgbotapi.NewKeyboardButton(utils.SETTINGS_CHANGE_TIMEZONE),
+				tgbotapi.NewKeyboardButton(utils.SETTINGS_MISSED_REMINDERS),
+			),
+			tgbotapi.NewKeyboardButtonRow(
 				tgbotapi.NewKeyboardButton(utils.CANCEL_MESSAGE),
 			),
 		)
@@ -237,14 +335,54 @@ func HandleCommand(update *tgbotapi.Update, bot *tgbotapi.BotAPI, chatSettings *
 		return
 	}
 
-	if _, err := bot.Request(msg); err != nil {
+	if _, err := core.Outbox.Request(ctx, msg, utils.PRIORITY_INTERACTIVE); err != nil {
 		log.Error(err)
 		return
 	}
 }
 
-func HandleCallbackQuery(update *tgbotapi.Update, bot *tgbotapi.BotAPI, chatSettings *schemas.ChatSettings) {
-	reminderInConstruction, _ := schemas.GetReminderInConstruction(update.CallbackQuery.Message.Chat.ID, update.CallbackQuery.From.ID)
+// captionCommand returns the command in the caption of a document, as
+// Message.Command only looks at the message text.
+func captionCommand(message *tgbotapi.Message) string {
+	if message.Document == nil || !strings.HasPrefix(message.Caption, "/") {
+		return ""
+	}
+	command := strings.Fields(message.Caption)[0][1:]
+	if i := strings.Index(command, "@"); i != -1 {
+		command = command[:i]
+	}
+	return command
+}
+
+// HandleImportCommand reads the document sent with an import command, or the
+// one it replies to, and answers the document with a report of what will be
+// imported. The confirm button reads the document again from that reply.
+func HandleImportCommand(ctx context.Context, format string, update *tgbotapi.Update, bot *tgbotapi.BotAPI, chatSettings *schemas.ChatSettings) {
+	documentMessage := update.Message
+	if documentMessage.Document == nil && documentMessage.ReplyToMessage != nil {
+		documentMessage = documentMessage.ReplyToMessage
+	}
+	msg := tgbotapi.NewMessage(update.Message.Chat.ID, "")
+	msg.ReplyToMessageID = documentMessage.MessageID
+	if documentMessage.Document == nil {
+		command := update.Message.Command()
+		msg.Text = fmt.Sprintf("Send /%v as the caption of the file to import, or reply to the file with /%v.", command, command)
+	} else if data, err := core.DownloadDocument(ctx, documentMessage.Document); err != nil {
+		log.Error(err)
+		msg.Text = fmt.Sprintf("Could not read the file: %v", err)
+	} else if plan, err := core.BuildImportPlan(ctx, format, data, chatSettings, update.Message.From.ID); err != nil {
+		msg.Text = fmt.Sprintf("Could not import the file: %v", err)
+	} else {
+		msg.Text, msg.ReplyMarkup = core.BuildImportPlanTextAndMarkup(plan, format, update.Message.From.ID)
+		msg.ParseMode = "html"
+	}
+	if _, err := core.Outbox.Request(ctx, msg, utils.PRIORITY_INTERACTIVE); err != nil {
+		log.Error(err)
+	}
+}
+
+func HandleCallbackQuery(ctx context.Context, update *tgbotapi.Update, bot *tgbotapi.BotAPI, chatSettings *schemas.ChatSettings) {
+	reminderInConstruction, _ := schemas.GetReminderInConstruction(ctx, update.CallbackQuery.Message.Chat.ID, update.CallbackQuery.From.ID)
 	if strings.HasPrefix(update.CallbackQuery.Data, "cbcal") && reminderInConstruction != nil {
 		action, step, _, _, _ := core.SplitCallbackCalendarData(update.CallbackQuery.Data)
 		tz, _ := time.LoadLocation(chatSettings.Timezone)
@@ -259,7 +397,7 @@ func HandleCallbackQuery(update *tgbotapi.Update, bot *tgbotapi.BotAPI, chatSett
 						utils.CALLBACK_CALENDAR_SELECT_YEAR,
 						replyMarkup,
 					)
-					if _, err := bot.Request(editedMessage); err != nil {
+					if _, err := core.Outbox.Request(ctx, editedMessage, utils.PRIORITY_INTERACTIVE); err != nil {
 						log.Error(err)
 						return
 					}
@@ -274,7 +412,7 @@ func HandleCallbackQuery(update *tgbotapi.Update, bot *tgbotapi.BotAPI, chatSett
 						utils.CALLBACK_CALENDAR_SELECT_MONTH,
 						replyMarkup,
 					)
-					if _, err := bot.Request(editedMessage); err != nil {
+					if _, err := core.Outbox.Request(ctx, editedMessage, utils.PRIORITY_INTERACTIVE); err != nil {
 						log.Error(err)
 						return
 					}
@@ -289,7 +427,7 @@ func HandleCallbackQuery(update *tgbotapi.Update, bot *tgbotapi.BotAPI, chatSett
 						utils.CALLBACK_CALENDAR_SELECT_DAY,
 						replyMarkup,
 					)
-					if _, err := bot.Request(editedMessage); err != nil {
+					if _, err := core.Outbox.Request(ctx, editedMessage, utils.PRIORITY_INTERACTIVE); err != nil {
 						log.Error(err)
 						return
 					}
@@ -307,7 +445,7 @@ func HandleCallbackQuery(update *tgbotapi.Update, bot *tgbotapi.BotAPI, chatSett
 						utils.CALLBACK_CALENDAR_SELECT_MONTH,
 						replyMarkup,
 					)
-					if _, err := bot.Request(editedMessage); err != nil {
+					if _, err := core.Outbox.Request(ctx, editedMessage, utils.PRIORITY_INTERACTIVE); err != nil {
 						log.Error(err)
 						return
 					}
@@ -322,7 +460,7 @@ func HandleCallbackQuery(update *tgbotapi.Update, bot *tgbotapi.BotAPI, chatSett
 						utils.CALLBACK_CALENDAR_SELECT_DAY,
 						replyMarkup,
 					)
-					if _, err := bot.Request(editedMessage); err != nil {
+					if _, err := core.Outbox.Request(ctx, editedMessage, utils.PRIORITY_INTERACTIVE); err != nil {
 						log.Error(err)
 						return
 					}
@@ -330,17 +468,17 @@ func HandleCallbackQuery(update *tgbotapi.Update, bot *tgbotapi.BotAPI, chatSett
 				}
 				if step == utils.CALLBACK_CALENDAR_STEP_DAY {
 					// user clicks on a day
-					if reminderInConstruction.Frequency == utils.REMINDER_ONCE || reminderInConstruction.Frequency == utils.REMINDER_YEARLY {
+					if reminderInConstruction.Frequency.Kind == utils.REMINDER_ONCE || reminderInConstruction.Frequency.Kind == utils.REMINDER_YEARLY {
 						_, _, selectedYear, selectedMonth, selectedDay := core.SplitCallbackCalendarData(update.CallbackQuery.Data)
 						// reminderTime stored in db is in UTC, while the date string is in user's timezone, so we need to correct that
 						reminderHour, reminderMinute := utils.ParseReminderTime(reminderInConstruction.Time)
 						reminderDate := time.Date(selectedYear, time.Month(selectedMonth), selectedDay, reminderHour, reminderMinute, 0, 0, tz)
 
 						replyMessageText := fmt.Sprintf("✅ Reminder set for %v", reminderDate.Format(utils.DATE_AND_TIME_FORMAT))
-						if reminderInConstruction.Frequency == utils.REMINDER_ONCE {
-							reminderInConstruction.Frequency = fmt.Sprintf("%v-%v", utils.REMINDER_ONCE, reminderDate.Format(utils.DATE_FORMAT))
-						} else if reminderInConstruction.Frequency == utils.REMINDER_YEARLY {
-							reminderInConstruction.Frequency = fmt.Sprintf("%v-%v", utils.REMINDER_YEARLY, reminderDate.Format(utils.DATE_FORMAT))
+						if reminderInConstruction.Frequency.Kind == utils.REMINDER_ONCE {
+							reminderInConstruction.Frequency = schemas.NewOnceRecurrence(reminderDate)
+						} else if reminderInConstruction.Frequency.Kind == utils.REMINDER_YEARLY {
+							reminderInConstruction.Frequency = schemas.NewYearlyRecurrence(reminderDate)
 							replyMessageText = fmt.Sprintf(
 								"✅ Reminder set for every year at %v",
 								reminderDate.Format(utils.DATE_AND_TIME_FORMAT_WITHOUT_YEAR),
@@ -354,17 +492,18 @@ func HandleCallbackQuery(update *tgbotapi.Update, bot *tgbotapi.BotAPI, chatSett
 						}
 						reminderInConstruction.NextTriggerTime = nextTriggerTime.Format(utils.DIRECTUS_DATETIME_FORMAT)
 						reminderInConstruction.InConstruction = false
-						err = reminderInConstruction.Update()
+						err = reminderInConstruction.Update(ctx)
 						if err != nil {
 							log.Error(err)
 							return
 						}
+						core.RecordAudit(ctx, reminderInConstruction.ChatId, update.CallbackQuery.From, utils.AUDIT_CREATE, utils.AUDIT_ENTITY_REMINDER, reminderInConstruction.Id, nil, *reminderInConstruction)
 						editedMessage := tgbotapi.NewEditMessageText(
 							update.CallbackQuery.Message.Chat.ID,
 							update.CallbackQuery.Message.MessageID,
 							replyMessageText,
 						)
-						if _, err := bot.Request(editedMessage); err != nil {
+						if _, err := core.Outbox.Request(ctx, editedMessage, utils.PRIORITY_INTERACTIVE); err != nil {
 							log.Error(err)
 							return
 						}
@@ -399,7 +538,7 @@ func HandleCallbackQuery(update *tgbotapi.Update, bot *tgbotapi.BotAPI, chatSett
 				ChatId:          update.CallbackQuery.Message.Chat.ID,
 				FromUserId:      update.CallbackQuery.From.ID,
 				FileId:          "",
-				Frequency:       fmt.Sprintf("%v-%v", utils.REMINDER_ONCE, nextTriggerTime.Format(utils.DATE_FORMAT)),
+				Frequency:       schemas.NewOnceRecurrence(nextTriggerTime),
 				Time:            nextTriggerTime.Format(utils.TIME_ONLY_FORMAT),
 				ReminderText:    strings.TrimPrefix(reminderText, utils.REMINDER_PREFIX),
 				InConstruction:  false,
@@ -408,18 +547,19 @@ func HandleCallbackQuery(update *tgbotapi.Update, bot *tgbotapi.BotAPI, chatSett
 			if isImageReminder {
 				reminder.FileId = update.CallbackQuery.Message.Photo[0].FileID
 			}
-			err = reminder.Create()
+			err = reminder.Create(ctx)
 			if err != nil {
 				log.Error(err)
 				return
 			}
+			core.RecordAudit(ctx, reminder.ChatId, update.CallbackQuery.From, utils.AUDIT_CREATE, utils.AUDIT_ENTITY_REMINDER, reminder.Id, nil, reminder)
 			if isImageReminder {
 				editedMessage := tgbotapi.NewEditMessageCaption(
 					update.CallbackQuery.Message.Chat.ID,
 					update.CallbackQuery.Message.MessageID,
 					fmt.Sprintf("%v\n\nI will remind you again on %v", reminderText, nextTriggerTime.Format(utils.DATE_AND_TIME_FORMAT)),
 				)
-				if _, err := bot.Request(editedMessage); err != nil {
+				if _, err := core.Outbox.Request(ctx, editedMessage, utils.PRIORITY_INTERACTIVE); err != nil {
 					log.Error(err)
 					return
 				}
@@ -430,7 +570,7 @@ func HandleCallbackQuery(update *tgbotapi.Update, bot *tgbotapi.BotAPI, chatSett
 					update.CallbackQuery.Message.MessageID,
 					fmt.Sprintf("%v\n\nI will remind you again on %v", reminderText, nextTriggerTime.Format(utils.DATE_AND_TIME_FORMAT)),
 				)
-				if _, err := bot.Request(editedMessage); err != nil {
+				if _, err := core.Outbox.Request(ctx, editedMessage, utils.PRIORITY_INTERACTIVE); err != nil {
 					log.Error(err)
 					return
 				}
@@ -442,7 +582,7 @@ func HandleCallbackQuery(update *tgbotapi.Update, bot *tgbotapi.BotAPI, chatSett
 				ChatId:          update.CallbackQuery.Message.Chat.ID,
 				FromUserId:      update.CallbackQuery.From.ID,
 				FileId:          "",
-				Frequency:       fmt.Sprintf("%v-%v", utils.REMINDER_ONCE, nextTriggerTime.Format(utils.DATE_FORMAT)),
+				Frequency:       schemas.NewOnceRecurrence(nextTriggerTime),
 				Time:            nextTriggerTime.Format(utils.TIME_ONLY_FORMAT),
 				ReminderText:    strings.TrimPrefix(reminderText, utils.REMINDER_PREFIX),
 				InConstruction:  false,
@@ -451,18 +591,19 @@ func HandleCallbackQuery(update *tgbotapi.Update, bot *tgbotapi.BotAPI, chatSett
 			if isImageReminder {
 				reminder.FileId = update.CallbackQuery.Message.Photo[0].FileID
 			}
-			err = reminder.Create()
+			err = reminder.Create(ctx)
 			if err != nil {
 				log.Error(err)
 				return
 			}
+			core.RecordAudit(ctx, reminder.ChatId, update.CallbackQuery.From, utils.AUDIT_CREATE, utils.AUDIT_ENTITY_REMINDER, reminder.Id, nil, reminder)
 			if isImageReminder {
 				editedMessage := tgbotapi.NewEditMessageCaption(
 					update.CallbackQuery.Message.Chat.ID,
 					update.CallbackQuery.Message.MessageID,
 					fmt.Sprintf("%v\n\nI will remind you again on %v", reminderText, nextTriggerTime.Format(utils.DATE_AND_TIME_FORMAT)),
 				)
-				if _, err := bot.Request(editedMessage); err != nil {
+				if _, err := core.Outbox.Request(ctx, editedMessage, utils.PRIORITY_INTERACTIVE); err != nil {
 					log.Error(err)
 					return
 				}
@@ -473,7 +614,7 @@ func HandleCallbackQuery(update *tgbotapi.Update, bot *tgbotapi.BotAPI, chatSett
 					update.CallbackQuery.Message.MessageID,
 					fmt.Sprintf("%v\n\nI will remind you again on %v", reminderText, nextTriggerTime.Format(utils.DATE_AND_TIME_FORMAT)),
 				)
-				if _, err := bot.Request(editedMessage); err != nil {
+				if _, err := core.Outbox.Request(ctx, editedMessage, utils.PRIORITY_INTERACTIVE); err != nil {
 					log.Error(err)
 					return
 				}
@@ -485,7 +626,7 @@ func HandleCallbackQuery(update *tgbotapi.Update, bot *tgbotapi.BotAPI, chatSett
 				ChatId:          update.CallbackQuery.Message.Chat.ID,
 				FromUserId:      update.CallbackQuery.From.ID,
 				FileId:          "",
-				Frequency:       fmt.Sprintf("%v-%v", utils.REMINDER_ONCE, nextTriggerTime.Format(utils.DATE_FORMAT)),
+				Frequency:       schemas.NewOnceRecurrence(nextTriggerTime),
 				Time:            nextTriggerTime.Format(utils.TIME_ONLY_FORMAT),
 				ReminderText:    strings.TrimPrefix(reminderText, utils.REMINDER_PREFIX),
 				InConstruction:  false,
@@ -494,18 +635,19 @@ func HandleCallbackQuery(update *tgbotapi.Update, bot *tgbotapi.BotAPI, chatSett
 			if isImageReminder {
 				reminder.FileId = update.CallbackQuery.Message.Photo[0].FileID
 			}
-			err = reminder.Create()
+			err = reminder.Create(ctx)
 			if err != nil {
 				log.Error(err)
 				return
 			}
+			core.RecordAudit(ctx, reminder.ChatId, update.CallbackQuery.From, utils.AUDIT_CREATE, utils.AUDIT_ENTITY_REMINDER, reminder.Id, nil, reminder)
 			if isImageReminder {
 				editedMessage := tgbotapi.NewEditMessageCaption(
 					update.CallbackQuery.Message.Chat.ID,
 					update.CallbackQuery.Message.MessageID,
 					fmt.Sprintf("%v\n\nI will remind you again on %v", reminderText, nextTriggerTime.Format(utils.DATE_AND_TIME_FORMAT)),
 				)
-				if _, err := bot.Request(editedMessage); err != nil {
+				if _, err := core.Outbox.Request(ctx, editedMessage, utils.PRIORITY_INTERACTIVE); err != nil {
 					log.Error(err)
 					return
 				}
@@ -516,7 +658,7 @@ func HandleCallbackQuery(update *tgbotapi.Update, bot *tgbotapi.BotAPI, chatSett
 					update.CallbackQuery.Message.MessageID,
 					fmt.Sprintf("%v\n\nI will remind you again on %v", reminderText, nextTriggerTime.Format(utils.DATE_AND_TIME_FORMAT)),
 				)
-				if _, err := bot.Request(editedMessage); err != nil {
+				if _, err := core.Outbox.Request(ctx, editedMessage, utils.PRIORITY_INTERACTIVE); err != nil {
 					log.Error(err)
 					return
 				}
@@ -528,7 +670,7 @@ func HandleCallbackQuery(update *tgbotapi.Update, bot *tgbotapi.BotAPI, chatSett
 				ChatId:          update.CallbackQuery.Message.Chat.ID,
 				FromUserId:      update.CallbackQuery.From.ID,
 				FileId:          "",
-				Frequency:       fmt.Sprintf("%v-%v", utils.REMINDER_ONCE, nextTriggerTime.Format(utils.DATE_FORMAT)),
+				Frequency:       schemas.NewOnceRecurrence(nextTriggerTime),
 				Time:            nextTriggerTime.Format(utils.TIME_ONLY_FORMAT),
 				ReminderText:    strings.TrimPrefix(reminderText, utils.REMINDER_PREFIX),
 				InConstruction:  false,
@@ -537,18 +679,19 @@ func HandleCallbackQuery(update *tgbotapi.Update, bot *tgbotapi.BotAPI, chatSett
 			if isImageReminder {
 				reminder.FileId = update.CallbackQuery.Message.Photo[0].FileID
 			}
-			err = reminder.Create()
+			err = reminder.Create(ctx)
 			if err != nil {
 				log.Error(err)
 				return
 			}
+			core.RecordAudit(ctx, reminder.ChatId, update.CallbackQuery.From, utils.AUDIT_CREATE, utils.AUDIT_ENTITY_REMINDER, reminder.Id, nil, reminder)
 			if isImageReminder {
 				editedMessage := tgbotapi.NewEditMessageCaption(
 					update.CallbackQuery.Message.Chat.ID,
 					update.CallbackQuery.Message.MessageID,
 					fmt.Sprintf("%v\n\nI will remind you again on %v", reminderText, nextTriggerTime.Format(utils.DATE_AND_TIME_FORMAT)),
 				)
-				if _, err := bot.Request(editedMessage); err != nil {
+				if _, err := core.Outbox.Request(ctx, editedMessage, utils.PRIORITY_INTERACTIVE); err != nil {
 					log.Error(err)
 					return
 				}
@@ -559,7 +702,7 @@ func HandleCallbackQuery(update *tgbotapi.Update, bot *tgbotapi.BotAPI, chatSett
 					update.CallbackQuery.Message.MessageID,
 					fmt.Sprintf("%v\n\nI will remind you again on %v", reminderText, nextTriggerTime.Format(utils.DATE_AND_TIME_FORMAT)),
 				)
-				if _, err := bot.Request(editedMessage); err != nil {
+				if _, err := core.Outbox.Request(ctx, editedMessage, utils.PRIORITY_INTERACTIVE); err != nil {
 					log.Error(err)
 					return
 				}
@@ -571,7 +714,7 @@ func HandleCallbackQuery(update *tgbotapi.Update, bot *tgbotapi.BotAPI, chatSett
 				ChatId:          update.CallbackQuery.Message.Chat.ID,
 				FromUserId:      update.CallbackQuery.From.ID,
 				FileId:          "",
-				Frequency:       fmt.Sprintf("%v-%v", utils.REMINDER_ONCE, nextTriggerTime.Format(utils.DATE_FORMAT)),
+				Frequency:       schemas.NewOnceRecurrence(nextTriggerTime),
 				Time:            nextTriggerTime.Format(utils.TIME_ONLY_FORMAT),
 				ReminderText:    strings.TrimPrefix(reminderText, utils.REMINDER_PREFIX),
 				InConstruction:  false,
@@ -580,18 +723,19 @@ func HandleCallbackQuery(update *tgbotapi.Update, bot *tgbotapi.BotAPI, chatSett
 			if isImageReminder {
 				reminder.FileId = update.CallbackQuery.Message.Photo[0].FileID
 			}
-			err = reminder.Create()
+			err = reminder.Create(ctx)
 			if err != nil {
 				log.Error(err)
 				return
 			}
+			core.RecordAudit(ctx, reminder.ChatId, update.CallbackQuery.From, utils.AUDIT_CREATE, utils.AUDIT_ENTITY_REMINDER, reminder.Id, nil, reminder)
 			if isImageReminder {
 				editedMessage := tgbotapi.NewEditMessageCaption(
 					update.CallbackQuery.Message.Chat.ID,
 					update.CallbackQuery.Message.MessageID,
 					fmt.Sprintf("%v\n\nI will remind you again on %v", reminderText, nextTriggerTime.Format(utils.DATE_AND_TIME_FORMAT)),
 				)
-				if _, err := bot.Request(editedMessage); err != nil {
+				if _, err := core.Outbox.Request(ctx, editedMessage, utils.PRIORITY_INTERACTIVE); err != nil {
 					log.Error(err)
 					return
 				}
@@ -602,7 +746,7 @@ func HandleCallbackQuery(update *tgbotapi.Update, bot *tgbotapi.BotAPI, chatSett
 					update.CallbackQuery.Message.MessageID,
 					fmt.Sprintf("%v\n\nI will remind you again on %v", reminderText, nextTriggerTime.Format(utils.DATE_AND_TIME_FORMAT)),
 				)
-				if _, err := bot.Request(editedMessage); err != nil {
+				if _, err := core.Outbox.Request(ctx, editedMessage, utils.PRIORITY_INTERACTIVE); err != nil {
 					log.Error(err)
 					return
 				}
@@ -613,7 +757,7 @@ func HandleCallbackQuery(update *tgbotapi.Update, bot *tgbotapi.BotAPI, chatSett
 				ChatId:          update.CallbackQuery.Message.Chat.ID,
 				FromUserId:      update.CallbackQuery.From.ID,
 				FileId:          "",
-				Frequency:       "",
+				Frequency:       schemas.Recurrence{},
 				Time:            "",
 				ReminderText:    strings.TrimPrefix(reminderText, utils.REMINDER_PREFIX),
 				InConstruction:  true,
@@ -622,7 +766,7 @@ func HandleCallbackQuery(update *tgbotapi.Update, bot *tgbotapi.BotAPI, chatSett
 			if isImageReminder {
 				reminder.FileId = update.CallbackQuery.Message.Photo[0].FileID
 			}
-			err = reminder.Create()
+			err = reminder.Create(ctx)
 			if err != nil {
 				log.Error(err)
 				return
@@ -633,7 +777,7 @@ func HandleCallbackQuery(update *tgbotapi.Update, bot *tgbotapi.BotAPI, chatSett
 					update.CallbackQuery.Message.MessageID,
 					reminderText,
 				)
-				if _, err := bot.Request(editedMessage); err != nil {
+				if _, err := core.Outbox.Request(ctx, editedMessage, utils.PRIORITY_INTERACTIVE); err != nil {
 					log.Error(err)
 					return
 				}
@@ -644,7 +788,7 @@ func HandleCallbackQuery(update *tgbotapi.Update, bot *tgbotapi.BotAPI, chatSett
 					update.CallbackQuery.Message.MessageID,
 					reminderText,
 				)
-				if _, err := bot.Request(editedMessage); err != nil {
+				if _, err := core.Outbox.Request(ctx, editedMessage, utils.PRIORITY_INTERACTIVE); err != nil {
 					log.Error(err)
 					return
 				}
@@ -658,7 +802,7 @@ func HandleCallbackQuery(update *tgbotapi.Update, bot *tgbotapi.BotAPI, chatSett
 				ForceReply: true,
 				Selective:  true,
 			}
-			if _, err := bot.Request(newMsg); err != nil {
+			if _, err := core.Outbox.Request(ctx, newMsg, utils.PRIORITY_INTERACTIVE); err != nil {
 				log.Error(err)
 				return
 			}
@@ -669,7 +813,7 @@ func HandleCallbackQuery(update *tgbotapi.Update, bot *tgbotapi.BotAPI, chatSett
 					update.CallbackQuery.Message.MessageID,
 					reminderText,
 				)
-				if _, err := bot.Request(editedMessage); err != nil {
+				if _, err := core.Outbox.Request(ctx, editedMessage, utils.PRIORITY_INTERACTIVE); err != nil {
 					log.Error(err)
 					return
 				}
@@ -679,7 +823,7 @@ func HandleCallbackQuery(update *tgbotapi.Update, bot *tgbotapi.BotAPI, chatSett
 					update.CallbackQuery.Message.MessageID,
 					reminderText,
 				)
-				if _, err := bot.Request(editedMessage); err != nil {
+				if _, err := core.Outbox.Request(ctx, editedMessage, utils.PRIORITY_INTERACTIVE); err != nil {
 					log.Error(err)
 					return
 				}
@@ -690,9 +834,196 @@ func HandleCallbackQuery(update *tgbotapi.Update, bot *tgbotapi.BotAPI, chatSett
 		return
 	}
 
+	if strings.HasPrefix(update.CallbackQuery.Data, "im") {
+		format, action, userId := core.SplitCallbackImportData(update.CallbackQuery.Data)
+		if userId != update.CallbackQuery.From.ID {
+			callback := tgbotapi.NewCallback(update.CallbackQuery.ID, "Only the user who sent the import command can answer this.")
+			if _, err := core.Outbox.Request(ctx, callback, utils.PRIORITY_INTERACTIVE); err != nil {
+				log.Error(err)
+			}
+			return
+		}
+		if action == utils.CALLBACK_CANCEL {
+			editedMessage := tgbotapi.NewEditMessageText(
+				update.CallbackQuery.Message.Chat.ID,
+				update.CallbackQuery.Message.MessageID,
+				"Import cancelled.",
+			)
+			if _, err := core.Outbox.Request(ctx, editedMessage, utils.PRIORITY_INTERACTIVE); err != nil {
+				log.Error(err)
+			}
+			return
+		}
+		resultText := ""
+		documentMessage := update.CallbackQuery.Message.ReplyToMessage
+		if documentMessage == nil || documentMessage.Document == nil {
+			resultText = "The file to import is no longer available, please send it again."
+		} else if data, err := core.DownloadDocument(ctx, documentMessage.Document); err != nil {
+			log.Error(err)
+			resultText = fmt.Sprintf("Could not read the file: %v", err)
+		} else if plan, err := core.BuildImportPlan(ctx, format, data, chatSettings, update.CallbackQuery.From.ID); err != nil {
+			resultText = fmt.Sprintf("Could not import the file: %v", err)
+		} else {
+			created, err := core.ApplyImportPlan(ctx, plan, chatSettings, update.CallbackQuery.From)
+			if err != nil {
+				log.Error(err)
+				resultText = fmt.Sprintf("Import stopped after %v reminders because of an error.", created)
+			} else {
+				resultText = fmt.Sprintf("✅ Imported %v reminders. Use /list to see them.", created)
+			}
+		}
+		editedMessage := tgbotapi.NewEditMessageText(
+			update.CallbackQuery.Message.Chat.ID,
+			update.CallbackQuery.Message.MessageID,
+			resultText,
+		)
+		if _, err := core.Outbox.Request(ctx, editedMessage, utils.PRIORITY_INTERACTIVE); err != nil {
+			log.Error(err)
+		}
+		return
+	}
+
+	if strings.HasPrefix(update.CallbackQuery.Data, "mr_") {
+		policy := strings.TrimPrefix(update.CallbackQuery.Data, "mr_")
+		if _, ok := utils.MISSED_REMINDERS_DESCRIPTIONS[policy]; ok && policy != chatSettings.MissedReminderPolicy() {
+			previousChatSettings := *chatSettings
+			chatSettings.MissedReminders = policy
+			// reminders that are overdue right now are left to the new policy
+			err := chatSettings.Save(ctx)
+			if err != nil {
+				log.Error(err)
+				return
+			}
+			core.RecordAudit(ctx, chatSettings.ChatId, update.CallbackQuery.From, utils.AUDIT_UPDATE, utils.AUDIT_ENTITY_CHAT_SETTINGS, fmt.Sprint(chatSettings.ChatId), previousChatSettings, *chatSettings)
+		}
+		editedMessage := tgbotapi.NewEditMessageTextAndMarkup(
+			update.CallbackQuery.Message.Chat.ID,
+			update.CallbackQuery.Message.MessageID,
+			core.BuildMissedRemindersText(chatSettings),
+			core.BuildMissedRemindersMarkup(chatSettings),
+		)
+		editedMessage.ParseMode = "html"
+		if _, err := core.Outbox.Request(ctx, editedMessage, utils.PRIORITY_INTERACTIVE); err != nil {
+			log.Error(err)
+		}
+		return
+	}
+
+	if strings.HasPrefix(update.CallbackQuery.Data, "fm") {
+		action, userId := core.SplitCallbackForgetMeData(update.CallbackQuery.Data)
+		if userId != update.CallbackQuery.From.ID {
+			callback := tgbotapi.NewCallback(update.CallbackQuery.ID, "Only the user who sent /forgetme can answer this.")
+			if _, err := core.Outbox.Request(ctx, callback, utils.PRIORITY_INTERACTIVE); err != nil {
+				log.Error(err)
+			}
+			return
+		}
+		resultText := utils.CANCEL_OPERATION_MESSAGE
+		if action == utils.CALLBACK_CONFIRM {
+			erased, err := schemas.EraseUserData(ctx, userId)
+			if err != nil {
+				log.Error(err)
+				resultText = "Some of your data could not be deleted, please try /forgetme again later."
+			} else {
+				resultText = core.BuildErasedUserDataText(erased)
+			}
+		}
+		editedMessage := tgbotapi.NewEditMessageText(
+			update.CallbackQuery.Message.Chat.ID,
+			update.CallbackQuery.Message.MessageID,
+			resultText,
+		)
+		if _, err := core.Outbox.Request(ctx, editedMessage, utils.PRIORITY_INTERACTIVE); err != nil {
+			log.Error(err)
+		}
+		return
+	}
+
 	if strings.HasPrefix(update.CallbackQuery.Data, "lr") {
 		action, step, page := core.SplitCallbackListReminderData(update.CallbackQuery.Data)
-		chatReminders, err := schemas.ListChatReminders(update.CallbackQuery.Message.Chat.ID)
+		// handled before listing the chat's reminders, which is empty when the only reminder was deleted
+		if action == utils.CALLBACK_UNDO {
+			reminder, err := schemas.RestoreReminder(ctx, step)
+			if err != nil {
+				log.Error(err)
+				return
+			}
+			if reminder == nil {
+				editedMessage := tgbotapi.NewEditMessageTextAndMarkup(
+					update.CallbackQuery.Message.Chat.ID,
+					update.CallbackQuery.Message.MessageID,
+					"This reminder can no longer be restored",
+					tgbotapi.NewInlineKeyboardMarkup(
+						tgbotapi.NewInlineKeyboardRow(
+							tgbotapi.NewInlineKeyboardButtonData(
+								"Back to list",
+								core.GetCallbackListReminderData(utils.CALLBACK_GOTO, utils.CALLBACK_NO_ACTION, 1),
+							),
+						),
+					),
+				)
+				if _, err := core.Outbox.Request(ctx, editedMessage, utils.PRIORITY_INTERACTIVE); err != nil {
+					log.Error(err)
+					return
+				}
+				return
+			}
+			nextTriggerTime, err := reminder.CalculateNextTriggerTime(chatSettings)
+			if errors.Is(err, schemas.ErrRecurrenceEnded) {
+				// nothing left to schedule, so the reminder stays deleted
+				err = reminder.SoftDelete(ctx)
+				if err != nil {
+					log.Error(err)
+					return
+				}
+				editedMessage := tgbotapi.NewEditMessageTextAndMarkup(
+					update.CallbackQuery.Message.Chat.ID,
+					update.CallbackQuery.Message.MessageID,
+					"This reminder has no upcoming occurrences left",
+					tgbotapi.NewInlineKeyboardMarkup(
+						tgbotapi.NewInlineKeyboardRow(
+							tgbotapi.NewInlineKeyboardButtonData(
+								"Back to list",
+								core.GetCallbackListReminderData(utils.CALLBACK_GOTO, utils.CALLBACK_NO_ACTION, 1),
+							),
+						),
+					),
+				)
+				if _, err := core.Outbox.Request(ctx, editedMessage, utils.PRIORITY_INTERACTIVE); err != nil {
+					log.Error(err)
+					return
+				}
+				return
+			} else if err != nil {
+				log.Error(err)
+				return
+			}
+			reminder.NextTriggerTime = nextTriggerTime.Format(utils.DIRECTUS_DATETIME_FORMAT)
+			err = reminder.Update(ctx)
+			if err != nil {
+				log.Error(err)
+				return
+			}
+			core.RecordAudit(ctx, reminder.ChatId, update.CallbackQuery.From, utils.AUDIT_RESTORE, utils.AUDIT_ENTITY_REMINDER, reminder.Id, nil, *reminder)
+			msgText, replyMarkup, err := core.BuildReminderMenuTextAndMarkup(*reminder, chatSettings)
+			if err != nil {
+				log.Error(err)
+				return
+			}
+			editedMessage := tgbotapi.NewEditMessageTextAndMarkup(
+				update.CallbackQuery.Message.Chat.ID,
+				update.CallbackQuery.Message.MessageID,
+				msgText,
+				replyMarkup,
+			)
+			editedMessage.ParseMode = "html"
+			if _, err := core.Outbox.Request(ctx, editedMessage, utils.PRIORITY_INTERACTIVE); err != nil {
+				log.Error(err)
+				return
+			}
+			return
+		}
+		chatReminders, err := schemas.ListChatReminders(ctx, update.CallbackQuery.Message.Chat.ID)
 		if err != nil {
 			log.Error(err)
 			return
@@ -703,7 +1034,7 @@ func HandleCallbackQuery(update *tgbotapi.Update, bot *tgbotapi.BotAPI, chatSett
 				update.CallbackQuery.Message.MessageID,
 				"There are no reminders in this chat.",
 			)
-			if _, err := bot.Request(editedMessage); err != nil {
+			if _, err := core.Outbox.Request(ctx, editedMessage, utils.PRIORITY_INTERACTIVE); err != nil {
 				log.Error(err)
 				return
 			}
@@ -717,7 +1048,7 @@ func HandleCallbackQuery(update *tgbotapi.Update, bot *tgbotapi.BotAPI, chatSett
 					update.CallbackQuery.Message.MessageID,
 					utils.NO_REMINDERS_MESSAGE,
 				)
-				if _, err := bot.Request(editedMessage); err != nil {
+				if _, err := core.Outbox.Request(ctx, editedMessage, utils.PRIORITY_INTERACTIVE); err != nil {
 					log.Error(err)
 					return
 				}
@@ -729,14 +1060,14 @@ func HandleCallbackQuery(update *tgbotapi.Update, bot *tgbotapi.BotAPI, chatSett
 				msgText,
 				replyMarkup,
 			)
-			if _, err := bot.Request(editedMessage); err != nil {
+			if _, err := core.Outbox.Request(ctx, editedMessage, utils.PRIORITY_INTERACTIVE); err != nil {
 				log.Error(err)
 				return
 			}
 			return
 		}
 		if action == utils.CALLBACK_SELECT {
-			reminderPtr, err := schemas.GetReminderById(step)
+			reminderPtr, err := schemas.GetReminderById(ctx, step)
 			if err != nil {
 				log.Error(err)
 				return
@@ -755,7 +1086,7 @@ func HandleCallbackQuery(update *tgbotapi.Update, bot *tgbotapi.BotAPI, chatSett
 						),
 					),
 				)
-				if _, err := bot.Request(editedMessage); err != nil {
+				if _, err := core.Outbox.Request(ctx, editedMessage, utils.PRIORITY_INTERACTIVE); err != nil {
 					log.Error(err)
 					return
 				}
@@ -776,7 +1107,7 @@ func HandleCallbackQuery(update *tgbotapi.Update, bot *tgbotapi.BotAPI, chatSett
 						),
 					),
 				)
-				if _, err := bot.Request(editedMessage); err != nil {
+				if _, err := core.Outbox.Request(ctx, editedMessage, utils.PRIORITY_INTERACTIVE); err != nil {
 					log.Error(err)
 					return
 				}
@@ -789,14 +1120,14 @@ func HandleCallbackQuery(update *tgbotapi.Update, bot *tgbotapi.BotAPI, chatSett
 				replyMarkup,
 			)
 			editedMessage.ParseMode = "html"
-			if _, err := bot.Request(editedMessage); err != nil {
+			if _, err := core.Outbox.Request(ctx, editedMessage, utils.PRIORITY_INTERACTIVE); err != nil {
 				log.Error(err)
 				return
 			}
 			return
 		}
 		if action == utils.CALLBACK_DELETE {
-			reminder, err := schemas.GetReminderById(step)
+			reminder, err := schemas.GetReminderById(ctx, step)
 			if err != nil {
 				log.Error(err)
 				return
@@ -815,13 +1146,13 @@ func HandleCallbackQuery(update *tgbotapi.Update, bot *tgbotapi.BotAPI, chatSett
 						),
 					),
 				)
-				if _, err := bot.Request(editedMessage); err != nil {
+				if _, err := core.Outbox.Request(ctx, editedMessage, utils.PRIORITY_INTERACTIVE); err != nil {
 					log.Error(err)
 					return
 				}
 				return
 			}
-			err = reminder.Delete()
+			err = reminder.SoftDelete(ctx)
 			if err != nil {
 				editedMessage := tgbotapi.NewEditMessageTextAndMarkup(
 					update.CallbackQuery.Message.Chat.ID,
@@ -836,17 +1167,24 @@ func HandleCallbackQuery(update *tgbotapi.Update, bot *tgbotapi.BotAPI, chatSett
 						),
 					),
 				)
-				if _, err := bot.Request(editedMessage); err != nil {
+				if _, err := core.Outbox.Request(ctx, editedMessage, utils.PRIORITY_INTERACTIVE); err != nil {
 					log.Error(err)
 					return
 				}
 				return
 			}
+			core.RecordAudit(ctx, reminder.ChatId, update.CallbackQuery.From, utils.AUDIT_DELETE, utils.AUDIT_ENTITY_REMINDER, reminder.Id, *reminder, nil)
 			editedMessage := tgbotapi.NewEditMessageTextAndMarkup(
 				update.CallbackQuery.Message.Chat.ID,
 				update.CallbackQuery.Message.MessageID,
 				"Reminder has been deleted",
 				tgbotapi.NewInlineKeyboardMarkup(
+					tgbotapi.NewInlineKeyboardRow(
+						tgbotapi.NewInlineKeyboardButtonData(
+							"Undo",
+							core.GetCallbackListReminderData(utils.CALLBACK_UNDO, reminder.Id, 0),
+						),
+					),
 					tgbotapi.NewInlineKeyboardRow(
 						tgbotapi.NewInlineKeyboardButtonData(
 							"Back to list",
@@ -855,14 +1193,14 @@ func HandleCallbackQuery(update *tgbotapi.Update, bot *tgbotapi.BotAPI, chatSett
 					),
 				),
 			)
-			if _, err := bot.Request(editedMessage); err != nil {
+			if _, err := core.Outbox.Request(ctx, editedMessage, utils.PRIORITY_INTERACTIVE); err != nil {
 				log.Error(err)
 				return
 			}
 			return
 		}
 		if action == utils.CALLBACK_SHOW_IMAGE {
-			reminder, err := schemas.GetReminderById(step)
+			reminder, err := schemas.GetReminderById(ctx, step)
 			if err != nil {
 				log.Error(err)
 				return
@@ -881,7 +1219,7 @@ func HandleCallbackQuery(update *tgbotapi.Update, bot *tgbotapi.BotAPI, chatSett
 						),
 					),
 				)
-				if _, err := bot.Request(editedMessage); err != nil {
+				if _, err := core.Outbox.Request(ctx, editedMessage, utils.PRIORITY_INTERACTIVE); err != nil {
 					log.Error(err)
 					return
 				}
@@ -892,7 +1230,56 @@ func HandleCallbackQuery(update *tgbotapi.Update, bot *tgbotapi.BotAPI, chatSett
 				update.CallbackQuery.Message.Chat.ID,
 				tgbotapi.FileID(reminder.FileId),
 			)
-			if _, err := bot.Request(msg); err != nil {
+			if _, err := core.Outbox.Request(ctx, msg, utils.PRIORITY_INTERACTIVE); err != nil {
+				log.Error(err)
+				return
+			}
+			return
+		}
+		if action == utils.CALLBACK_HISTORY {
+			reminder, err := schemas.GetReminderById(ctx, step)
+			if err != nil {
+				log.Error(err)
+				return
+			}
+			if reminder == nil {
+				editedMessage := tgbotapi.NewEditMessageTextAndMarkup(
+					update.CallbackQuery.Message.Chat.ID,
+					update.CallbackQuery.Message.MessageID,
+					"Reminder not found",
+					tgbotapi.NewInlineKeyboardMarkup(
+						tgbotapi.NewInlineKeyboardRow(
+							tgbotapi.NewInlineKeyboardButtonData(
+								"Back to list",
+								core.GetCallbackListReminderData(utils.CALLBACK_GOTO, utils.CALLBACK_NO_ACTION, 1),
+							),
+						),
+					),
+				)
+				if _, err := core.Outbox.Request(ctx, editedMessage, utils.PRIORITY_INTERACTIVE); err != nil {
+					log.Error(err)
+					return
+				}
+				return
+			}
+			deliveries, err := schemas.ListReminderDeliveries(ctx, reminder.Id, utils.MAX_DELIVERIES_SHOWN)
+			if err != nil {
+				log.Error(err)
+				return
+			}
+			msgText, replyMarkup, err := core.BuildDeliveryHistoryTextAndMarkup(*reminder, deliveries, chatSettings)
+			if err != nil {
+				log.Error(err)
+				return
+			}
+			editedMessage := tgbotapi.NewEditMessageTextAndMarkup(
+				update.CallbackQuery.Message.Chat.ID,
+				update.CallbackQuery.Message.MessageID,
+				msgText,
+				replyMarkup,
+			)
+			editedMessage.ParseMode = "html"
+			if _, err := core.Outbox.Request(ctx, editedMessage, utils.PRIORITY_INTERACTIVE); err != nil {
 				log.Error(err)
 				return
 			}
@@ -0,0 +1,78 @@
+package schemas
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+)
+
+// AuditEntry records a change made by a Telegram user to a reminder or to the
+// chat settings. Before and After hold JSON snapshots of the entity, and are
+// empty when it did not exist before or after the change.
+type AuditEntry struct {
+	Id         string `json:"id"`
+	ChatId     int64  `json:"chat_id"`
+	UserId     int64  `json:"user_id"`
+	UserName   string `json:"user_name"`
+	Action     string `json:"action"`
+	EntityType string `json:"entity_type"`
+	EntityId   string `json:"entity_id"`
+	Before     string `json:"before"`
+	After      string `json:"after"`
+	// CreatedAt is in DIRECTUS_DATETIME_FORMAT, in UTC.
+	CreatedAt string `json:"created_at"`
+}
+
+// MarshalJSON implements the json.Marshaler interface.
+func (a AuditEntry) MarshalJSON() ([]byte, error) {
+	type Alias AuditEntry // Prevent recursion
+
+	aux := &struct {
+		ChatId string `json:"chat_id"`
+		UserId string `json:"user_id"`
+		*Alias
+	}{
+		ChatId: strconv.FormatInt(a.ChatId, 10),
+		UserId: strconv.FormatInt(a.UserId, 10),
+		Alias:  (*Alias)(&a),
+	}
+	return json.Marshal(aux)
+}
+
+// UnmarshalJSON implements the json.Unmarshaler interface.
+func (a *AuditEntry) UnmarshalJSON(data []byte) error {
+	type Alias AuditEntry // Prevent recursion
+
+	aux := &struct {
+		ChatId string `json:"chat_id"`
+		UserId string `json:"user_id"`
+		*Alias
+	}{
+		Alias: (*Alias)(a),
+	}
+
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+
+	chatId, err := strconv.ParseInt(aux.ChatId, 10, 64)
+	if err != nil {
+		return err
+	}
+	a.ChatId = chatId
+	userId, err := strconv.ParseInt(aux.UserId, 10, 64)
+	if err != nil {
+		return err
+	}
+	a.UserId = userId
+	return nil
+}
+
+func (auditEntry AuditEntry) Create(ctx context.Context) error {
+	return Store.CreateAuditEntry(ctx, auditEntry)
+}
+
+// ListChatAuditEntries returns the latest audit entries of a chat, newest first.
+func ListChatAuditEntries(ctx context.Context, chatId int64, limit int) ([]AuditEntry, error) {
+	return Store.ListChatAuditEntries(ctx, chatId, limit)
+}
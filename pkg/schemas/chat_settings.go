@@ -1,11 +1,9 @@
 package schemas
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
-	"fmt"
-	"io"
-	"net/http"
+	"errors"
 	"strconv"
 
 	"github.com/Jason-CKY/telegram-reminderbot/pkg/utils"
@@ -15,6 +13,18 @@ type ChatSettings struct {
 	ChatId   int64  `json:"chat_id"`
 	Timezone string `json:"timezone"`
 	Updating bool   `json:"updating"`
+	// MissedReminders is one of the utils.MISSED_REMINDERS_* policies
+	MissedReminders string `json:"missed_reminders,omitempty"`
+}
+
+// MissedReminderPolicy returns the chat's policy for missed reminders, which
+// is utils.MISSED_REMINDERS_COLLAPSE for chats that never chose one, so
+// a reminder missed several times does not flood the chat.
+func (chatSettings ChatSettings) MissedReminderPolicy() string {
+	if _, ok := utils.MISSED_REMINDERS_DESCRIPTIONS[chatSettings.MissedReminders]; ok {
+		return chatSettings.MissedReminders
+	}
+	return utils.MISSED_REMINDERS_COLLAPSE
 }
 
 // MarshalJSON implements the json.Marshaler interface.
@@ -54,61 +64,36 @@ func (cs *ChatSettings) UnmarshalJSON(data []byte) error {
 	return nil
 }
 
-func (chatSettings ChatSettings) Create() error {
-	endpoint := fmt.Sprintf("%v/items/reminderbot_chat_settings", utils.DirectusHost)
-	reqBody, _ := json.Marshal(chatSettings)
-	req, httpErr := http.NewRequest(http.MethodPost, endpoint, bytes.NewBuffer(reqBody))
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %v", utils.DirectusToken))
-	if httpErr != nil {
-		return httpErr
-	}
-	client := &http.Client{}
-	res, httpErr := client.Do(req)
-	if httpErr != nil {
-		return httpErr
-	}
-	body, _ := io.ReadAll(res.Body)
-	defer res.Body.Close()
-	if res.StatusCode != 200 {
-		return fmt.Errorf("error inserting chat settings to directus: %v", string(body))
-	}
+func (chatSettings ChatSettings) Create(ctx context.Context) error {
+	return Store.CreateChatSettings(ctx, chatSettings)
+}
 
-	return nil
+// Save stores the settings without touching the chat's reminders, for changes
+// that do not move their trigger times.
+func (chatSettings ChatSettings) Save(ctx context.Context) error {
+	return Store.UpdateChatSettings(ctx, chatSettings)
 }
 
-func (chatSettings ChatSettings) Update() error {
-	endpoint := fmt.Sprintf("%v/items/reminderbot_chat_settings/%v", utils.DirectusHost, chatSettings.ChatId)
-	reqBody, _ := json.Marshal(chatSettings)
-	req, httpErr := http.NewRequest(http.MethodPatch, endpoint, bytes.NewBuffer(reqBody))
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %v", utils.DirectusToken))
-	if httpErr != nil {
-		return httpErr
-	}
-	client := &http.Client{}
-	res, httpErr := client.Do(req)
-	if httpErr != nil {
-		return httpErr
-	}
-	body, _ := io.ReadAll(res.Body)
-	defer res.Body.Close()
-	if res.StatusCode != 200 {
-		return fmt.Errorf("error updating chat settings to directus: %v", string(body))
+func (chatSettings ChatSettings) Update(ctx context.Context) error {
+	err := Store.UpdateChatSettings(ctx, chatSettings)
+	if err != nil {
+		return err
 	}
 
 	// update all reminders in this chat with their new chat settings
-	reminders, err := GetRemindersByChatId(chatSettings.ChatId)
+	reminders, err := GetRemindersByChatId(ctx, chatSettings.ChatId)
 	if err != nil {
 		return err
 	}
 	for _, reminder := range reminders {
 		nextTriggerTime, err := reminder.CalculateNextTriggerTime(&chatSettings)
-		if err != nil {
+		if errors.Is(err, ErrRecurrenceEnded) {
+			continue
+		} else if err != nil {
 			return err
 		}
 		reminder.NextTriggerTime = nextTriggerTime.Format(utils.DIRECTUS_DATETIME_FORMAT)
-		err = reminder.Update()
+		err = reminder.Update(ctx)
 		if err != nil {
 			return err
 		}
@@ -118,70 +103,16 @@ func (chatSettings ChatSettings) Update() error {
 
 }
 
-func (chatSettings ChatSettings) Delete() error {
-	endpoint := fmt.Sprintf("%v/items/reminderbot_chat_settings/%v", utils.DirectusHost, chatSettings.ChatId)
-	req, httpErr := http.NewRequest(http.MethodDelete, endpoint, nil)
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %v", utils.DirectusToken))
-	if httpErr != nil {
-		return httpErr
-	}
-	client := &http.Client{}
-	res, httpErr := client.Do(req)
-	if httpErr != nil {
-		return httpErr
-	}
-	body, _ := io.ReadAll(res.Body)
-	defer res.Body.Close()
-	if res.StatusCode != 204 {
-		return fmt.Errorf("error deleting chat settings in directus: %v", string(body))
-	}
-	return nil
+func (chatSettings ChatSettings) Delete(ctx context.Context) error {
+	return Store.DeleteChatSettings(ctx, chatSettings.ChatId)
 }
 
-func GetChatSettings(chatId int64) (*ChatSettings, error) {
-	endpoint := fmt.Sprintf("%v/items/reminderbot_chat_settings", utils.DirectusHost)
-	reqBody := []byte(fmt.Sprintf(`{
-		"query": {
-			"filter": {
-				"chat_id": {
-					"_eq": "%v"
-				}
-			}
-		}
-	}`, chatId))
-	req, httpErr := http.NewRequest("SEARCH", endpoint, bytes.NewBuffer(reqBody))
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %v", utils.DirectusToken))
-	if httpErr != nil {
-		return nil, httpErr
-	}
-	client := &http.Client{}
-	res, httpErr := client.Do(req)
-	if httpErr != nil {
-		return nil, httpErr
-	}
-	defer res.Body.Close()
-	body, _ := io.ReadAll(res.Body)
-	if res.StatusCode != 200 {
-		return nil, fmt.Errorf("error getting chat settings in directus: %v", string(body))
-	}
-	var chatSettingsResponse map[string][]ChatSettings
-	jsonErr := json.Unmarshal(body, &chatSettingsResponse)
-	// error handling for json unmarshaling
-	if jsonErr != nil {
-		return nil, jsonErr
-	}
-
-	if len(chatSettingsResponse["data"]) == 0 {
-		return nil, nil
-	}
-
-	return &chatSettingsResponse["data"][0], nil
+func GetChatSettings(ctx context.Context, chatId int64) (*ChatSettings, error) {
+	return Store.GetChatSettings(ctx, chatId)
 }
 
-func InsertChatSettingsIfNotPresent(chatId int64) (*ChatSettings, bool, error) {
-	chatSettings, err := GetChatSettings(chatId)
+func InsertChatSettingsIfNotPresent(ctx context.Context, chatId int64) (*ChatSettings, bool, error) {
+	chatSettings, err := GetChatSettings(ctx, chatId)
 	if err != nil {
 		return nil, false, err
 	}
@@ -191,7 +122,7 @@ func InsertChatSettingsIfNotPresent(chatId int64) (*ChatSettings, bool, error) {
 			Timezone: utils.DEFAULT_TIMEZONE,
 			Updating: false,
 		}
-		err := chatSettings.Create()
+		err := chatSettings.Create(ctx)
 		if err != nil {
 			return nil, false, err
 		}
@@ -201,19 +132,6 @@ func InsertChatSettingsIfNotPresent(chatId int64) (*ChatSettings, bool, error) {
 	return chatSettings, true, nil
 }
 
-func MigrateChatSettingsChatId(fromChatId int64, toChatId int64) error {
-	oldChatSettings, err := GetChatSettings(fromChatId)
-	if err != nil {
-		return err
-	}
-	err = oldChatSettings.Delete()
-	if err != nil {
-		return err
-	}
-	oldChatSettings.ChatId = toChatId
-	err = oldChatSettings.Create()
-	if err != nil {
-		return err
-	}
-	return nil
+func MigrateChat(ctx context.Context, fromChatId int64, toChatId int64) error {
+	return Store.MigrateChat(ctx, fromChatId, toChatId)
 }
@@ -0,0 +1,148 @@
+package schemas
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Jason-CKY/telegram-reminderbot/pkg/utils"
+	log "github.com/sirupsen/logrus"
+)
+
+// CachedStore wraps another store and keeps chat settings in memory, as they
+// are read for every incoming update and every fired reminder. Writes made
+// through the store update the cache, and entries expire after the ttl so that
+// changes made elsewhere (another instance, the Directus admin app) are picked
+// up. Call InvalidateChatSettings to drop them sooner.
+type CachedStore struct {
+	ReminderStore
+	ttl time.Duration
+
+	mu           sync.Mutex
+	chatSettings map[int64]cachedChatSettings
+	// generation is bumped on every write and invalidation, so that a read that
+	// raced with one does not put stale settings back into the cache.
+	generation uint64
+}
+
+type cachedChatSettings struct {
+	chatSettings ChatSettings
+	expiresAt    time.Time
+}
+
+func NewCachedStore(store ReminderStore, ttl time.Duration) *CachedStore {
+	return &CachedStore{
+		ReminderStore: store,
+		ttl:           ttl,
+		chatSettings:  map[int64]cachedChatSettings{},
+	}
+}
+
+func (store *CachedStore) put(chatSettings ChatSettings) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	store.generation++
+	store.chatSettings[chatSettings.ChatId] = cachedChatSettings{
+		chatSettings: chatSettings,
+		expiresAt:    time.Now().Add(store.ttl),
+	}
+}
+
+// InvalidateChatSettings drops the cached settings of the given chats, or of
+// every chat when none are given.
+func (store *CachedStore) InvalidateChatSettings(chatIds ...int64) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	store.generation++
+	if len(chatIds) == 0 {
+		store.chatSettings = map[int64]cachedChatSettings{}
+		return
+	}
+	for _, chatId := range chatIds {
+		delete(store.chatSettings, chatId)
+	}
+}
+
+// GetChatSettings returns a copy of the cached settings, so callers can change
+// them freely. Missing settings are not cached, they are created right after.
+func (store *CachedStore) GetChatSettings(ctx context.Context, chatId int64) (*ChatSettings, error) {
+	store.mu.Lock()
+	cached, ok := store.chatSettings[chatId]
+	generation := store.generation
+	store.mu.Unlock()
+	if ok && time.Now().Before(cached.expiresAt) {
+		chatSettings := cached.chatSettings
+		return &chatSettings, nil
+	}
+
+	chatSettings, err := store.ReminderStore.GetChatSettings(ctx, chatId)
+	if err != nil || chatSettings == nil {
+		return chatSettings, err
+	}
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	if store.generation == generation {
+		store.chatSettings[chatId] = cachedChatSettings{
+			chatSettings: *chatSettings,
+			expiresAt:    time.Now().Add(store.ttl),
+		}
+	}
+	return chatSettings, nil
+}
+
+func (store *CachedStore) CreateChatSettings(ctx context.Context, chatSettings ChatSettings) error {
+	err := store.ReminderStore.CreateChatSettings(ctx, chatSettings)
+	if err != nil {
+		store.InvalidateChatSettings(chatSettings.ChatId)
+		return err
+	}
+	store.put(chatSettings)
+	return nil
+}
+
+func (store *CachedStore) UpdateChatSettings(ctx context.Context, chatSettings ChatSettings) error {
+	err := store.ReminderStore.UpdateChatSettings(ctx, chatSettings)
+	if err != nil {
+		// the update may still have been applied
+		store.InvalidateChatSettings(chatSettings.ChatId)
+		return err
+	}
+	store.put(chatSettings)
+	return nil
+}
+
+func (store *CachedStore) DeleteChatSettings(ctx context.Context, chatId int64) error {
+	defer store.InvalidateChatSettings(chatId)
+	return store.ReminderStore.DeleteChatSettings(ctx, chatId)
+}
+
+func (store *CachedStore) EraseUserData(ctx context.Context, userId int64) (ErasedUserData, error) {
+	defer store.InvalidateChatSettings(userId)
+	return store.ReminderStore.EraseUserData(ctx, userId)
+}
+
+func (store *CachedStore) MigrateChat(ctx context.Context, fromChatId int64, toChatId int64) error {
+	defer store.InvalidateChatSettings(fromChatId, toChatId)
+	return store.ReminderStore.MigrateChat(ctx, fromChatId, toChatId)
+}
+
+// HandleChangeEvent drops the cached settings of chats changed outside the bot.
+func (store *CachedStore) HandleChangeEvent(ctx context.Context, event ChangeEvent) {
+	if event.Collection != "reminderbot_chat_settings" || event.ChatSettings != nil {
+		// settings written by the bot were cached when they were written
+		return
+	}
+	if event.Action == utils.CHANGE_RESYNC {
+		store.InvalidateChatSettings()
+		return
+	}
+	for _, key := range event.Keys {
+		chatId, err := strconv.ParseInt(key, 10, 64)
+		if err != nil {
+			log.Error(err)
+			continue
+		}
+		store.InvalidateChatSettings(chatId)
+	}
+}
@@ -0,0 +1,67 @@
+package schemas
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+)
+
+// Delivery records one attempt at sending a reminder. Deliveries are kept after
+// the reminder itself is deleted, so that missed reminders can be looked into.
+type Delivery struct {
+	Id         string `json:"id"`
+	ReminderId string `json:"reminder_id"`
+	ChatId     int64  `json:"chat_id"`
+	// ScheduledTime and SentTime are in DIRECTUS_DATETIME_FORMAT, in UTC.
+	ScheduledTime string `json:"scheduled_time"`
+	SentTime      string `json:"sent_time"`
+	MessageId     int    `json:"message_id"`
+	Outcome       string `json:"outcome"`
+	Error         string `json:"error"`
+}
+
+// MarshalJSON implements the json.Marshaler interface.
+func (d Delivery) MarshalJSON() ([]byte, error) {
+	type Alias Delivery // Prevent recursion
+
+	aux := &struct {
+		ChatId string `json:"chat_id"`
+		*Alias
+	}{
+		ChatId: strconv.FormatInt(d.ChatId, 10),
+		Alias:  (*Alias)(&d),
+	}
+	return json.Marshal(aux)
+}
+
+// UnmarshalJSON implements the json.Unmarshaler interface.
+func (d *Delivery) UnmarshalJSON(data []byte) error {
+	type Alias Delivery // Prevent recursion
+
+	aux := &struct {
+		ChatId string `json:"chat_id"`
+		*Alias
+	}{
+		Alias: (*Alias)(d),
+	}
+
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+
+	chatId, err := strconv.ParseInt(aux.ChatId, 10, 64)
+	if err != nil {
+		return err
+	}
+	d.ChatId = chatId
+	return nil
+}
+
+func (delivery Delivery) Create(ctx context.Context) error {
+	return Store.CreateDelivery(ctx, delivery)
+}
+
+// ListReminderDeliveries returns the latest deliveries of a reminder, newest first.
+func ListReminderDeliveries(ctx context.Context, reminderId string, limit int) ([]Delivery, error) {
+	return Store.ListReminderDeliveries(ctx, reminderId, limit)
+}
@@ -0,0 +1,316 @@
+package schemas
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/Jason-CKY/telegram-reminderbot/pkg/utils"
+	log "github.com/sirupsen/logrus"
+)
+
+// directusMigration is one versioned change to the Directus data model. Apply
+// must be safe to run again if a previous attempt failed half way.
+type directusMigration struct {
+	version     int
+	description string
+	apply       func(ctx context.Context, store *DirectusStore) error
+}
+
+// directusMigrations must only ever be appended to.
+var directusMigrations = []directusMigration{
+	{
+		version:     1,
+		description: "create reminder and chat settings collections",
+		apply: func(ctx context.Context, store *DirectusStore) error {
+			err := store.ensureCollection(ctx, "reminderbot_chat_settings", `{"collection":"reminderbot_chat_settings","fields":[{"field":"chat_id","type":"bigInteger","meta":{"hidden":true,"interface":"input","readonly":true},"schema":{"is_primary_key":true,"has_auto_increment":true}},{"field":"date_created","type":"timestamp","meta":{"special":["date-created"],"interface":"datetime","readonly":true,"hidden":true,"width":"half","display":"datetime","display_options":{"relative":true}},"schema":{}},{"field":"date_updated","type":"timestamp","meta":{"special":["date-updated"],"interface":"datetime","readonly":true,"hidden":true,"width":"half","display":"datetime","display_options":{"relative":true}},"schema":{}}],"schema":{},"meta":{"singleton":false}}`)
+			if err != nil {
+				return err
+			}
+			err = store.ensureField(ctx, "reminderbot_chat_settings", "timezone", `{"type":"string","meta":{"interface":"input","special":null,"required":true},"field":"timezone"}`)
+			if err != nil {
+				return err
+			}
+			err = store.ensureField(ctx, "reminderbot_chat_settings", "updating", `{"type":"boolean","meta":{"interface":"boolean","special":["cast-boolean"]},"field":"updating","schema":{"default_value":false}}`)
+			if err != nil {
+				return err
+			}
+
+			err = store.ensureCollection(ctx, "reminderbot_reminder", `{"collection":"reminderbot_reminder","fields":[{"field":"id","type":"uuid","meta":{"hidden":true,"readonly":true,"interface":"input","special":["uuid"]},"schema":{"is_primary_key":true,"length":36,"has_auto_increment":false}},{"field":"date_created","type":"timestamp","meta":{"special":["date-created"],"interface":"datetime","readonly":true,"hidden":true,"width":"half","display":"datetime","display_options":{"relative":true}},"schema":{}},{"field":"date_updated","type":"timestamp","meta":{"special":["date-updated"],"interface":"datetime","readonly":true,"hidden":true,"width":"half","display":"datetime","display_options":{"relative":true}},"schema":{}}],"schema":{},"meta":{"singleton":false}}`)
+			if err != nil {
+				return err
+			}
+			reminderFields := []struct {
+				field   string
+				payload string
+			}{
+				{"chat_id", `{"type":"bigInteger","meta":{"interface":"select-dropdown-m2o","special":["m2o"],"required":true,"options":{"template":"{{chat_id}}"}},"field":"chat_id"}`},
+				{"from_user_id", `{"field":"from_user_id","type":"bigInteger","schema":{},"meta":{"interface":"input","special":null,"required":true}}`},
+				{"file_id", `{"field":"file_id","type":"string","schema":{},"meta":{"interface":"input","special":null}}`},
+				{"reminder_text", `{"type":"text","meta":{"interface":"input-multiline","special":null},"field":"reminder_text"}`},
+				{"frequency", `{"field":"frequency","type":"string","schema":{},"meta":{"interface":"input","special":null}}`},
+				{"time", `{"field":"time","type":"string","schema":{},"meta":{"interface":"input","special":null}}`},
+				{"in_construction", `{"type":"boolean","meta":{"interface":"boolean","special":["cast-boolean"],"required":true},"field":"in_construction","schema":{"default_value":true}}`},
+				{"next_trigger_time", `{"type":"dateTime","meta":{"interface":"datetime","special":null,"required":false,"options":{"includeSeconds":true}},"field":"next_trigger_time"}`},
+			}
+			for _, reminderField := range reminderFields {
+				err = store.ensureField(ctx, "reminderbot_reminder", reminderField.field, reminderField.payload)
+				if err != nil {
+					return err
+				}
+			}
+			return store.ensureRelation(ctx, "reminderbot_reminder", "chat_id", `{"collection":"reminderbot_reminder","field":"chat_id","related_collection":"reminderbot_chat_settings","meta":{"sort_field":null},"schema":{"on_delete":"SET NULL"}}`)
+		},
+	},
+	{
+		version:     2,
+		description: "add reminder lease fields",
+		apply: func(ctx context.Context, store *DirectusStore) error {
+			err := store.ensureField(ctx, "reminderbot_reminder", "lease_owner", `{"field":"lease_owner","type":"string","schema":{},"meta":{"interface":"input","special":null,"hidden":true}}`)
+			if err != nil {
+				return err
+			}
+			return store.ensureField(ctx, "reminderbot_reminder", "lease_expires_at", `{"field":"lease_expires_at","type":"dateTime","schema":{},"meta":{"interface":"datetime","special":null,"hidden":true,"options":{"includeSeconds":true}}}`)
+		},
+	},
+	{
+		version:     3,
+		description: "create delivery collection",
+		apply: func(ctx context.Context, store *DirectusStore) error {
+			err := store.ensureCollection(ctx, "reminderbot_delivery", `{"collection":"reminderbot_delivery","fields":[{"field":"id","type":"uuid","meta":{"hidden":true,"readonly":true,"interface":"input","special":["uuid"]},"schema":{"is_primary_key":true,"length":36,"has_auto_increment":false}}],"schema":{},"meta":{"singleton":false}}`)
+			if err != nil {
+				return err
+			}
+			// no relation to reminderbot_reminder, deliveries outlive the reminders they belong to
+			deliveryFields := []struct {
+				field   string
+				payload string
+			}{
+				{"reminder_id", `{"field":"reminder_id","type":"uuid","schema":{"is_indexed":true},"meta":{"interface":"input","special":null,"required":true}}`},
+				{"chat_id", `{"field":"chat_id","type":"bigInteger","schema":{},"meta":{"interface":"input","special":null,"required":true}}`},
+				{"scheduled_time", `{"field":"scheduled_time","type":"dateTime","schema":{},"meta":{"interface":"datetime","special":null,"options":{"includeSeconds":true}}}`},
+				{"sent_time", `{"field":"sent_time","type":"dateTime","schema":{},"meta":{"interface":"datetime","special":null,"options":{"includeSeconds":true}}}`},
+				{"message_id", `{"field":"message_id","type":"bigInteger","schema":{},"meta":{"interface":"input","special":null}}`},
+				{"outcome", `{"field":"outcome","type":"string","schema":{},"meta":{"interface":"input","special":null,"required":true}}`},
+				{"error", `{"field":"error","type":"text","schema":{},"meta":{"interface":"input-multiline","special":null}}`},
+			}
+			for _, deliveryField := range deliveryFields {
+				err = store.ensureField(ctx, "reminderbot_delivery", deliveryField.field, deliveryField.payload)
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	},
+	{
+		version:     4,
+		description: "create audit log collection",
+		apply: func(ctx context.Context, store *DirectusStore) error {
+			err := store.ensureCollection(ctx, "reminderbot_audit_log", `{"collection":"reminderbot_audit_log","fields":[{"field":"id","type":"uuid","meta":{"hidden":true,"readonly":true,"interface":"input","special":["uuid"]},"schema":{"is_primary_key":true,"length":36,"has_auto_increment":false}}],"schema":{},"meta":{"singleton":false}}`)
+			if err != nil {
+				return err
+			}
+			auditFields := []struct {
+				field   string
+				payload string
+			}{
+				{"chat_id", `{"field":"chat_id","type":"bigInteger","schema":{"is_indexed":true},"meta":{"interface":"input","special":null,"required":true}}`},
+				{"user_id", `{"field":"user_id","type":"bigInteger","schema":{},"meta":{"interface":"input","special":null,"required":true}}`},
+				{"user_name", `{"field":"user_name","type":"string","schema":{},"meta":{"interface":"input","special":null}}`},
+				{"action", `{"field":"action","type":"string","schema":{},"meta":{"interface":"input","special":null,"required":true}}`},
+				{"entity_type", `{"field":"entity_type","type":"string","schema":{},"meta":{"interface":"input","special":null,"required":true}}`},
+				{"entity_id", `{"field":"entity_id","type":"string","schema":{},"meta":{"interface":"input","special":null,"required":true}}`},
+				{"before", `{"field":"before","type":"text","schema":{},"meta":{"interface":"input-code","special":null,"options":{"language":"JSON"}}}`},
+				{"after", `{"field":"after","type":"text","schema":{},"meta":{"interface":"input-code","special":null,"options":{"language":"JSON"}}}`},
+				{"created_at", `{"field":"created_at","type":"dateTime","schema":{},"meta":{"interface":"datetime","special":null,"required":true,"options":{"includeSeconds":true}}}`},
+			}
+			for _, auditField := range auditFields {
+				err = store.ensureField(ctx, "reminderbot_audit_log", auditField.field, auditField.payload)
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	},
+	{
+		version:     5,
+		description: "add reminder soft delete field",
+		apply: func(ctx context.Context, store *DirectusStore) error {
+			return store.ensureField(ctx, "reminderbot_reminder", "deleted_at", `{"field":"deleted_at","type":"dateTime","schema":{},"meta":{"interface":"datetime","special":null,"hidden":true,"options":{"includeSeconds":true}}}`)
+		},
+	},
+	{
+		version:     6,
+		description: "widen reminder file id field for encrypted values",
+		apply: func(ctx context.Context, store *DirectusStore) error {
+			status, body, err := store.directusRequest(ctx, http.MethodPatch, "/fields/reminderbot_reminder/file_id", []byte(`{"type":"text","meta":{"interface":"input-multiline"}}`))
+			if err != nil {
+				return err
+			}
+			if status != 200 {
+				return fmt.Errorf("error updating field reminderbot_reminder.file_id in directus: %v", string(body))
+			}
+			return nil
+		},
+	},
+	{
+		version:     7,
+		description: "add chat settings missed reminders field",
+		apply: func(ctx context.Context, store *DirectusStore) error {
+			return store.ensureField(ctx, "reminderbot_chat_settings", "missed_reminders", `{"field":"missed_reminders","type":"string","schema":{"default_value":"collapse"},"meta":{"interface":"select-dropdown","special":null,"options":{"choices":[{"text":"Send once","value":"collapse"},{"text":"Send each late","value":"late"},{"text":"Skip","value":"skip"}]}}}`)
+		},
+	},
+}
+
+// directusRequest sends an authenticated request to the Directus API and
+// returns the status code together with the response body.
+func (store *DirectusStore) directusRequest(ctx context.Context, method string, path string, reqBody []byte) (int, []byte, error) {
+	endpoint := fmt.Sprintf("%v%v", store.Host, path)
+	var reader io.Reader
+	if reqBody != nil {
+		reader = bytes.NewBuffer(reqBody)
+	}
+	req, httpErr := http.NewRequestWithContext(ctx, method, endpoint, reader)
+	if httpErr != nil {
+		return 0, nil, httpErr
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %v", store.Token))
+	client := &http.Client{}
+	res, httpErr := client.Do(req)
+	if httpErr != nil {
+		return 0, nil, httpErr
+	}
+	body, _ := io.ReadAll(res.Body)
+	defer res.Body.Close()
+	return res.StatusCode, body, nil
+}
+
+// exists reports whether a schema object is present. Directus answers 403 instead
+// of 404 for collections that do not exist, so both count as missing.
+func (store *DirectusStore) exists(ctx context.Context, path string) (bool, error) {
+	status, body, err := store.directusRequest(ctx, http.MethodGet, path, nil)
+	if err != nil {
+		return false, err
+	}
+	switch status {
+	case 200:
+		return true, nil
+	case 403, 404:
+		return false, nil
+	default:
+		return false, fmt.Errorf("error reading %v from directus: %v", path, string(body))
+	}
+}
+
+func (store *DirectusStore) ensureCollection(ctx context.Context, collection string, payload string) error {
+	exists, err := store.exists(ctx, fmt.Sprintf("/collections/%v", collection))
+	if err != nil || exists {
+		return err
+	}
+	log.Infof("creating directus collection %v", collection)
+	status, body, err := store.directusRequest(ctx, http.MethodPost, "/collections", []byte(payload))
+	if err != nil {
+		return err
+	}
+	if status != 200 {
+		return fmt.Errorf("error creating collection %v in directus: %v", collection, string(body))
+	}
+	return nil
+}
+
+func (store *DirectusStore) ensureField(ctx context.Context, collection string, field string, payload string) error {
+	exists, err := store.exists(ctx, fmt.Sprintf("/fields/%v/%v", collection, field))
+	if err != nil || exists {
+		return err
+	}
+	log.Infof("creating directus field %v.%v", collection, field)
+	status, body, err := store.directusRequest(ctx, http.MethodPost, fmt.Sprintf("/fields/%v", collection), []byte(payload))
+	if err != nil {
+		return err
+	}
+	if status != 200 {
+		return fmt.Errorf("error creating field %v.%v in directus: %v", collection, field, string(body))
+	}
+	return nil
+}
+
+func (store *DirectusStore) ensureRelation(ctx context.Context, collection string, field string, payload string) error {
+	exists, err := store.exists(ctx, fmt.Sprintf("/relations/%v/%v", collection, field))
+	if err != nil || exists {
+		return err
+	}
+	log.Infof("creating directus relation %v.%v", collection, field)
+	status, body, err := store.directusRequest(ctx, http.MethodPost, "/relations", []byte(payload))
+	if err != nil {
+		return err
+	}
+	if status != 200 {
+		return fmt.Errorf("error creating relation %v.%v in directus: %v", collection, field, string(body))
+	}
+	return nil
+}
+
+func (store *DirectusStore) schemaVersion(ctx context.Context) (int, error) {
+	status, body, err := store.directusRequest(ctx, http.MethodGet, "/items/reminderbot_schema_migrations?sort=-version&limit=1", nil)
+	if err != nil {
+		return 0, err
+	}
+	if status != 200 {
+		return 0, fmt.Errorf("error reading schema version from directus: %v", string(body))
+	}
+	var migrationResponse map[string][]struct {
+		Version int `json:"version"`
+	}
+	jsonErr := json.Unmarshal(body, &migrationResponse)
+	// error handling for json unmarshaling
+	if jsonErr != nil {
+		return 0, jsonErr
+	}
+	if len(migrationResponse["data"]) == 0 {
+		return 0, nil
+	}
+	return migrationResponse["data"][0].Version, nil
+}
+
+// Migrate creates or upgrades the Directus collections, fields and relations
+// used by the bot. The static token must belong to an admin user.
+func (store *DirectusStore) Migrate(ctx context.Context) error {
+	err := store.ensureCollection(ctx, "reminderbot_schema_migrations", `{"collection":"reminderbot_schema_migrations","fields":[{"field":"version","type":"integer","meta":{"interface":"input","readonly":true},"schema":{"is_primary_key":true,"has_auto_increment":false}},{"field":"description","type":"string","meta":{"interface":"input","readonly":true},"schema":{}},{"field":"applied_at","type":"dateTime","meta":{"interface":"datetime","readonly":true},"schema":{}}],"schema":{},"meta":{"singleton":false,"hidden":true}}`)
+	if err != nil {
+		return err
+	}
+	currentVersion, err := store.schemaVersion(ctx)
+	if err != nil {
+		return err
+	}
+	for _, migration := range directusMigrations {
+		if migration.version <= currentVersion {
+			continue
+		}
+		log.Infof("applying directus migration %v: %v", migration.version, migration.description)
+		err := migration.apply(ctx, store)
+		if err != nil {
+			return fmt.Errorf("error applying migration %v: %v", migration.version, err)
+		}
+		reqBody, _ := json.Marshal(map[string]interface{}{
+			"version":     migration.version,
+			"description": migration.description,
+			"applied_at":  time.Now().UTC().Format(utils.DIRECTUS_DATETIME_FORMAT),
+		})
+		status, body, err := store.directusRequest(ctx, http.MethodPost, "/items/reminderbot_schema_migrations", reqBody)
+		if err != nil {
+			return err
+		}
+		if status != 200 {
+			return fmt.Errorf("error recording migration %v in directus: %v", migration.version, string(body))
+		}
+	}
+	return nil
+}
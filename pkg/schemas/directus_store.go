@@ -0,0 +1,996 @@
+package schemas
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/Jason-CKY/telegram-reminderbot/pkg/utils"
+)
+
+// DirectusStore persists reminders and chat settings through the Directus REST API.
+type DirectusStore struct {
+	Host  string
+	Token string
+}
+
+func NewDirectusStore(host string, token string) *DirectusStore {
+	return &DirectusStore{
+		Host:  host,
+		Token: token,
+	}
+}
+
+func (store *DirectusStore) CreateReminder(ctx context.Context, reminder Reminder) error {
+	directusWrites.add("reminderbot_reminder", reminder.Id)
+	endpoint := fmt.Sprintf("%v/items/reminderbot_reminder", store.Host)
+	reqBody, _ := json.Marshal(reminder)
+	req, httpErr := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewBuffer(reqBody))
+	if httpErr != nil {
+		return httpErr
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %v", store.Token))
+	client := &http.Client{}
+	res, httpErr := client.Do(req)
+	if httpErr != nil {
+		return httpErr
+	}
+	body, _ := io.ReadAll(res.Body)
+	defer res.Body.Close()
+	if res.StatusCode != 200 {
+		return fmt.Errorf("error inserting reminder to directus: %v", string(body))
+	}
+
+	return nil
+}
+
+func (store *DirectusStore) UpdateReminder(ctx context.Context, reminder Reminder) error {
+	directusWrites.add("reminderbot_reminder", reminder.Id)
+	endpoint := fmt.Sprintf("%v/items/reminderbot_reminder/%v", store.Host, reminder.Id)
+	// updating a reminder also releases any lease held on it
+	var reminderFields map[string]interface{}
+	reminderJson, _ := json.Marshal(reminder)
+	jsonErr := json.Unmarshal(reminderJson, &reminderFields)
+	if jsonErr != nil {
+		return jsonErr
+	}
+	reminderFields["lease_owner"] = nil
+	reminderFields["lease_expires_at"] = nil
+	reqBody, _ := json.Marshal(reminderFields)
+	req, httpErr := http.NewRequestWithContext(ctx, http.MethodPatch, endpoint, bytes.NewBuffer(reqBody))
+	if httpErr != nil {
+		return httpErr
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %v", store.Token))
+	client := &http.Client{}
+	res, httpErr := client.Do(req)
+	if httpErr != nil {
+		return httpErr
+	}
+	body, _ := io.ReadAll(res.Body)
+	defer res.Body.Close()
+	if res.StatusCode != 200 {
+		return fmt.Errorf("error updating reminder to directus: %v", string(body))
+	}
+
+	return nil
+}
+
+func (store *DirectusStore) DeleteReminder(ctx context.Context, id string) error {
+	directusWrites.add("reminderbot_reminder", id)
+	endpoint := fmt.Sprintf("%v/items/reminderbot_reminder/%v", store.Host, id)
+	req, httpErr := http.NewRequestWithContext(ctx, http.MethodDelete, endpoint, nil)
+	if httpErr != nil {
+		return httpErr
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %v", store.Token))
+	client := &http.Client{}
+	res, httpErr := client.Do(req)
+	if httpErr != nil {
+		return httpErr
+	}
+	body, _ := io.ReadAll(res.Body)
+	defer res.Body.Close()
+	if res.StatusCode != 204 {
+		return fmt.Errorf("error deleting reminder in directus: %v", string(body))
+	}
+	return nil
+}
+
+func (store *DirectusStore) SoftDeleteReminder(ctx context.Context, id string, deletedAt time.Time) error {
+	directusWrites.add("reminderbot_reminder", id)
+	reqBody := []byte(fmt.Sprintf(`{
+		"deleted_at": "%v",
+		"lease_owner": null,
+		"lease_expires_at": null
+	}`, deletedAt.UTC().Format(utils.DIRECTUS_DATETIME_FORMAT)))
+	status, body, err := store.directusRequest(ctx, http.MethodPatch, fmt.Sprintf("/items/reminderbot_reminder/%v", id), reqBody)
+	if err != nil {
+		return err
+	}
+	if status != 200 {
+		return fmt.Errorf("error soft deleting reminder in directus: %v", string(body))
+	}
+	return nil
+}
+
+func (store *DirectusStore) RestoreReminder(ctx context.Context, id string) (*Reminder, error) {
+	deletedReminders, err := store.searchReminders(ctx, fmt.Sprintf(`{
+		"id": {
+			"_eq": "%v"
+		},
+		"deleted_at": {
+			"_nnull": true
+		}
+	}`, id), []string{"id"}, 1, 0)
+	if err != nil {
+		return nil, err
+	}
+	if len(deletedReminders) == 0 {
+		return nil, nil
+	}
+	directusWrites.add("reminderbot_reminder", id)
+	status, body, err := store.directusRequest(ctx, http.MethodPatch, fmt.Sprintf("/items/reminderbot_reminder/%v", id), []byte(`{"deleted_at": null}`))
+	if err != nil {
+		return nil, err
+	}
+	if status != 200 {
+		return nil, fmt.Errorf("error restoring reminder in directus: %v", string(body))
+	}
+	return &deletedReminders[0], nil
+}
+
+func (store *DirectusStore) PurgeDeletedReminders(ctx context.Context, before time.Time) error {
+	reqBody := []byte(fmt.Sprintf(`{
+		"query": {
+			"filter": {
+				"deleted_at": {
+					"_lt": "%v"
+				}
+			},
+			"limit": -1
+		}
+	}`, before.UTC().Format(utils.DIRECTUS_DATETIME_FORMAT)))
+	status, body, err := store.directusRequest(ctx, http.MethodDelete, "/items/reminderbot_reminder", reqBody)
+	if err != nil {
+		return err
+	}
+	if status != 200 && status != 204 {
+		return fmt.Errorf("error purging deleted reminders in directus: %v", string(body))
+	}
+	return nil
+}
+
+// searchReminders runs a single SEARCH against the reminder collection and
+// returns one page of results.
+func (store *DirectusStore) searchReminders(ctx context.Context, filter string, sort []string, limit int, offset int) ([]Reminder, error) {
+	endpoint := fmt.Sprintf("%v/items/reminderbot_reminder", store.Host)
+	sortFields, _ := json.Marshal(sort)
+	reqBody := []byte(fmt.Sprintf(`{
+		"query": {
+			"filter": %v,
+			"sort": %v,
+			"limit": %v,
+			"offset": %v
+		}
+	}`, filter, string(sortFields), limit, offset))
+	req, httpErr := http.NewRequestWithContext(ctx, "SEARCH", endpoint, bytes.NewBuffer(reqBody))
+	if httpErr != nil {
+		return nil, httpErr
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %v", store.Token))
+	client := &http.Client{}
+	res, httpErr := client.Do(req)
+	if httpErr != nil {
+		return nil, httpErr
+	}
+	body, _ := io.ReadAll(res.Body)
+	defer res.Body.Close()
+	if res.StatusCode != 200 {
+		return nil, fmt.Errorf("error searching for reminders in directus: %v", string(body))
+	}
+	var reminderResponse map[string][]Reminder
+	jsonErr := json.Unmarshal(body, &reminderResponse)
+	// error handling for json unmarshaling
+	if jsonErr != nil {
+		return nil, jsonErr
+	}
+	return reminderResponse["data"], nil
+}
+
+// searchAllReminders pages through a SEARCH with offset pagination until
+// every matching reminder has been read. sort must give a stable order.
+func (store *DirectusStore) searchAllReminders(ctx context.Context, filter string, sort []string) ([]Reminder, error) {
+	var reminders []Reminder
+	for offset := 0; ; offset += utils.DIRECTUS_PAGE_SIZE {
+		page, err := store.searchReminders(ctx, filter, sort, utils.DIRECTUS_PAGE_SIZE, offset)
+		if err != nil {
+			return nil, err
+		}
+		reminders = append(reminders, page...)
+		if len(page) < utils.DIRECTUS_PAGE_SIZE {
+			return reminders, nil
+		}
+	}
+}
+
+// updateItemsByQuery PATCHes every item of collection matching filter with data.
+// Directus applies its default limit to batch updates, so keep going until a
+// short batch comes back. data must move the item out of filter for the loop to end.
+func (store *DirectusStore) updateItemsByQuery(ctx context.Context, collection string, filter string, data string) error {
+	endpoint := fmt.Sprintf("%v/items/%v", store.Host, collection)
+	for {
+		reqBody := []byte(fmt.Sprintf(`{
+			"query": {
+				"filter": %v,
+				"limit": %v
+			},
+			"data": %v
+		}`, filter, utils.DIRECTUS_PAGE_SIZE, data))
+		req, httpErr := http.NewRequestWithContext(ctx, http.MethodPatch, endpoint, bytes.NewBuffer(reqBody))
+		if httpErr != nil {
+			return httpErr
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %v", store.Token))
+		client := &http.Client{}
+		res, httpErr := client.Do(req)
+		if httpErr != nil {
+			return httpErr
+		}
+		body, _ := io.ReadAll(res.Body)
+		res.Body.Close()
+		if res.StatusCode != 200 {
+			return fmt.Errorf("error updating %v in directus: %v", collection, string(body))
+		}
+		var itemResponse map[string][]json.RawMessage
+		jsonErr := json.Unmarshal(body, &itemResponse)
+		// error handling for json unmarshaling
+		if jsonErr != nil {
+			return jsonErr
+		}
+		if len(itemResponse["data"]) < utils.DIRECTUS_PAGE_SIZE {
+			return nil
+		}
+	}
+}
+
+func (store *DirectusStore) DeleteRemindersInConstruction(ctx context.Context, chatId int64, fromUserId int64) error {
+	endpoint := fmt.Sprintf("%v/items/reminderbot_reminder", store.Host)
+	reqBody := []byte(fmt.Sprintf(`{
+		"query": {
+			"filter": {
+				"_and": [
+					{
+						"chat_id": {
+							"_eq": "%v"
+						}
+					},
+					{
+						"from_user_id": {
+							"_eq": "%v"
+						}
+					},
+					{
+						"in_construction": {
+							"_eq": true
+						}
+					}
+				]
+			},
+			"limit": -1
+		}
+	}`, chatId, fromUserId))
+
+	req, httpErr := http.NewRequestWithContext(ctx, http.MethodDelete, endpoint, bytes.NewBuffer(reqBody))
+	if httpErr != nil {
+		return httpErr
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %v", store.Token))
+	client := &http.Client{}
+	res, httpErr := client.Do(req)
+	if httpErr != nil {
+		return httpErr
+	}
+	body, _ := io.ReadAll(res.Body)
+	defer res.Body.Close()
+	if res.StatusCode != 200 && res.StatusCode != 204 {
+		return fmt.Errorf("error deleting reminders in construction: %v", string(body))
+	}
+
+	return nil
+}
+
+func (store *DirectusStore) GetReminderInConstruction(ctx context.Context, chatId int64, fromUserId int64) (*Reminder, error) {
+	reminders, err := store.searchReminders(ctx, fmt.Sprintf(`{
+		"_and": [
+			{
+				"chat_id": {
+					"_eq": "%v"
+				}
+			},
+			{
+				"from_user_id": {
+					"_eq": "%v"
+				}
+			},
+			{
+				"in_construction": {
+					"_eq": true
+				}
+			},
+			{
+				"deleted_at": {
+					"_null": true
+				}
+			}
+		]
+	}`, chatId, fromUserId), []string{"date_created"}, 1, 0)
+	if err != nil {
+		return nil, err
+	}
+	if len(reminders) == 0 {
+		return nil, nil
+	}
+	return &reminders[0], nil
+}
+
+func (store *DirectusStore) GetReminderById(ctx context.Context, id string) (*Reminder, error) {
+	reminders, err := store.searchReminders(ctx, fmt.Sprintf(`{
+		"id": {
+			"_eq": "%v"
+		},
+		"deleted_at": {
+			"_null": true
+		}
+	}`, id), []string{"id"}, 1, 0)
+	if err != nil {
+		return nil, err
+	}
+	if len(reminders) == 0 {
+		return nil, nil
+	}
+	return &reminders[0], nil
+}
+
+func (store *DirectusStore) GetRemindersByChatId(ctx context.Context, chatId int64) ([]Reminder, error) {
+	return store.searchAllReminders(ctx, fmt.Sprintf(`{
+		"chat_id": {
+			"_eq": "%v"
+		},
+		"in_construction": {
+			"_eq": false
+		},
+		"deleted_at": {
+			"_null": true
+		}
+	}`, chatId), []string{"id"})
+}
+
+// GetDueReminders uses the reminder id as a cursor rather than an offset, so
+// reminders that stop being due while the caller works through the pages do
+// not shift later reminders out of view.
+func (store *DirectusStore) GetDueReminders(ctx context.Context, before time.Time, cursor string, limit int) ([]Reminder, error) {
+	cursorFilter := ""
+	if cursor != "" {
+		cursorFilter = fmt.Sprintf(`,
+			{
+				"id": {
+					"_gt": "%v"
+				}
+			}`, cursor)
+	}
+	return store.searchReminders(ctx, fmt.Sprintf(`{
+		"_and": [
+			{
+				"in_construction": {
+					"_eq": false
+				}
+			},
+			{
+				"deleted_at": {
+					"_null": true
+				}
+			},
+			{
+				"next_trigger_time": {
+					"_lt": "%v"
+				}
+			}%v
+		]
+	}`, before.UTC().Format(utils.DIRECTUS_DATETIME_FORMAT), cursorFilter), []string{"id"}, limit, 0)
+}
+
+// ClaimDueReminders looks up due reminders and leases them with a PATCH by
+// query, then reads back the reminders that carry this claim. Directus has no
+// compare-and-set through its API, so the claim is not atomic and only one
+// instance of the bot may run against a Directus store; main refuses an
+// --instance-id with it.
+func (store *DirectusStore) ClaimDueReminders(ctx context.Context, owner string, before time.Time, leaseDuration time.Duration, limit int) ([]Reminder, error) {
+	now := time.Now().UTC()
+	expiresAt := now.Add(leaseDuration).Format(utils.DIRECTUS_DATETIME_FORMAT)
+	unleased := fmt.Sprintf(`{
+		"_or": [
+			{
+				"lease_expires_at": {
+					"_null": true
+				}
+			},
+			{
+				"lease_expires_at": {
+					"_lt": "%v"
+				}
+			}
+		]
+	}`, now.Format(utils.DIRECTUS_DATETIME_FORMAT))
+	dueReminders, err := store.searchReminders(ctx, fmt.Sprintf(`{
+		"_and": [
+			{
+				"in_construction": {
+					"_eq": false
+				}
+			},
+			{
+				"deleted_at": {
+					"_null": true
+				}
+			},
+			{
+				"next_trigger_time": {
+					"_lt": "%v"
+				}
+			},
+			%v
+		]
+	}`, before.UTC().Format(utils.DIRECTUS_DATETIME_FORMAT), unleased), []string{"next_trigger_time", "id"}, limit, 0)
+	if err != nil {
+		return nil, err
+	}
+	if len(dueReminders) == 0 {
+		return nil, nil
+	}
+	var ids []string
+	for _, reminder := range dueReminders {
+		ids = append(ids, reminder.Id)
+	}
+	// the ids are known before the lease is written, so its echo from the subscription is dropped
+	directusWrites.add("reminderbot_reminder", ids...)
+	idsJson, _ := json.Marshal(ids)
+
+	endpoint := fmt.Sprintf("%v/items/reminderbot_reminder", store.Host)
+	reqBody := []byte(fmt.Sprintf(`{
+		"query": {
+			"filter": {
+				"_and": [
+					{
+						"id": {
+							"_in": %v
+						}
+					},
+					%v
+				]
+			},
+			"limit": -1
+		},
+		"data": {
+			"lease_owner": "%v",
+			"lease_expires_at": "%v"
+		}
+	}`, string(idsJson), unleased, owner, expiresAt))
+	req, httpErr := http.NewRequestWithContext(ctx, http.MethodPatch, endpoint, bytes.NewBuffer(reqBody))
+	if httpErr != nil {
+		return nil, httpErr
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %v", store.Token))
+	client := &http.Client{}
+	res, httpErr := client.Do(req)
+	if httpErr != nil {
+		return nil, httpErr
+	}
+	body, _ := io.ReadAll(res.Body)
+	defer res.Body.Close()
+	if res.StatusCode != 200 {
+		return nil, fmt.Errorf("error claiming due reminders in directus: %v", string(body))
+	}
+
+	// the lease is only written where it had not been taken in the meantime
+	return store.searchReminders(ctx, fmt.Sprintf(`{
+		"_and": [
+			{
+				"lease_owner": {
+					"_eq": "%v"
+				}
+			},
+			{
+				"lease_expires_at": {
+					"_eq": "%v"
+				}
+			}
+		]
+	}`, owner, expiresAt), []string{"next_trigger_time", "id"}, limit, 0)
+}
+
+func (store *DirectusStore) ListChatReminders(ctx context.Context, chatId int64) ([]Reminder, error) {
+	reminders, err := store.searchAllReminders(ctx, fmt.Sprintf(`{
+		"_and": [
+			{
+				"chat_id": {
+					"_eq": "%v"
+				}
+			},
+			{
+				"in_construction": {
+					"_eq": false
+				}
+			},
+			{
+				"deleted_at": {
+					"_null": true
+				}
+			}
+		]
+	}`, chatId), []string{"date_created", "id"})
+	if err != nil {
+		return nil, err
+	}
+	if len(reminders) == 0 {
+		return nil, nil
+	}
+	return reminders, nil
+}
+
+func (store *DirectusStore) CreateChatSettings(ctx context.Context, chatSettings ChatSettings) error {
+	directusWrites.add("reminderbot_chat_settings", fmt.Sprint(chatSettings.ChatId))
+	endpoint := fmt.Sprintf("%v/items/reminderbot_chat_settings", store.Host)
+	reqBody, _ := json.Marshal(chatSettings)
+	req, httpErr := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewBuffer(reqBody))
+	if httpErr != nil {
+		return httpErr
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %v", store.Token))
+	client := &http.Client{}
+	res, httpErr := client.Do(req)
+	if httpErr != nil {
+		return httpErr
+	}
+	body, _ := io.ReadAll(res.Body)
+	defer res.Body.Close()
+	if res.StatusCode != 200 {
+		return fmt.Errorf("error inserting chat settings to directus: %v", string(body))
+	}
+
+	return nil
+}
+
+func (store *DirectusStore) UpdateChatSettings(ctx context.Context, chatSettings ChatSettings) error {
+	directusWrites.add("reminderbot_chat_settings", fmt.Sprint(chatSettings.ChatId))
+	endpoint := fmt.Sprintf("%v/items/reminderbot_chat_settings/%v", store.Host, chatSettings.ChatId)
+	reqBody, _ := json.Marshal(chatSettings)
+	req, httpErr := http.NewRequestWithContext(ctx, http.MethodPatch, endpoint, bytes.NewBuffer(reqBody))
+	if httpErr != nil {
+		return httpErr
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %v", store.Token))
+	client := &http.Client{}
+	res, httpErr := client.Do(req)
+	if httpErr != nil {
+		return httpErr
+	}
+	body, _ := io.ReadAll(res.Body)
+	defer res.Body.Close()
+	if res.StatusCode != 200 {
+		return fmt.Errorf("error updating chat settings to directus: %v", string(body))
+	}
+	return nil
+}
+
+func (store *DirectusStore) DeleteChatSettings(ctx context.Context, chatId int64) error {
+	directusWrites.add("reminderbot_chat_settings", fmt.Sprint(chatId))
+	endpoint := fmt.Sprintf("%v/items/reminderbot_chat_settings/%v", store.Host, chatId)
+	req, httpErr := http.NewRequestWithContext(ctx, http.MethodDelete, endpoint, nil)
+	if httpErr != nil {
+		return httpErr
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %v", store.Token))
+	client := &http.Client{}
+	res, httpErr := client.Do(req)
+	if httpErr != nil {
+		return httpErr
+	}
+	body, _ := io.ReadAll(res.Body)
+	defer res.Body.Close()
+	if res.StatusCode != 204 {
+		return fmt.Errorf("error deleting chat settings in directus: %v", string(body))
+	}
+	return nil
+}
+
+func (store *DirectusStore) GetChatSettings(ctx context.Context, chatId int64) (*ChatSettings, error) {
+	endpoint := fmt.Sprintf("%v/items/reminderbot_chat_settings", store.Host)
+	reqBody := []byte(fmt.Sprintf(`{
+		"query": {
+			"filter": {
+				"chat_id": {
+					"_eq": "%v"
+				}
+			}
+		}
+	}`, chatId))
+	req, httpErr := http.NewRequestWithContext(ctx, "SEARCH", endpoint, bytes.NewBuffer(reqBody))
+	if httpErr != nil {
+		return nil, httpErr
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %v", store.Token))
+	client := &http.Client{}
+	res, httpErr := client.Do(req)
+	if httpErr != nil {
+		return nil, httpErr
+	}
+	defer res.Body.Close()
+	body, _ := io.ReadAll(res.Body)
+	if res.StatusCode != 200 {
+		return nil, fmt.Errorf("error getting chat settings in directus: %v", string(body))
+	}
+	var chatSettingsResponse map[string][]ChatSettings
+	jsonErr := json.Unmarshal(body, &chatSettingsResponse)
+	// error handling for json unmarshaling
+	if jsonErr != nil {
+		return nil, jsonErr
+	}
+
+	if len(chatSettingsResponse["data"]) == 0 {
+		return nil, nil
+	}
+
+	return &chatSettingsResponse["data"][0], nil
+}
+
+// MigrateChat can't use a transaction through the Directus API, so it runs
+// steps that can each be repeated: copy the settings to the new chat, move the
+// reminders, deliveries and audit entries, and only then delete the old settings. A failure part way leaves
+// the old settings in place, and the next attempt picks up where this one stopped.
+func (store *DirectusStore) MigrateChat(ctx context.Context, fromChatId int64, toChatId int64) error {
+	oldChatSettings, err := store.GetChatSettings(ctx, fromChatId)
+	if err != nil {
+		return err
+	}
+	if oldChatSettings != nil {
+		newChatSettings, err := store.GetChatSettings(ctx, toChatId)
+		if err != nil {
+			return err
+		}
+		migratedChatSettings := *oldChatSettings
+		migratedChatSettings.ChatId = toChatId
+		if newChatSettings == nil {
+			err = store.CreateChatSettings(ctx, migratedChatSettings)
+		} else {
+			err = store.UpdateChatSettings(ctx, migratedChatSettings)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, collection := range []string{"reminderbot_reminder", "reminderbot_delivery", "reminderbot_audit_log"} {
+		err = store.updateItemsByQuery(ctx,
+			collection,
+			fmt.Sprintf(`{
+				"chat_id": {
+					"_eq": "%v"
+				}
+			}`, fromChatId),
+			fmt.Sprintf(`{
+				"chat_id": "%v"
+			}`, toChatId),
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	if oldChatSettings != nil {
+		return store.DeleteChatSettings(ctx, fromChatId)
+	}
+	return nil
+}
+
+// searchItemIds returns the ids of every item of a collection matching the filter.
+func (store *DirectusStore) searchItemIds(ctx context.Context, collection string, filter string) ([]string, error) {
+	var ids []string
+	for offset := 0; ; offset += utils.DIRECTUS_PAGE_SIZE {
+		reqBody := []byte(fmt.Sprintf(`{
+			"query": {
+				"filter": %v,
+				"fields": ["id"],
+				"sort": ["id"],
+				"limit": %v,
+				"offset": %v
+			}
+		}`, filter, utils.DIRECTUS_PAGE_SIZE, offset))
+		status, body, err := store.directusRequest(ctx, "SEARCH", "/items/"+collection, reqBody)
+		if err != nil {
+			return nil, err
+		}
+		if status != 200 {
+			return nil, fmt.Errorf("error searching %v in directus: %v", collection, string(body))
+		}
+		var itemResponse map[string][]struct {
+			Id string `json:"id"`
+		}
+		jsonErr := json.Unmarshal(body, &itemResponse)
+		// error handling for json unmarshaling
+		if jsonErr != nil {
+			return nil, jsonErr
+		}
+		for _, item := range itemResponse["data"] {
+			ids = append(ids, item.Id)
+		}
+		if len(itemResponse["data"]) < utils.DIRECTUS_PAGE_SIZE {
+			return ids, nil
+		}
+	}
+}
+
+func (store *DirectusStore) deleteItems(ctx context.Context, collection string, ids []string) error {
+	for start := 0; start < len(ids); start += utils.DIRECTUS_PAGE_SIZE {
+		end := min(start+utils.DIRECTUS_PAGE_SIZE, len(ids))
+		reqBody, _ := json.Marshal(ids[start:end])
+		status, body, err := store.directusRequest(ctx, http.MethodDelete, "/items/"+collection, reqBody)
+		if err != nil {
+			return err
+		}
+		if status != 200 && status != 204 {
+			return fmt.Errorf("error deleting %v in directus: %v", collection, string(body))
+		}
+	}
+	return nil
+}
+
+// EraseUserData finds everything to remove before deleting it, and deletes
+// reminders and settings last, so that a failed attempt can simply be repeated.
+func (store *DirectusStore) EraseUserData(ctx context.Context, userId int64) (ErasedUserData, error) {
+	var erased ErasedUserData
+	reminderIds, err := store.searchItemIds(ctx, "reminderbot_reminder", fmt.Sprintf(`{
+		"from_user_id": {
+			"_eq": "%v"
+		}
+	}`, userId))
+	if err != nil {
+		return erased, err
+	}
+	var deliveryIds []string
+	auditIds := map[string]bool{}
+	userAuditIds, err := store.searchItemIds(ctx, "reminderbot_audit_log", fmt.Sprintf(`{
+		"_or": [
+			{"user_id": {"_eq": "%v"}},
+			{"chat_id": {"_eq": "%v"}}
+		]
+	}`, userId, userId))
+	if err != nil {
+		return erased, err
+	}
+	for _, id := range userAuditIds {
+		auditIds[id] = true
+	}
+	for start := 0; start < len(reminderIds); start += utils.DIRECTUS_PAGE_SIZE {
+		chunk, _ := json.Marshal(reminderIds[start:min(start+utils.DIRECTUS_PAGE_SIZE, len(reminderIds))])
+		ids, err := store.searchItemIds(ctx, "reminderbot_delivery", fmt.Sprintf(`{
+			"reminder_id": {
+				"_in": %v
+			}
+		}`, string(chunk)))
+		if err != nil {
+			return erased, err
+		}
+		deliveryIds = append(deliveryIds, ids...)
+		ids, err = store.searchItemIds(ctx, "reminderbot_audit_log", fmt.Sprintf(`{
+			"entity_type": {
+				"_eq": "%v"
+			},
+			"entity_id": {
+				"_in": %v
+			}
+		}`, utils.AUDIT_ENTITY_REMINDER, string(chunk)))
+		if err != nil {
+			return erased, err
+		}
+		for _, id := range ids {
+			auditIds[id] = true
+		}
+	}
+
+	if err := store.deleteItems(ctx, "reminderbot_delivery", deliveryIds); err != nil {
+		return erased, err
+	}
+	erased.Deliveries = len(deliveryIds)
+	var auditIdList []string
+	for id := range auditIds {
+		auditIdList = append(auditIdList, id)
+	}
+	if err := store.deleteItems(ctx, "reminderbot_audit_log", auditIdList); err != nil {
+		return erased, err
+	}
+	erased.AuditEntries = len(auditIdList)
+	if err := store.deleteItems(ctx, "reminderbot_reminder", reminderIds); err != nil {
+		return erased, err
+	}
+	erased.Reminders = len(reminderIds)
+	chatSettings, err := store.GetChatSettings(ctx, userId)
+	if err != nil {
+		return erased, err
+	}
+	if chatSettings != nil {
+		if err := store.DeleteChatSettings(ctx, userId); err != nil {
+			return erased, err
+		}
+		erased.ChatSettings = 1
+	}
+	return erased, nil
+}
+
+func (store *DirectusStore) RewriteSensitiveFields(ctx context.Context, rewrite func(string) (string, error)) (int, error) {
+	rewritten := 0
+	for _, collection := range []struct {
+		name   string
+		fields [2]string
+	}{
+		{"reminderbot_reminder", [2]string{"reminder_text", "file_id"}},
+		{"reminderbot_audit_log", [2]string{"before", "after"}},
+	} {
+		count, err := store.rewriteItemFields(ctx, collection.name, collection.fields, rewrite)
+		rewritten += count
+		if err != nil {
+			return rewritten, err
+		}
+	}
+	return rewritten, nil
+}
+
+// rewriteItemFields pages through every item of a collection and PATCHes the
+// items whose fields change. Rewriting does not change the sort order, so the
+// offsets stay valid while items are updated.
+func (store *DirectusStore) rewriteItemFields(ctx context.Context, collection string, fields [2]string, rewrite func(string) (string, error)) (int, error) {
+	rewritten := 0
+	for offset := 0; ; offset += utils.DIRECTUS_PAGE_SIZE {
+		reqBody := []byte(fmt.Sprintf(`{
+			"query": {
+				"fields": ["id", "%v", "%v"],
+				"sort": ["id"],
+				"limit": %v,
+				"offset": %v
+			}
+		}`, fields[0], fields[1], utils.DIRECTUS_PAGE_SIZE, offset))
+		status, body, err := store.directusRequest(ctx, "SEARCH", "/items/"+collection, reqBody)
+		if err != nil {
+			return rewritten, err
+		}
+		if status != 200 {
+			return rewritten, fmt.Errorf("error searching %v in directus: %v", collection, string(body))
+		}
+		var itemResponse map[string][]map[string]interface{}
+		jsonErr := json.Unmarshal(body, &itemResponse)
+		// error handling for json unmarshaling
+		if jsonErr != nil {
+			return rewritten, jsonErr
+		}
+		for _, item := range itemResponse["data"] {
+			values := [2]string{}
+			for i, field := range fields {
+				values[i], _ = item[field].(string)
+			}
+			changed, err := rewriteValues(rewrite, &values[0], &values[1])
+			if err != nil {
+				return rewritten, fmt.Errorf("error rewriting %v %v: %v", collection, item["id"], err)
+			}
+			if !changed {
+				continue
+			}
+			patch, _ := json.Marshal(map[string]string{fields[0]: values[0], fields[1]: values[1]})
+			status, body, err := store.directusRequest(ctx, http.MethodPatch, fmt.Sprintf("/items/%v/%v", collection, item["id"]), patch)
+			if err != nil {
+				return rewritten, err
+			}
+			if status != 200 {
+				return rewritten, fmt.Errorf("error updating %v %v in directus: %v", collection, item["id"], string(body))
+			}
+			rewritten++
+		}
+		if len(itemResponse["data"]) < utils.DIRECTUS_PAGE_SIZE {
+			return rewritten, nil
+		}
+	}
+}
+
+func (store *DirectusStore) CreateDelivery(ctx context.Context, delivery Delivery) error {
+	reqBody, _ := json.Marshal(delivery)
+	status, body, err := store.directusRequest(ctx, http.MethodPost, "/items/reminderbot_delivery", reqBody)
+	if err != nil {
+		return err
+	}
+	if status != 200 {
+		return fmt.Errorf("error inserting delivery to directus: %v", string(body))
+	}
+	return nil
+}
+
+func (store *DirectusStore) ListReminderDeliveries(ctx context.Context, reminderId string, limit int) ([]Delivery, error) {
+	reqBody := []byte(fmt.Sprintf(`{
+		"query": {
+			"filter": {
+				"reminder_id": {
+					"_eq": "%v"
+				}
+			},
+			"sort": ["-sent_time"],
+			"limit": %v
+		}
+	}`, reminderId, limit))
+	status, body, err := store.directusRequest(ctx, "SEARCH", "/items/reminderbot_delivery", reqBody)
+	if err != nil {
+		return nil, err
+	}
+	if status != 200 {
+		return nil, fmt.Errorf("error searching for deliveries in directus: %v", string(body))
+	}
+	var deliveryResponse map[string][]Delivery
+	jsonErr := json.Unmarshal(body, &deliveryResponse)
+	// error handling for json unmarshaling
+	if jsonErr != nil {
+		return nil, jsonErr
+	}
+	return deliveryResponse["data"], nil
+}
+
+func (store *DirectusStore) CreateAuditEntry(ctx context.Context, auditEntry AuditEntry) error {
+	reqBody, _ := json.Marshal(auditEntry)
+	status, body, err := store.directusRequest(ctx, http.MethodPost, "/items/reminderbot_audit_log", reqBody)
+	if err != nil {
+		return err
+	}
+	if status != 200 {
+		return fmt.Errorf("error inserting audit entry to directus: %v", string(body))
+	}
+	return nil
+}
+
+func (store *DirectusStore) ListChatAuditEntries(ctx context.Context, chatId int64, limit int) ([]AuditEntry, error) {
+	reqBody := []byte(fmt.Sprintf(`{
+		"query": {
+			"filter": {
+				"chat_id": {
+					"_eq": "%v"
+				}
+			},
+			"sort": ["-created_at"],
+			"limit": %v
+		}
+	}`, chatId, limit))
+	status, body, err := store.directusRequest(ctx, "SEARCH", "/items/reminderbot_audit_log", reqBody)
+	if err != nil {
+		return nil, err
+	}
+	if status != 200 {
+		return nil, fmt.Errorf("error searching for audit entries in directus: %v", string(body))
+	}
+	var auditResponse map[string][]AuditEntry
+	jsonErr := json.Unmarshal(body, &auditResponse)
+	// error handling for json unmarshaling
+	if jsonErr != nil {
+		return nil, jsonErr
+	}
+	return auditResponse["data"], nil
+}
@@ -0,0 +1,276 @@
+package schemas
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Jason-CKY/telegram-reminderbot/pkg/utils"
+	"github.com/gorilla/websocket"
+	log "github.com/sirupsen/logrus"
+)
+
+// directusSubscriptions are the collections the bot follows, with their primary key field.
+var directusSubscriptions = []struct {
+	collection string
+	primaryKey string
+}{
+	{"reminderbot_reminder", "id"},
+	{"reminderbot_chat_settings", "chat_id"},
+}
+
+// localWrites counts the writes the DirectusStore of this process has made to
+// items of the followed collections, so that the events Directus sends back for
+// them are dropped instead of being handled as outside changes. Writes that get
+// no event, e.g. because they failed, are forgotten after utils.DIRECTUS_LOCAL_WRITE_TTL.
+type localWrites struct {
+	mu     sync.Mutex
+	writes map[string]localWrite
+}
+
+type localWrite struct {
+	count     int
+	expiresAt time.Time
+}
+
+var directusWrites = &localWrites{writes: map[string]localWrite{}}
+
+func (writes *localWrites) add(collection string, keys ...string) {
+	writes.mu.Lock()
+	defer writes.mu.Unlock()
+	now := time.Now()
+	for key, write := range writes.writes {
+		if write.expiresAt.Before(now) {
+			delete(writes.writes, key)
+		}
+	}
+	for _, key := range keys {
+		write := writes.writes[collection+"/"+key]
+		write.count++
+		write.expiresAt = now.Add(utils.DIRECTUS_LOCAL_WRITE_TTL)
+		writes.writes[collection+"/"+key] = write
+	}
+}
+
+// remote returns the keys of an event that were not written by this process,
+// taking one write off the count of every key that was.
+func (writes *localWrites) remote(collection string, keys []string) []string {
+	writes.mu.Lock()
+	defer writes.mu.Unlock()
+	now := time.Now()
+	var remoteKeys []string
+	for _, key := range keys {
+		write, ok := writes.writes[collection+"/"+key]
+		if !ok || write.expiresAt.Before(now) {
+			remoteKeys = append(remoteKeys, key)
+			continue
+		}
+		write.count--
+		if write.count == 0 {
+			delete(writes.writes, collection+"/"+key)
+		} else {
+			writes.writes[collection+"/"+key] = write
+		}
+	}
+	return remoteKeys
+}
+
+// DirectusSubscriber follows changes to the bot's collections through the
+// Directus WebSocket API, so that edits made in the Directus admin app reach
+// the bot straight away, and publishes them on an EventBus. Changes made by
+// this process are left out.
+type DirectusSubscriber struct {
+	Host  string
+	Token string
+	Bus   *EventBus
+	// minBackoff is the first delay before reconnecting, doubled on every failed attempt
+	minBackoff time.Duration
+}
+
+func NewDirectusSubscriber(host string, token string, bus *EventBus) *DirectusSubscriber {
+	return &DirectusSubscriber{
+		Host:       host,
+		Token:      token,
+		Bus:        bus,
+		minBackoff: time.Second,
+	}
+}
+
+type directusMessage struct {
+	Type       string            `json:"type"`
+	Status     string            `json:"status,omitempty"`
+	Event      string            `json:"event,omitempty"`
+	Uid        string            `json:"uid,omitempty"`
+	Data       []json.RawMessage `json:"data,omitempty"`
+	Collection string            `json:"collection,omitempty"`
+	Error      *struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	} `json:"error,omitempty"`
+}
+
+func (subscriber *DirectusSubscriber) websocketUrl() string {
+	url := strings.TrimSuffix(subscriber.Host, "/") + "/websocket"
+	if strings.HasPrefix(url, "https://") {
+		return "wss://" + strings.TrimPrefix(url, "https://")
+	}
+	return "ws://" + strings.TrimPrefix(url, "http://")
+}
+
+// Run keeps a subscription open until ctx is done, reconnecting with an
+// increasing delay whenever the connection fails. A CHANGE_RESYNC event is
+// published for every collection once subscribed, as changes may have been
+// missed while disconnected.
+func (subscriber *DirectusSubscriber) Run(ctx context.Context) {
+	backoff := subscriber.minBackoff
+	for {
+		subscribed, err := subscriber.listen(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if subscribed {
+			backoff = subscriber.minBackoff
+		}
+		log.Warnf("directus subscription failed, reconnecting in %v: %v", backoff, err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, utils.DIRECTUS_WEBSOCKET_MAX_BACKOFF)
+	}
+}
+
+// listen runs a single connection until it fails, and reports whether it got as far as subscribing.
+func (subscriber *DirectusSubscriber) listen(ctx context.Context) (bool, error) {
+	conn, _, err := websocket.DefaultDialer.DialContext(ctx, subscriber.websocketUrl(), nil)
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() {
+		conn.Close()
+	})
+	defer stop()
+
+	err = conn.WriteJSON(map[string]string{"type": "auth", "access_token": subscriber.Token})
+	if err != nil {
+		return false, err
+	}
+	message, err := subscriber.read(conn)
+	if err != nil {
+		return false, err
+	}
+	if message.Type != "auth" || message.Status != "ok" {
+		if message.Error != nil {
+			return false, fmt.Errorf("directus websocket authentication failed: %v", message.Error.Message)
+		}
+		return false, fmt.Errorf("directus websocket authentication failed: unexpected %v message", message.Type)
+	}
+
+	for _, subscription := range directusSubscriptions {
+		err = conn.WriteJSON(map[string]interface{}{
+			"type":       "subscribe",
+			"collection": subscription.collection,
+			"uid":        subscription.collection,
+			"query":      map[string]interface{}{"fields": []string{subscription.primaryKey}},
+		})
+		if err != nil {
+			return false, err
+		}
+	}
+	log.Infof("subscribed to directus changes at %v", subscriber.websocketUrl())
+	for _, subscription := range directusSubscriptions {
+		subscriber.Bus.Publish(ctx, ChangeEvent{Collection: subscription.collection, Action: utils.CHANGE_RESYNC})
+	}
+
+	for {
+		message, err := subscriber.read(conn)
+		if err != nil {
+			return true, err
+		}
+		switch message.Type {
+		case "ping":
+			err = conn.WriteJSON(map[string]string{"type": "pong"})
+			if err != nil {
+				return true, err
+			}
+		case "subscription":
+			event, err := subscriptionEvent(message)
+			if err != nil {
+				log.Error(err)
+				continue
+			}
+			if event == nil {
+				continue
+			}
+			// the bot's own writes were published when they were made
+			event.Keys = directusWrites.remote(event.Collection, event.Keys)
+			if len(event.Keys) > 0 {
+				subscriber.Bus.Publish(ctx, *event)
+			}
+		case "subscribe", "auth":
+			if message.Status == "error" && message.Error != nil {
+				return true, errors.New(message.Error.Message)
+			}
+		}
+	}
+}
+
+func (subscriber *DirectusSubscriber) read(conn *websocket.Conn) (directusMessage, error) {
+	var message directusMessage
+	err := conn.SetReadDeadline(time.Now().Add(utils.DIRECTUS_WEBSOCKET_READ_TIMEOUT))
+	if err != nil {
+		return message, err
+	}
+	err = conn.ReadJSON(&message)
+	return message, err
+}
+
+// subscriptionEvent turns a subscription message into a change event. The
+// initial result of a subscription is skipped, it is covered by the resync.
+func subscriptionEvent(message directusMessage) (*ChangeEvent, error) {
+	primaryKey := ""
+	for _, subscription := range directusSubscriptions {
+		if subscription.collection == message.Uid {
+			primaryKey = subscription.primaryKey
+		}
+	}
+	if primaryKey == "" || message.Event == "init" {
+		return nil, nil
+	}
+	event := ChangeEvent{Collection: message.Uid}
+	switch message.Event {
+	case "create":
+		event.Action = utils.CHANGE_CREATE
+	case "update":
+		event.Action = utils.CHANGE_UPDATE
+	case "delete":
+		event.Action = utils.CHANGE_DELETE
+	default:
+		return nil, nil
+	}
+	// deletes send the keys themselves, creates and updates send the items
+	for _, item := range message.Data {
+		if bytes.HasPrefix(bytes.TrimSpace(item), []byte("{")) {
+			var fields map[string]json.RawMessage
+			if err := json.Unmarshal(item, &fields); err != nil {
+				return nil, fmt.Errorf("error reading %v event: %v", message.Uid, err)
+			}
+			item = fields[primaryKey]
+		}
+		var key interface{}
+		decoder := json.NewDecoder(bytes.NewReader(item))
+		decoder.UseNumber()
+		if err := decoder.Decode(&key); err != nil {
+			return nil, fmt.Errorf("error reading %v event: %v", message.Uid, err)
+		}
+		event.Keys = append(event.Keys, fmt.Sprint(key))
+	}
+	return &event, nil
+}
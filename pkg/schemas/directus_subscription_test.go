@@ -0,0 +1,235 @@
+package schemas
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Jason-CKY/telegram-reminderbot/pkg/utils"
+	"github.com/gorilla/websocket"
+)
+
+// fakeDirectus is a local stand-in for the Directus WebSocket endpoint. Each
+// connection is handed to serve, after the path has been checked.
+func fakeDirectus(t *testing.T, serve func(conn *websocket.Conn)) *httptest.Server {
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/websocket" {
+			t.Errorf("connected to %v, want /websocket", r.URL.Path)
+			http.NotFound(w, r)
+			return
+		}
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+		serve(conn)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// expectMessage reads the next client message and checks its fields.
+func expectMessage(t *testing.T, conn *websocket.Conn, want map[string]interface{}) {
+	t.Helper()
+	var message map[string]interface{}
+	if err := conn.ReadJSON(&message); err != nil {
+		t.Errorf("reading client message: %v", err)
+		return
+	}
+	for key, value := range want {
+		if !reflect.DeepEqual(message[key], value) {
+			t.Errorf("client sent %v = %v, want %v (message %v)", key, message[key], value, message)
+		}
+	}
+}
+
+// acceptClient answers the authentication and subscriptions of a client.
+func acceptClient(t *testing.T, conn *websocket.Conn) {
+	expectMessage(t, conn, map[string]interface{}{"type": "auth", "access_token": "secret-token"})
+	conn.WriteJSON(map[string]string{"type": "auth", "status": "ok"})
+	expectMessage(t, conn, map[string]interface{}{
+		"type":       "subscribe",
+		"collection": "reminderbot_reminder",
+		"query":      map[string]interface{}{"fields": []interface{}{"id"}},
+	})
+	expectMessage(t, conn, map[string]interface{}{
+		"type":       "subscribe",
+		"collection": "reminderbot_chat_settings",
+		"query":      map[string]interface{}{"fields": []interface{}{"chat_id"}},
+	})
+}
+
+func collectEvents(bus *EventBus) <-chan ChangeEvent {
+	events := make(chan ChangeEvent, 100)
+	bus.Subscribe(func(ctx context.Context, event ChangeEvent) {
+		events <- event
+	})
+	return events
+}
+
+func nextEvent(t *testing.T, events <-chan ChangeEvent) ChangeEvent {
+	t.Helper()
+	select {
+	case event := <-events:
+		return event
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for a change event")
+		return ChangeEvent{}
+	}
+}
+
+func TestDirectusSubscriberPublishesChanges(t *testing.T) {
+	done := make(chan struct{})
+	server := fakeDirectus(t, func(conn *websocket.Conn) {
+		acceptClient(t, conn)
+		conn.WriteJSON(map[string]string{"type": "ping"})
+		expectMessage(t, conn, map[string]interface{}{"type": "pong"})
+		messages := []string{
+			`{"type":"subscription","event":"init","uid":"reminderbot_reminder","data":[{"id":"ignored"}]}`,
+			`{"type":"subscription","event":"update","uid":"reminderbot_reminder","data":[{"id":"3f1c"},{"id":"9a2b"}]}`,
+			`{"type":"subscription","event":"create","uid":"reminderbot_chat_settings","data":[{"chat_id":-1001234567890123}]}`,
+			`{"type":"subscription","event":"delete","uid":"reminderbot_chat_settings","data":["-42",7]}`,
+			`{"type":"subscription","event":"update","uid":"some_other_collection","data":[{"id":"1"}]}`,
+		}
+		for _, message := range messages {
+			conn.WriteMessage(websocket.TextMessage, []byte(message))
+		}
+		<-done
+	})
+
+	bus := &EventBus{}
+	events := collectEvents(bus)
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		NewDirectusSubscriber(server.URL, "secret-token", bus).Run(ctx)
+		close(stopped)
+	}()
+
+	want := []ChangeEvent{
+		{Collection: "reminderbot_reminder", Action: utils.CHANGE_RESYNC},
+		{Collection: "reminderbot_chat_settings", Action: utils.CHANGE_RESYNC},
+		{Collection: "reminderbot_reminder", Action: utils.CHANGE_UPDATE, Keys: []string{"3f1c", "9a2b"}},
+		{Collection: "reminderbot_chat_settings", Action: utils.CHANGE_CREATE, Keys: []string{"-1001234567890123"}},
+		{Collection: "reminderbot_chat_settings", Action: utils.CHANGE_DELETE, Keys: []string{"-42", "7"}},
+	}
+	for _, wantEvent := range want {
+		if event := nextEvent(t, events); !reflect.DeepEqual(event, wantEvent) {
+			t.Errorf("got event %+v, want %+v", event, wantEvent)
+		}
+	}
+
+	cancel()
+	close(done)
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not return after its context was cancelled")
+	}
+	select {
+	case event := <-events:
+		t.Errorf("unexpected event %+v", event)
+	default:
+	}
+}
+
+func TestDirectusSubscriberDropsOwnWrites(t *testing.T) {
+	// one write of the bot each, e.g. a claimed reminder and a changed timezone
+	directusWrites.add("reminderbot_reminder", "own-write")
+	directusWrites.add("reminderbot_chat_settings", "-77")
+	done := make(chan struct{})
+	server := fakeDirectus(t, func(conn *websocket.Conn) {
+		acceptClient(t, conn)
+		messages := []string{
+			`{"type":"subscription","event":"update","uid":"reminderbot_reminder","data":[{"id":"own-write"},{"id":"outside-write"}]}`,
+			`{"type":"subscription","event":"update","uid":"reminderbot_chat_settings","data":[{"chat_id":-77}]}`,
+			// the bot's write has been seen, so this is a change made elsewhere
+			`{"type":"subscription","event":"update","uid":"reminderbot_reminder","data":[{"id":"own-write"}]}`,
+		}
+		for _, message := range messages {
+			conn.WriteMessage(websocket.TextMessage, []byte(message))
+		}
+		<-done
+	})
+	defer close(done)
+
+	bus := &EventBus{}
+	events := collectEvents(bus)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go NewDirectusSubscriber(server.URL, "secret-token", bus).Run(ctx)
+
+	want := []ChangeEvent{
+		{Collection: "reminderbot_reminder", Action: utils.CHANGE_RESYNC},
+		{Collection: "reminderbot_chat_settings", Action: utils.CHANGE_RESYNC},
+		{Collection: "reminderbot_reminder", Action: utils.CHANGE_UPDATE, Keys: []string{"outside-write"}},
+		{Collection: "reminderbot_reminder", Action: utils.CHANGE_UPDATE, Keys: []string{"own-write"}},
+	}
+	for _, wantEvent := range want {
+		if event := nextEvent(t, events); !reflect.DeepEqual(event, wantEvent) {
+			t.Errorf("got event %+v, want %+v", event, wantEvent)
+		}
+	}
+}
+
+func TestDirectusSubscriberReconnects(t *testing.T) {
+	var connections atomic.Int32
+	server := fakeDirectus(t, func(conn *websocket.Conn) {
+		switch connections.Add(1) {
+		case 1:
+			expectMessage(t, conn, map[string]interface{}{"type": "auth"})
+			conn.WriteJSON(map[string]interface{}{
+				"type":   "auth",
+				"status": "error",
+				"error":  map[string]string{"code": "AUTH_FAILED", "message": "Authentication failed."},
+			})
+		case 2:
+			// drop the connection after subscribing, as a restarting Directus would
+			acceptClient(t, conn)
+		default:
+			acceptClient(t, conn)
+			conn.WriteMessage(websocket.TextMessage, []byte(`{"type":"subscription","event":"update","uid":"reminderbot_reminder","data":[{"id":"after-reconnect"}]}`))
+			time.Sleep(time.Second)
+		}
+	})
+
+	bus := &EventBus{}
+	events := collectEvents(bus)
+	subscriber := NewDirectusSubscriber(server.URL, "secret-token", bus)
+	subscriber.minBackoff = 10 * time.Millisecond
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go subscriber.Run(ctx)
+
+	// each successful connection starts with a resync, so nothing missed in between is lost
+	for i := 0; i < 4; i++ {
+		if event := nextEvent(t, events); event.Action != utils.CHANGE_RESYNC {
+			t.Fatalf("got event %+v, want a resync", event)
+		}
+	}
+	event := nextEvent(t, events)
+	if event.Action != utils.CHANGE_UPDATE || !reflect.DeepEqual(event.Keys, []string{"after-reconnect"}) {
+		t.Errorf("got event %+v, want the update sent after reconnecting", event)
+	}
+	if count := connections.Load(); count != 3 {
+		t.Errorf("connected %v times, want 3", count)
+	}
+}
+
+func TestDirectusSubscriberWebsocketUrl(t *testing.T) {
+	for host, want := range map[string]string{
+		"http://localhost:8055":         "ws://localhost:8055/websocket",
+		"https://directus.example.com/": "wss://directus.example.com/websocket",
+	} {
+		if url := NewDirectusSubscriber(host, "", &EventBus{}).websocketUrl(); url != want {
+			t.Errorf("websocketUrl() for %v = %v, want %v", host, url, want)
+		}
+	}
+}
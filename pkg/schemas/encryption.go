@@ -0,0 +1,339 @@
+package schemas
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// encryptedValuePrefix marks values encrypted by a Keyring, which are stored as
+// "enc:v1:<key id>:<wrapped data key>:<ciphertext>" in unpadded base64. Each
+// value has its own random data key, sealed with the key encryption key, so
+// that rotating keys only needs the data keys to be wrapped again.
+const encryptedValuePrefix = "enc:v1:"
+
+// Keyring holds the key encryption keys. Values are encrypted with the primary
+// key, and can be decrypted with the primary key or any of the previous keys.
+type Keyring struct {
+	primaryId string
+	keys      map[string]cipher.AEAD
+}
+
+// NewKeyring takes base64 encoded 32 byte keys, e.g. from `openssl rand -base64 32`.
+func NewKeyring(primaryKey string, previousKeys []string) (*Keyring, error) {
+	keyring := &Keyring{keys: map[string]cipher.AEAD{}}
+	for i, encodedKey := range append([]string{primaryKey}, previousKeys...) {
+		key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encodedKey))
+		if err != nil {
+			return nil, fmt.Errorf("invalid encryption key: %v", err)
+		}
+		if len(key) != 32 {
+			return nil, fmt.Errorf("invalid encryption key: expected 32 bytes, got %v", len(key))
+		}
+		aead, err := newAEAD(key)
+		if err != nil {
+			return nil, err
+		}
+		keyId := encryptionKeyId(key)
+		keyring.keys[keyId] = aead
+		if i == 0 {
+			keyring.primaryId = keyId
+		}
+	}
+	return keyring, nil
+}
+
+// encryptionKeyId identifies a key without revealing it.
+func encryptionKeyId(key []byte) string {
+	digest := sha256.Sum256(key)
+	return hex.EncodeToString(digest[:4])
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func seal(aead cipher.AEAD, plaintext []byte, additionalData []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+func open(aead cipher.AEAD, sealed []byte, additionalData []byte) ([]byte, error) {
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("encrypted value is too short")
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	return aead.Open(nil, nonce, ciphertext, additionalData)
+}
+
+// Encrypt returns the encrypted form of a value. Empty values stay empty.
+func (keyring *Keyring) Encrypt(value string) (string, error) {
+	if value == "" {
+		return "", nil
+	}
+	dataKey := make([]byte, 32)
+	if _, err := rand.Read(dataKey); err != nil {
+		return "", err
+	}
+	dataAEAD, err := newAEAD(dataKey)
+	if err != nil {
+		return "", err
+	}
+	ciphertext, err := seal(dataAEAD, []byte(value), nil)
+	if err != nil {
+		return "", err
+	}
+	return keyring.wrap(dataKey, ciphertext)
+}
+
+func (keyring *Keyring) wrap(dataKey []byte, ciphertext []byte) (string, error) {
+	// the key id is authenticated, so a wrapped key can't be passed off as another key's
+	wrappedKey, err := seal(keyring.keys[keyring.primaryId], dataKey, []byte(keyring.primaryId))
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf(
+		"%v%v:%v:%v",
+		encryptedValuePrefix,
+		keyring.primaryId,
+		base64.RawStdEncoding.EncodeToString(wrappedKey),
+		base64.RawStdEncoding.EncodeToString(ciphertext),
+	), nil
+}
+
+// unwrap returns the data key and ciphertext of an encrypted value, and the id of the key that wrapped it.
+func (keyring *Keyring) unwrap(value string) ([]byte, []byte, string, error) {
+	parts := strings.Split(strings.TrimPrefix(value, encryptedValuePrefix), ":")
+	if len(parts) != 3 {
+		return nil, nil, "", errors.New("malformed encrypted value")
+	}
+	keyId := parts[0]
+	aead, ok := keyring.keys[keyId]
+	if !ok {
+		return nil, nil, "", fmt.Errorf("value is encrypted with unknown key %v", keyId)
+	}
+	wrappedKey, err := base64.RawStdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, nil, "", err
+	}
+	ciphertext, err := base64.RawStdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, nil, "", err
+	}
+	dataKey, err := open(aead, wrappedKey, []byte(keyId))
+	if err != nil {
+		return nil, nil, "", fmt.Errorf("unwrapping data key: %v", err)
+	}
+	return dataKey, ciphertext, keyId, nil
+}
+
+// Decrypt returns the plain text of a value. Values that are not encrypted,
+// such as rows written before encryption was turned on, are returned as is.
+func (keyring *Keyring) Decrypt(value string) (string, error) {
+	if !strings.HasPrefix(value, encryptedValuePrefix) {
+		return value, nil
+	}
+	dataKey, ciphertext, _, err := keyring.unwrap(value)
+	if err != nil {
+		return "", err
+	}
+	dataAEAD, err := newAEAD(dataKey)
+	if err != nil {
+		return "", err
+	}
+	plaintext, err := open(dataAEAD, ciphertext, nil)
+	if err != nil {
+		return "", fmt.Errorf("decrypting value: %v", err)
+	}
+	return string(plaintext), nil
+}
+
+// Rekey wraps the data key of a value with the primary key, and encrypts values
+// that are still plain text. Values already under the primary key are returned unchanged.
+func (keyring *Keyring) Rekey(value string) (string, error) {
+	if value == "" {
+		return value, nil
+	}
+	if !strings.HasPrefix(value, encryptedValuePrefix) {
+		return keyring.Encrypt(value)
+	}
+	dataKey, ciphertext, keyId, err := keyring.unwrap(value)
+	if err != nil {
+		return "", err
+	}
+	if keyId == keyring.primaryId {
+		return value, nil
+	}
+	return keyring.wrap(dataKey, ciphertext)
+}
+
+// rewriteValues passes each value through rewrite in place, and reports whether any of them changed.
+func rewriteValues(rewrite func(string) (string, error), values ...*string) (bool, error) {
+	changed := false
+	for _, value := range values {
+		rewritten, err := rewrite(*value)
+		if err != nil {
+			return false, err
+		}
+		changed = changed || rewritten != *value
+		*value = rewritten
+	}
+	return changed, nil
+}
+
+// EncryptedStore wraps another store, encrypting the text and file id of
+// reminders, and the reminder snapshots in audit entries, before they are
+// written, and decrypting them when they are read back.
+type EncryptedStore struct {
+	ReminderStore
+	keyring *Keyring
+}
+
+func NewEncryptedStore(store ReminderStore, keyring *Keyring) *EncryptedStore {
+	return &EncryptedStore{
+		ReminderStore: store,
+		keyring:       keyring,
+	}
+}
+
+// Rekey encrypts every stored value under the primary key, including values
+// written before encryption was turned on. It can be run again if interrupted.
+func (store *EncryptedStore) Rekey(ctx context.Context) (int, error) {
+	return store.ReminderStore.RewriteSensitiveFields(ctx, store.keyring.Rekey)
+}
+
+func (store *EncryptedStore) encryptReminder(reminder Reminder) (Reminder, error) {
+	var err error
+	reminder.ReminderText, err = store.keyring.Encrypt(reminder.ReminderText)
+	if err != nil {
+		return reminder, err
+	}
+	reminder.FileId, err = store.keyring.Encrypt(reminder.FileId)
+	return reminder, err
+}
+
+func (store *EncryptedStore) decryptReminder(reminder *Reminder) error {
+	var err error
+	reminder.ReminderText, err = store.keyring.Decrypt(reminder.ReminderText)
+	if err != nil {
+		return fmt.Errorf("error decrypting reminder %v: %v", reminder.Id, err)
+	}
+	reminder.FileId, err = store.keyring.Decrypt(reminder.FileId)
+	if err != nil {
+		return fmt.Errorf("error decrypting reminder %v: %v", reminder.Id, err)
+	}
+	return nil
+}
+
+func (store *EncryptedStore) decryptReminders(reminders []Reminder, err error) ([]Reminder, error) {
+	if err != nil {
+		return nil, err
+	}
+	for i := range reminders {
+		if err := store.decryptReminder(&reminders[i]); err != nil {
+			return nil, err
+		}
+	}
+	return reminders, nil
+}
+
+func (store *EncryptedStore) decryptOptionalReminder(reminder *Reminder, err error) (*Reminder, error) {
+	if err != nil || reminder == nil {
+		return reminder, err
+	}
+	if err := store.decryptReminder(reminder); err != nil {
+		return nil, err
+	}
+	return reminder, nil
+}
+
+func (store *EncryptedStore) CreateReminder(ctx context.Context, reminder Reminder) error {
+	reminder, err := store.encryptReminder(reminder)
+	if err != nil {
+		return err
+	}
+	return store.ReminderStore.CreateReminder(ctx, reminder)
+}
+
+func (store *EncryptedStore) UpdateReminder(ctx context.Context, reminder Reminder) error {
+	reminder, err := store.encryptReminder(reminder)
+	if err != nil {
+		return err
+	}
+	return store.ReminderStore.UpdateReminder(ctx, reminder)
+}
+
+func (store *EncryptedStore) RestoreReminder(ctx context.Context, id string) (*Reminder, error) {
+	return store.decryptOptionalReminder(store.ReminderStore.RestoreReminder(ctx, id))
+}
+
+func (store *EncryptedStore) GetReminderInConstruction(ctx context.Context, chatId int64, fromUserId int64) (*Reminder, error) {
+	return store.decryptOptionalReminder(store.ReminderStore.GetReminderInConstruction(ctx, chatId, fromUserId))
+}
+
+func (store *EncryptedStore) GetReminderById(ctx context.Context, id string) (*Reminder, error) {
+	return store.decryptOptionalReminder(store.ReminderStore.GetReminderById(ctx, id))
+}
+
+func (store *EncryptedStore) GetRemindersByChatId(ctx context.Context, chatId int64) ([]Reminder, error) {
+	return store.decryptReminders(store.ReminderStore.GetRemindersByChatId(ctx, chatId))
+}
+
+func (store *EncryptedStore) GetDueReminders(ctx context.Context, before time.Time, cursor string, limit int) ([]Reminder, error) {
+	return store.decryptReminders(store.ReminderStore.GetDueReminders(ctx, before, cursor, limit))
+}
+
+func (store *EncryptedStore) ClaimDueReminders(ctx context.Context, owner string, before time.Time, leaseDuration time.Duration, limit int) ([]Reminder, error) {
+	return store.decryptReminders(store.ReminderStore.ClaimDueReminders(ctx, owner, before, leaseDuration, limit))
+}
+
+func (store *EncryptedStore) ListChatReminders(ctx context.Context, chatId int64) ([]Reminder, error) {
+	return store.decryptReminders(store.ReminderStore.ListChatReminders(ctx, chatId))
+}
+
+// CreateAuditEntry encrypts the snapshots as a whole, as they hold the reminder text and file id.
+func (store *EncryptedStore) CreateAuditEntry(ctx context.Context, auditEntry AuditEntry) error {
+	var err error
+	auditEntry.Before, err = store.keyring.Encrypt(auditEntry.Before)
+	if err != nil {
+		return err
+	}
+	auditEntry.After, err = store.keyring.Encrypt(auditEntry.After)
+	if err != nil {
+		return err
+	}
+	return store.ReminderStore.CreateAuditEntry(ctx, auditEntry)
+}
+
+func (store *EncryptedStore) ListChatAuditEntries(ctx context.Context, chatId int64, limit int) ([]AuditEntry, error) {
+	auditEntries, err := store.ReminderStore.ListChatAuditEntries(ctx, chatId, limit)
+	if err != nil {
+		return nil, err
+	}
+	for i := range auditEntries {
+		auditEntries[i].Before, err = store.keyring.Decrypt(auditEntries[i].Before)
+		if err != nil {
+			return nil, fmt.Errorf("error decrypting audit entry %v: %v", auditEntries[i].Id, err)
+		}
+		auditEntries[i].After, err = store.keyring.Decrypt(auditEntries[i].After)
+		if err != nil {
+			return nil, fmt.Errorf("error decrypting audit entry %v: %v", auditEntries[i].Id, err)
+		}
+	}
+	return auditEntries, nil
+}
@@ -0,0 +1,156 @@
+package schemas
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Jason-CKY/telegram-reminderbot/pkg/utils"
+)
+
+// ChangeEvent reports items of a collection that were created, updated or
+// deleted, possibly by someone other than the bot. Keys are the primary keys of
+// the items as text. A CHANGE_RESYNC event has no keys, and means that changes
+// may have been missed, so anything derived from the collection should be reloaded.
+type ChangeEvent struct {
+	Collection string
+	Action     string
+	Keys       []string
+	// Reminders and ChatSettings hold the items as they were written, for creates
+	// and updates made by this instance of the bot. Events from elsewhere only carry keys.
+	Reminders    []Reminder
+	ChatSettings []ChatSettings
+}
+
+// EventBus hands change events to every subscriber, in the order they were published.
+type EventBus struct {
+	mu       sync.RWMutex
+	handlers []func(context.Context, ChangeEvent)
+}
+
+// Events carries the changes reported by the store, e.g. by a DirectusSubscriber.
+var Events = &EventBus{}
+
+// Subscribe registers a handler. Handlers are called one after the other on the
+// publishing goroutine, with its context, so they should return quickly.
+func (bus *EventBus) Subscribe(handler func(context.Context, ChangeEvent)) {
+	bus.mu.Lock()
+	defer bus.mu.Unlock()
+	bus.handlers = append(bus.handlers, handler)
+}
+
+func (bus *EventBus) Publish(ctx context.Context, event ChangeEvent) {
+	bus.mu.RLock()
+	defer bus.mu.RUnlock()
+	for _, handler := range bus.handlers {
+		handler(ctx, event)
+	}
+}
+
+// PublishingStore wraps another store and publishes the reminder and chat
+// settings changes made through it, so that the scheduler hears of them without
+// reading them back.
+type PublishingStore struct {
+	ReminderStore
+	bus *EventBus
+}
+
+func NewPublishingStore(store ReminderStore, bus *EventBus) *PublishingStore {
+	return &PublishingStore{
+		ReminderStore: store,
+		bus:           bus,
+	}
+}
+
+func (store *PublishingStore) publish(ctx context.Context, action string, ids []string, reminders []Reminder) {
+	store.bus.Publish(ctx, ChangeEvent{
+		Collection: "reminderbot_reminder",
+		Action:     action,
+		Keys:       ids,
+		Reminders:  reminders,
+	})
+}
+
+func (store *PublishingStore) CreateReminder(ctx context.Context, reminder Reminder) error {
+	err := store.ReminderStore.CreateReminder(ctx, reminder)
+	if err != nil {
+		return err
+	}
+	store.publish(ctx, utils.CHANGE_CREATE, []string{reminder.Id}, []Reminder{reminder})
+	return nil
+}
+
+func (store *PublishingStore) CreateChatSettings(ctx context.Context, chatSettings ChatSettings) error {
+	err := store.ReminderStore.CreateChatSettings(ctx, chatSettings)
+	if err != nil {
+		return err
+	}
+	store.publishChatSettings(ctx, utils.CHANGE_CREATE, chatSettings)
+	return nil
+}
+
+func (store *PublishingStore) UpdateChatSettings(ctx context.Context, chatSettings ChatSettings) error {
+	err := store.ReminderStore.UpdateChatSettings(ctx, chatSettings)
+	if err != nil {
+		return err
+	}
+	store.publishChatSettings(ctx, utils.CHANGE_UPDATE, chatSettings)
+	return nil
+}
+
+func (store *PublishingStore) publishChatSettings(ctx context.Context, action string, chatSettings ChatSettings) {
+	store.bus.Publish(ctx, ChangeEvent{
+		Collection:   "reminderbot_chat_settings",
+		Action:       action,
+		Keys:         []string{strconv.FormatInt(chatSettings.ChatId, 10)},
+		ChatSettings: []ChatSettings{chatSettings},
+	})
+}
+
+func (store *PublishingStore) UpdateReminder(ctx context.Context, reminder Reminder) error {
+	err := store.ReminderStore.UpdateReminder(ctx, reminder)
+	if err != nil {
+		return err
+	}
+	store.publish(ctx, utils.CHANGE_UPDATE, []string{reminder.Id}, []Reminder{reminder})
+	return nil
+}
+
+func (store *PublishingStore) DeleteReminder(ctx context.Context, id string) error {
+	err := store.ReminderStore.DeleteReminder(ctx, id)
+	if err != nil {
+		return err
+	}
+	store.publish(ctx, utils.CHANGE_DELETE, []string{id}, nil)
+	return nil
+}
+
+func (store *PublishingStore) SoftDeleteReminder(ctx context.Context, id string, deletedAt time.Time) error {
+	err := store.ReminderStore.SoftDeleteReminder(ctx, id, deletedAt)
+	if err != nil {
+		return err
+	}
+	store.publish(ctx, utils.CHANGE_DELETE, []string{id}, nil)
+	return nil
+}
+
+func (store *PublishingStore) RestoreReminder(ctx context.Context, id string) (*Reminder, error) {
+	reminder, err := store.ReminderStore.RestoreReminder(ctx, id)
+	if err != nil || reminder == nil {
+		return reminder, err
+	}
+	store.publish(ctx, utils.CHANGE_UPDATE, []string{id}, []Reminder{*reminder})
+	return reminder, nil
+}
+
+// EraseUserData and MigrateChat change reminders in bulk, so everything derived from them is reloaded.
+func (store *PublishingStore) EraseUserData(ctx context.Context, userId int64) (ErasedUserData, error) {
+	defer store.publish(ctx, utils.CHANGE_RESYNC, nil, nil)
+	return store.ReminderStore.EraseUserData(ctx, userId)
+}
+
+func (store *PublishingStore) MigrateChat(ctx context.Context, fromChatId int64, toChatId int64) error {
+	defer store.publish(ctx, utils.CHANGE_RESYNC, nil, nil)
+	return store.ReminderStore.MigrateChat(ctx, fromChatId, toChatId)
+}
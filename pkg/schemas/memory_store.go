@@ -0,0 +1,387 @@
+package schemas
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Jason-CKY/telegram-reminderbot/pkg/utils"
+)
+
+// MemoryStore keeps reminders and chat settings in process memory. It is meant
+// for tests and single binary deployments where losing data on restart is acceptable.
+type MemoryStore struct {
+	mu           sync.RWMutex
+	reminders    map[string]Reminder
+	createdOrder map[string]int64
+	sequence     int64
+	chatSettings map[int64]ChatSettings
+	leases       map[string]reminderLease
+	deletedAt    map[string]time.Time
+	deliveries   []Delivery
+	auditEntries []AuditEntry
+}
+
+type reminderLease struct {
+	owner     string
+	expiresAt time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		reminders:    map[string]Reminder{},
+		createdOrder: map[string]int64{},
+		chatSettings: map[int64]ChatSettings{},
+		leases:       map[string]reminderLease{},
+		deletedAt:    map[string]time.Time{},
+	}
+}
+
+func (store *MemoryStore) Migrate(ctx context.Context) error {
+	return nil
+}
+
+// filterReminders returns the reminders matching keep, in creation order.
+// Soft deleted reminders are never returned.
+func (store *MemoryStore) filterReminders(keep func(Reminder) bool) []Reminder {
+	var reminders []Reminder
+	for _, reminder := range store.reminders {
+		if _, deleted := store.deletedAt[reminder.Id]; !deleted && keep(reminder) {
+			reminders = append(reminders, reminder)
+		}
+	}
+	sort.Slice(reminders, func(i, j int) bool {
+		return store.createdOrder[reminders[i].Id] < store.createdOrder[reminders[j].Id]
+	})
+	return reminders
+}
+
+func (store *MemoryStore) CreateReminder(ctx context.Context, reminder Reminder) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	if _, ok := store.reminders[reminder.Id]; ok {
+		return fmt.Errorf("reminder %v already exists", reminder.Id)
+	}
+	store.sequence++
+	store.reminders[reminder.Id] = reminder
+	store.createdOrder[reminder.Id] = store.sequence
+	return nil
+}
+
+func (store *MemoryStore) UpdateReminder(ctx context.Context, reminder Reminder) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	if _, ok := store.reminders[reminder.Id]; !ok {
+		return fmt.Errorf("reminder %v not found", reminder.Id)
+	}
+	store.reminders[reminder.Id] = reminder
+	delete(store.leases, reminder.Id)
+	return nil
+}
+
+func (store *MemoryStore) DeleteReminder(ctx context.Context, id string) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	if _, ok := store.reminders[id]; !ok {
+		return fmt.Errorf("reminder %v not found", id)
+	}
+	delete(store.reminders, id)
+	delete(store.createdOrder, id)
+	delete(store.leases, id)
+	delete(store.deletedAt, id)
+	return nil
+}
+
+func (store *MemoryStore) SoftDeleteReminder(ctx context.Context, id string, deletedAt time.Time) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	if _, ok := store.reminders[id]; !ok {
+		return fmt.Errorf("reminder %v not found", id)
+	}
+	store.deletedAt[id] = deletedAt
+	delete(store.leases, id)
+	return nil
+}
+
+func (store *MemoryStore) RestoreReminder(ctx context.Context, id string) (*Reminder, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	if _, deleted := store.deletedAt[id]; !deleted {
+		return nil, nil
+	}
+	delete(store.deletedAt, id)
+	reminder := store.reminders[id]
+	return &reminder, nil
+}
+
+func (store *MemoryStore) PurgeDeletedReminders(ctx context.Context, before time.Time) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	for id, deletedAt := range store.deletedAt {
+		if deletedAt.Before(before) {
+			delete(store.reminders, id)
+			delete(store.createdOrder, id)
+			delete(store.leases, id)
+			delete(store.deletedAt, id)
+		}
+	}
+	return nil
+}
+
+func (store *MemoryStore) DeleteRemindersInConstruction(ctx context.Context, chatId int64, fromUserId int64) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	for id, reminder := range store.reminders {
+		if reminder.ChatId == chatId && reminder.FromUserId == fromUserId && reminder.InConstruction {
+			delete(store.reminders, id)
+			delete(store.createdOrder, id)
+			delete(store.deletedAt, id)
+		}
+	}
+	return nil
+}
+
+func (store *MemoryStore) GetReminderInConstruction(ctx context.Context, chatId int64, fromUserId int64) (*Reminder, error) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+	reminders := store.filterReminders(func(reminder Reminder) bool {
+		return reminder.ChatId == chatId && reminder.FromUserId == fromUserId && reminder.InConstruction
+	})
+	if len(reminders) == 0 {
+		return nil, nil
+	}
+	return &reminders[0], nil
+}
+
+func (store *MemoryStore) GetReminderById(ctx context.Context, id string) (*Reminder, error) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+	reminder, ok := store.reminders[id]
+	if _, deleted := store.deletedAt[id]; !ok || deleted {
+		return nil, nil
+	}
+	return &reminder, nil
+}
+
+func (store *MemoryStore) GetRemindersByChatId(ctx context.Context, chatId int64) ([]Reminder, error) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+	return store.filterReminders(func(reminder Reminder) bool {
+		return reminder.ChatId == chatId && !reminder.InConstruction
+	}), nil
+}
+
+func (store *MemoryStore) GetDueReminders(ctx context.Context, before time.Time, cursor string, limit int) ([]Reminder, error) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+	beforeText := before.UTC().Format(utils.DIRECTUS_DATETIME_FORMAT)
+	reminders := store.filterReminders(func(reminder Reminder) bool {
+		// DIRECTUS_DATETIME_FORMAT sorts lexicographically in time order
+		return !reminder.InConstruction && reminder.NextTriggerTime != "" && reminder.NextTriggerTime < beforeText && reminder.Id > cursor
+	})
+	sort.Slice(reminders, func(i, j int) bool {
+		return reminders[i].Id < reminders[j].Id
+	})
+	if len(reminders) > limit {
+		reminders = reminders[:limit]
+	}
+	return reminders, nil
+}
+
+func (store *MemoryStore) ClaimDueReminders(ctx context.Context, owner string, before time.Time, leaseDuration time.Duration, limit int) ([]Reminder, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	now := time.Now()
+	beforeText := before.UTC().Format(utils.DIRECTUS_DATETIME_FORMAT)
+	reminders := store.filterReminders(func(reminder Reminder) bool {
+		lease, leased := store.leases[reminder.Id]
+		return !reminder.InConstruction && reminder.NextTriggerTime != "" && reminder.NextTriggerTime < beforeText && (!leased || lease.expiresAt.Before(now))
+	})
+	sort.Slice(reminders, func(i, j int) bool {
+		return reminders[i].NextTriggerTime < reminders[j].NextTriggerTime
+	})
+	if len(reminders) > limit {
+		reminders = reminders[:limit]
+	}
+	for _, reminder := range reminders {
+		store.leases[reminder.Id] = reminderLease{owner: owner, expiresAt: now.Add(leaseDuration)}
+	}
+	return reminders, nil
+}
+
+func (store *MemoryStore) ListChatReminders(ctx context.Context, chatId int64) ([]Reminder, error) {
+	return store.GetRemindersByChatId(ctx, chatId)
+}
+
+func (store *MemoryStore) CreateChatSettings(ctx context.Context, chatSettings ChatSettings) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	if _, ok := store.chatSettings[chatSettings.ChatId]; ok {
+		return fmt.Errorf("chat settings for %v already exists", chatSettings.ChatId)
+	}
+	store.chatSettings[chatSettings.ChatId] = chatSettings
+	return nil
+}
+
+func (store *MemoryStore) UpdateChatSettings(ctx context.Context, chatSettings ChatSettings) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	if _, ok := store.chatSettings[chatSettings.ChatId]; !ok {
+		return fmt.Errorf("chat settings for %v not found", chatSettings.ChatId)
+	}
+	store.chatSettings[chatSettings.ChatId] = chatSettings
+	return nil
+}
+
+func (store *MemoryStore) DeleteChatSettings(ctx context.Context, chatId int64) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	if _, ok := store.chatSettings[chatId]; !ok {
+		return fmt.Errorf("chat settings for %v not found", chatId)
+	}
+	delete(store.chatSettings, chatId)
+	return nil
+}
+
+func (store *MemoryStore) GetChatSettings(ctx context.Context, chatId int64) (*ChatSettings, error) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+	chatSettings, ok := store.chatSettings[chatId]
+	if !ok {
+		return nil, nil
+	}
+	return &chatSettings, nil
+}
+
+func (store *MemoryStore) EraseUserData(ctx context.Context, userId int64) (ErasedUserData, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	var erased ErasedUserData
+	reminderIds := map[string]bool{}
+	for id, reminder := range store.reminders {
+		if reminder.FromUserId == userId {
+			reminderIds[id] = true
+			delete(store.reminders, id)
+			delete(store.createdOrder, id)
+			delete(store.leases, id)
+			delete(store.deletedAt, id)
+			erased.Reminders++
+		}
+	}
+	var deliveries []Delivery
+	for _, delivery := range store.deliveries {
+		if reminderIds[delivery.ReminderId] {
+			erased.Deliveries++
+			continue
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	store.deliveries = deliveries
+	var auditEntries []AuditEntry
+	for _, auditEntry := range store.auditEntries {
+		if auditEntry.UserId == userId || auditEntry.ChatId == userId || (auditEntry.EntityType == utils.AUDIT_ENTITY_REMINDER && reminderIds[auditEntry.EntityId]) {
+			erased.AuditEntries++
+			continue
+		}
+		auditEntries = append(auditEntries, auditEntry)
+	}
+	store.auditEntries = auditEntries
+	if _, ok := store.chatSettings[userId]; ok {
+		delete(store.chatSettings, userId)
+		erased.ChatSettings++
+	}
+	return erased, nil
+}
+
+func (store *MemoryStore) RewriteSensitiveFields(ctx context.Context, rewrite func(string) (string, error)) (int, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	rewritten := 0
+	for id, reminder := range store.reminders {
+		changed, err := rewriteValues(rewrite, &reminder.ReminderText, &reminder.FileId)
+		if err != nil {
+			return rewritten, fmt.Errorf("error rewriting reminder %v: %v", id, err)
+		}
+		if changed {
+			store.reminders[id] = reminder
+			rewritten++
+		}
+	}
+	for i := range store.auditEntries {
+		changed, err := rewriteValues(rewrite, &store.auditEntries[i].Before, &store.auditEntries[i].After)
+		if err != nil {
+			return rewritten, fmt.Errorf("error rewriting audit entry %v: %v", store.auditEntries[i].Id, err)
+		}
+		if changed {
+			rewritten++
+		}
+	}
+	return rewritten, nil
+}
+
+func (store *MemoryStore) MigrateChat(ctx context.Context, fromChatId int64, toChatId int64) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	if chatSettings, ok := store.chatSettings[fromChatId]; ok {
+		chatSettings.ChatId = toChatId
+		store.chatSettings[toChatId] = chatSettings
+		delete(store.chatSettings, fromChatId)
+	}
+	for id, reminder := range store.reminders {
+		if reminder.ChatId == fromChatId {
+			reminder.ChatId = toChatId
+			store.reminders[id] = reminder
+		}
+	}
+	for i := range store.deliveries {
+		if store.deliveries[i].ChatId == fromChatId {
+			store.deliveries[i].ChatId = toChatId
+		}
+	}
+	for i := range store.auditEntries {
+		if store.auditEntries[i].ChatId == fromChatId {
+			store.auditEntries[i].ChatId = toChatId
+		}
+	}
+	return nil
+}
+
+func (store *MemoryStore) CreateDelivery(ctx context.Context, delivery Delivery) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	store.deliveries = append(store.deliveries, delivery)
+	return nil
+}
+
+func (store *MemoryStore) ListReminderDeliveries(ctx context.Context, reminderId string, limit int) ([]Delivery, error) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+	var deliveries []Delivery
+	for i := len(store.deliveries) - 1; i >= 0 && len(deliveries) < limit; i-- {
+		if store.deliveries[i].ReminderId == reminderId {
+			deliveries = append(deliveries, store.deliveries[i])
+		}
+	}
+	return deliveries, nil
+}
+
+func (store *MemoryStore) CreateAuditEntry(ctx context.Context, auditEntry AuditEntry) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	store.auditEntries = append(store.auditEntries, auditEntry)
+	return nil
+}
+
+func (store *MemoryStore) ListChatAuditEntries(ctx context.Context, chatId int64, limit int) ([]AuditEntry, error) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+	var auditEntries []AuditEntry
+	for i := len(store.auditEntries) - 1; i >= 0 && len(auditEntries) < limit; i-- {
+		if store.auditEntries[i].ChatId == chatId {
+			auditEntries = append(auditEntries, store.auditEntries[i])
+		}
+	}
+	return auditEntries, nil
+}
@@ -0,0 +1,369 @@
+package schemas
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Jason-CKY/telegram-reminderbot/pkg/utils"
+	log "github.com/sirupsen/logrus"
+	"github.com/teambition/rrule-go"
+)
+
+// ErrRecurrenceEnded is returned by Next when an RRULE has no occurrences left,
+// because of its COUNT or UNTIL.
+var ErrRecurrenceEnded = errors.New("recurrence has no more occurrences")
+
+// Recurrence describes when a reminder fires. It is stored using the original
+// frequency strings: "Once-2024/05/01", "Daily", "Weekly-3", "Monthly-15" and
+// "Yearly-2024/05/01", plus "RRule-FREQ=WEEKLY;BYDAY=MO,WE,FR" for RFC 5545
+// rules. A Kind without its detail (e.g. "Weekly") is a pending
+// recurrence, used while a reminder is still being built.
+//
+// Use the New*Recurrence constructors or ParseRecurrence to build one.
+type Recurrence struct {
+	Kind string
+	// Date is set for Once and Yearly recurrences, as a UTC midnight.
+	Date time.Time
+	// Weekday is set for Weekly recurrences.
+	Weekday time.Weekday
+	// DayOfMonth is set for Monthly recurrences, 1-31.
+	DayOfMonth int
+	// RRule is set for RRule recurrences, e.g. "FREQ=MONTHLY;BYSETPOS=-1;BYDAY=FR;DTSTART=20240501".
+	// The time of day always comes from the reminder, not from the rule.
+	RRule string
+
+	hasDetail bool
+	// invalid keeps unparseable data read from storage so it round trips unchanged.
+	invalid string
+}
+
+func NewPendingRecurrence(kind string) Recurrence {
+	return Recurrence{Kind: kind}
+}
+
+func NewOnceRecurrence(date time.Time) Recurrence {
+	return Recurrence{Kind: utils.REMINDER_ONCE, Date: dateOnly(date), hasDetail: true}
+}
+
+func NewDailyRecurrence() Recurrence {
+	return Recurrence{Kind: utils.REMINDER_DAILY, hasDetail: true}
+}
+
+func NewWeeklyRecurrence(weekday time.Weekday) Recurrence {
+	return Recurrence{Kind: utils.REMINDER_WEEKLY, Weekday: weekday, hasDetail: true}
+}
+
+func NewMonthlyRecurrence(dayOfMonth int) Recurrence {
+	return Recurrence{Kind: utils.REMINDER_MONTHLY, DayOfMonth: dayOfMonth, hasDetail: true}
+}
+
+func NewYearlyRecurrence(date time.Time) Recurrence {
+	return Recurrence{Kind: utils.REMINDER_YEARLY, Date: dateOnly(date), hasDetail: true}
+}
+
+// NewRRuleRecurrence builds a recurrence from an RFC 5545 RRULE, for a reminder
+// that fires at reminderTime (<HH>:<MM>). Rules without a DTSTART are anchored
+// to the first reminder time at or after start, so that INTERVAL and COUNT keep
+// counting from the reminder's first occurrence.
+func NewRRuleRecurrence(rule string, reminderTime string, start time.Time) (Recurrence, error) {
+	if !utils.IsValidTime(reminderTime) {
+		return Recurrence{}, fmt.Errorf("invalid reminder time: %q", reminderTime)
+	}
+	rule = strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(rule)), "RRULE:")
+	if !strings.Contains(";"+rule, ";DTSTART=") {
+		// today's reminder time may have passed already, it must not count towards COUNT
+		reminderHour, reminderMinute := utils.ParseReminderTime(reminderTime)
+		if first := time.Date(start.Year(), start.Month(), start.Day(), reminderHour, reminderMinute, 0, 0, start.Location()); first.Before(start) {
+			start = start.AddDate(0, 0, 1)
+		}
+		rule = fmt.Sprintf("%v;DTSTART=%v", rule, start.Format(utils.RRULE_DATE_FORMAT))
+	}
+	recurrence := Recurrence{Kind: utils.REMINDER_RRULE, RRule: rule, hasDetail: true}
+	if err := recurrence.Validate(); err != nil {
+		return Recurrence{}, err
+	}
+	return recurrence, nil
+}
+
+func dateOnly(date time.Time) time.Time {
+	return time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// ParseRecurrence parses a frequency string. The empty string is the zero Recurrence.
+func ParseRecurrence(text string) (Recurrence, error) {
+	if text == "" {
+		return Recurrence{}, nil
+	}
+	kind, detail, hasDetail := strings.Cut(text, "-")
+	switch kind {
+	case utils.REMINDER_ONCE, utils.REMINDER_WEEKLY, utils.REMINDER_MONTHLY, utils.REMINDER_YEARLY, utils.REMINDER_RRULE:
+	case utils.REMINDER_DAILY:
+		if hasDetail {
+			return Recurrence{}, fmt.Errorf("invalid frequency %q: daily reminders take no detail", text)
+		}
+		return NewDailyRecurrence(), nil
+	default:
+		return Recurrence{}, fmt.Errorf("invalid frequency %q: unknown kind %q", text, kind)
+	}
+	if !hasDetail {
+		return NewPendingRecurrence(kind), nil
+	}
+
+	var recurrence Recurrence
+	switch kind {
+	case utils.REMINDER_ONCE, utils.REMINDER_YEARLY:
+		date, err := time.Parse(utils.DATE_FORMAT, detail)
+		if err != nil {
+			return Recurrence{}, fmt.Errorf("invalid frequency %q: %v", text, err)
+		}
+		recurrence = Recurrence{Kind: kind, Date: date, hasDetail: true}
+	case utils.REMINDER_WEEKLY:
+		weekday, err := strconv.Atoi(detail)
+		if err != nil {
+			return Recurrence{}, fmt.Errorf("invalid frequency %q: %v", text, err)
+		}
+		recurrence = NewWeeklyRecurrence(time.Weekday(weekday))
+	case utils.REMINDER_MONTHLY:
+		day, err := strconv.Atoi(detail)
+		if err != nil {
+			return Recurrence{}, fmt.Errorf("invalid frequency %q: %v", text, err)
+		}
+		recurrence = NewMonthlyRecurrence(day)
+	case utils.REMINDER_RRULE:
+		recurrence = Recurrence{Kind: kind, RRule: detail, hasDetail: true}
+	}
+	if err := recurrence.Validate(); err != nil {
+		return Recurrence{}, err
+	}
+	return recurrence, nil
+}
+
+func (recurrence Recurrence) IsZero() bool {
+	return recurrence.Kind == "" && recurrence.invalid == ""
+}
+
+// IsPending reports whether the kind is known but its detail has not been chosen yet.
+func (recurrence Recurrence) IsPending() bool {
+	return recurrence.Kind != "" && !recurrence.hasDetail
+}
+
+// Validate checks that the recurrence is complete and within range.
+func (recurrence Recurrence) Validate() error {
+	if recurrence.invalid != "" {
+		return fmt.Errorf("invalid frequency %q", recurrence.invalid)
+	}
+	if recurrence.Kind == "" {
+		return errors.New("frequency is not set")
+	}
+	if recurrence.IsPending() {
+		return fmt.Errorf("%v frequency is incomplete", recurrence.Kind)
+	}
+	switch recurrence.Kind {
+	case utils.REMINDER_ONCE, utils.REMINDER_YEARLY:
+		if recurrence.Date.IsZero() {
+			return fmt.Errorf("%v frequency is missing a date", recurrence.Kind)
+		}
+	case utils.REMINDER_DAILY:
+	case utils.REMINDER_WEEKLY:
+		if recurrence.Weekday < time.Sunday || recurrence.Weekday > time.Saturday {
+			return fmt.Errorf("invalid day of week: %v", int(recurrence.Weekday))
+		}
+	case utils.REMINDER_MONTHLY:
+		if recurrence.DayOfMonth < 1 || recurrence.DayOfMonth > 31 {
+			return fmt.Errorf("invalid day of month: %v", recurrence.DayOfMonth)
+		}
+	case utils.REMINDER_RRULE:
+		return validateRRule(recurrence.RRule)
+	default:
+		return fmt.Errorf("unknown frequency: %v", recurrence.Kind)
+	}
+	return nil
+}
+
+// String formats the recurrence in the stored frequency format.
+func (recurrence Recurrence) String() string {
+	if recurrence.invalid != "" {
+		return recurrence.invalid
+	}
+	if recurrence.Kind == "" || recurrence.IsPending() {
+		return recurrence.Kind
+	}
+	switch recurrence.Kind {
+	case utils.REMINDER_ONCE, utils.REMINDER_YEARLY:
+		return fmt.Sprintf("%v-%v", recurrence.Kind, recurrence.Date.Format(utils.DATE_FORMAT))
+	case utils.REMINDER_WEEKLY:
+		return fmt.Sprintf("%v-%v", recurrence.Kind, int(recurrence.Weekday))
+	case utils.REMINDER_MONTHLY:
+		return fmt.Sprintf("%v-%v", recurrence.Kind, recurrence.DayOfMonth)
+	case utils.REMINDER_RRULE:
+		return fmt.Sprintf("%v-%v", recurrence.Kind, recurrence.RRule)
+	default:
+		return recurrence.Kind
+	}
+}
+
+// Rule returns the RRULE without its DTSTART, for display.
+func (recurrence Recurrence) Rule() string {
+	var parts []string
+	for _, part := range strings.Split(recurrence.RRule, ";") {
+		if !strings.HasPrefix(part, "DTSTART=") {
+			parts = append(parts, part)
+		}
+	}
+	return strings.Join(parts, ";")
+}
+
+// validateRRule only accepts rules that produce at most one occurrence a day,
+// since a reminder has a single time of day.
+func validateRRule(rule string) error {
+	if rule == "" {
+		return errors.New("RRule frequency is missing a rule")
+	}
+	if strings.Contains(rule, "\n") {
+		return errors.New("invalid RRULE: only a single RRULE line is supported")
+	}
+	option, err := rrule.StrToROption(rule)
+	if err != nil {
+		return fmt.Errorf("invalid RRULE: %v", err)
+	}
+	switch option.Freq {
+	case rrule.DAILY, rrule.WEEKLY, rrule.MONTHLY, rrule.YEARLY:
+	default:
+		return fmt.Errorf("invalid RRULE: FREQ=%v is not supported, use DAILY, WEEKLY, MONTHLY or YEARLY", option.Freq)
+	}
+	if len(option.Byhour) > 0 || len(option.Byminute) > 0 || len(option.Bysecond) > 0 {
+		return errors.New("invalid RRULE: BYHOUR, BYMINUTE and BYSECOND are not supported, the reminder time is used instead")
+	}
+	if _, err := rrule.NewRRule(*option); err != nil {
+		return fmt.Errorf("invalid RRULE: %v", err)
+	}
+	return nil
+}
+
+// MarshalJSON implements the json.Marshaler interface.
+func (recurrence Recurrence) MarshalJSON() ([]byte, error) {
+	return json.Marshal(recurrence.String())
+}
+
+// UnmarshalJSON implements the json.Unmarshaler interface. Malformed frequencies
+// are kept as invalid recurrences instead of failing, so one bad row cannot
+// break every query that returns it.
+func (recurrence *Recurrence) UnmarshalJSON(data []byte) error {
+	var text *string
+	if err := json.Unmarshal(data, &text); err != nil {
+		return err
+	}
+	if text == nil {
+		*recurrence = Recurrence{}
+		return nil
+	}
+	*recurrence = parseStoredRecurrence(*text)
+	return nil
+}
+
+// Value implements the driver.Valuer interface.
+func (recurrence Recurrence) Value() (driver.Value, error) {
+	return recurrence.String(), nil
+}
+
+// Scan implements the sql.Scanner interface.
+func (recurrence *Recurrence) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case nil:
+		*recurrence = Recurrence{}
+	case string:
+		*recurrence = parseStoredRecurrence(v)
+	case []byte:
+		*recurrence = parseStoredRecurrence(string(v))
+	default:
+		return fmt.Errorf("unexpected type for frequency: %T", v)
+	}
+	return nil
+}
+
+func parseStoredRecurrence(text string) Recurrence {
+	recurrence, err := ParseRecurrence(text)
+	if err != nil {
+		log.Warnf("keeping unparseable frequency as invalid: %v", err)
+		return Recurrence{invalid: text}
+	}
+	return recurrence
+}
+
+// Next returns the first trigger time strictly after the given time, for a
+// reminder that fires at reminderTime (<HH>:<MM>) in the tz timezone. Once
+// reminders always return their single trigger time, even if it has passed.
+func (recurrence Recurrence) Next(reminderTime string, after time.Time, tz *time.Location) (time.Time, error) {
+	if err := recurrence.Validate(); err != nil {
+		return after, err
+	}
+	if !utils.IsValidTime(reminderTime) {
+		return after, fmt.Errorf("invalid reminder time: %q", reminderTime)
+	}
+	reminderHour, reminderMinute := utils.ParseReminderTime(reminderTime)
+	currentTime := after.In(tz)
+
+	switch recurrence.Kind {
+	case utils.REMINDER_ONCE:
+		triggerTime := time.Date(recurrence.Date.Year(), recurrence.Date.Month(), recurrence.Date.Day(), reminderHour, reminderMinute, 0, 0, tz)
+		return triggerTime.In(time.UTC), nil
+	case utils.REMINDER_DAILY:
+		triggerTime := time.Date(currentTime.Year(), currentTime.Month(), currentTime.Day(), reminderHour, reminderMinute, 0, 0, tz)
+		if !triggerTime.After(currentTime) {
+			triggerTime = triggerTime.AddDate(0, 0, 1)
+		}
+		return triggerTime.In(time.UTC), nil
+	case utils.REMINDER_WEEKLY:
+		triggerTime := time.Date(currentTime.Year(), currentTime.Month(), currentTime.Day(), reminderHour, reminderMinute, 0, 0, tz)
+		for recurrence.Weekday != triggerTime.Weekday() {
+			triggerTime = triggerTime.AddDate(0, 0, 1)
+		}
+		if !triggerTime.After(currentTime) {
+			triggerTime = triggerTime.AddDate(0, 0, 7)
+		}
+		return triggerTime.In(time.UTC), nil
+	case utils.REMINDER_MONTHLY:
+		triggerTime := time.Date(currentTime.Year(), currentTime.Month(), recurrence.DayOfMonth, reminderHour, reminderMinute, 0, 0, tz)
+		if !triggerTime.After(currentTime) {
+			triggerTime = time.Date(currentTime.Year(), currentTime.Month()+1, recurrence.DayOfMonth, reminderHour, reminderMinute, 0, 0, tz)
+		}
+		return triggerTime.In(time.UTC), nil
+	case utils.REMINDER_YEARLY:
+		triggerTime := time.Date(currentTime.Year(), recurrence.Date.Month(), recurrence.Date.Day(), reminderHour, reminderMinute, 0, 0, tz)
+		if !triggerTime.After(currentTime) {
+			triggerTime = time.Date(currentTime.Year()+1, recurrence.Date.Month(), recurrence.Date.Day(), reminderHour, reminderMinute, 0, 0, tz)
+		}
+		return triggerTime.In(time.UTC), nil
+	case utils.REMINDER_RRULE:
+		// dates without a time or zone in the rule are read in the chat's timezone
+		option, err := rrule.StrToROptionInLocation(recurrence.RRule, tz)
+		if err != nil {
+			return after, err
+		}
+		start := currentTime
+		if !option.Dtstart.IsZero() {
+			start = option.Dtstart.In(tz)
+		}
+		option.Dtstart = time.Date(start.Year(), start.Month(), start.Day(), reminderHour, reminderMinute, 0, 0, tz)
+		// an UNTIL date includes the whole day
+		if until := option.Until.In(tz); !option.Until.IsZero() && until.Hour() == 0 && until.Minute() == 0 && until.Second() == 0 {
+			option.Until = until.AddDate(0, 0, 1).Add(-time.Second)
+		}
+		rule, err := rrule.NewRRule(*option)
+		if err != nil {
+			return after, err
+		}
+		triggerTime := rule.After(currentTime, false)
+		if triggerTime.IsZero() {
+			return after, ErrRecurrenceEnded
+		}
+		return triggerTime.In(time.UTC), nil
+	default:
+		return after, errors.New("invalid frequency")
+	}
+}
@@ -0,0 +1,89 @@
+package schemas
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// occurrences returns the trigger times of a 09:00 reminder with the given
+// RRULE, created at created, until the recurrence ends or max is reached.
+func occurrences(t *testing.T, rule string, created time.Time, max int) []time.Time {
+	t.Helper()
+	recurrence, err := NewRRuleRecurrence(rule, "09:00", created)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var triggerTimes []time.Time
+	after := created
+	for len(triggerTimes) < max {
+		next, err := recurrence.Next("09:00", after, created.Location())
+		if errors.Is(err, ErrRecurrenceEnded) {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		triggerTimes = append(triggerTimes, next.In(created.Location()))
+		after = next
+	}
+	return triggerTimes
+}
+
+func checkOccurrences(t *testing.T, got []time.Time, want ...time.Time) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("got occurrences %v, want %v", got, want)
+	}
+	for i := range want {
+		if !got[i].Equal(want[i]) {
+			t.Errorf("occurrence %v is %v, want %v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestRRuleCountStartsAtFirstOccurrence(t *testing.T) {
+	singapore, err := time.LoadLocation("Asia/Singapore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	day := func(d int) time.Time { return time.Date(2024, 5, d, 9, 0, 0, 0, singapore) }
+
+	// created after the day's reminder time, the first occurrence is the next day
+	got := occurrences(t, "FREQ=DAILY;COUNT=2", time.Date(2024, 5, 1, 13, 59, 0, 0, singapore), 10)
+	checkOccurrences(t, got, day(2), day(3))
+
+	// created before the day's reminder time, the first occurrence is the same day
+	got = occurrences(t, "FREQ=DAILY;COUNT=2", time.Date(2024, 5, 1, 8, 0, 0, 0, singapore), 10)
+	checkOccurrences(t, got, day(1), day(2))
+
+	got = occurrences(t, "FREQ=DAILY;INTERVAL=2;COUNT=3", time.Date(2024, 5, 1, 13, 59, 0, 0, singapore), 10)
+	checkOccurrences(t, got, day(2), day(4), day(6))
+}
+
+func TestRRuleUntilIncludesLastDay(t *testing.T) {
+	singapore, err := time.LoadLocation("Asia/Singapore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	day := func(d int) time.Time { return time.Date(2024, 5, d, 9, 0, 0, 0, singapore) }
+
+	got := occurrences(t, "FREQ=DAILY;UNTIL=20240503", time.Date(2024, 5, 1, 13, 59, 0, 0, singapore), 10)
+	checkOccurrences(t, got, day(2), day(3))
+
+	got = occurrences(t, "FREQ=DAILY;UNTIL=20240503", time.Date(2024, 5, 1, 8, 0, 0, 0, singapore), 10)
+	checkOccurrences(t, got, day(1), day(2), day(3))
+
+	// created after the reminder time on the UNTIL date, there is nothing left
+	got = occurrences(t, "FREQ=DAILY;UNTIL=20240503", time.Date(2024, 5, 3, 13, 59, 0, 0, singapore), 10)
+	checkOccurrences(t, got)
+}
+
+func TestRRuleKeepsExplicitDtstart(t *testing.T) {
+	recurrence, err := NewRRuleRecurrence("FREQ=WEEKLY;DTSTART=20240101", "09:00", time.Date(2024, 5, 1, 13, 59, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if recurrence.RRule != "FREQ=WEEKLY;DTSTART=20240101" {
+		t.Errorf("rule is %v, want the DTSTART kept", recurrence.RRule)
+	}
+}
@@ -0,0 +1,269 @@
+package schemas
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/Jason-CKY/telegram-reminderbot/pkg/utils"
+	log "github.com/sirupsen/logrus"
+)
+
+// sqlMigration is one versioned schema change. Statements are keyed by dialect
+// so that each backend can use its own column types.
+type sqlMigration struct {
+	version     int
+	description string
+	statements  map[string][]string
+}
+
+// sqlMigrations must only ever be appended to. The first migration uses
+// IF NOT EXISTS so that tables previously created through Directus are adopted as-is.
+var sqlMigrations = []sqlMigration{
+	{
+		version:     1,
+		description: "create reminder and chat settings tables",
+		statements: map[string][]string{
+			utils.STORE_POSTGRES: {
+				`CREATE TABLE IF NOT EXISTS reminderbot_chat_settings (
+					chat_id BIGINT PRIMARY KEY,
+					date_created TIMESTAMP WITH TIME ZONE,
+					date_updated TIMESTAMP WITH TIME ZONE,
+					timezone VARCHAR(255) NOT NULL,
+					updating BOOLEAN DEFAULT FALSE
+				)`,
+				`CREATE TABLE IF NOT EXISTS reminderbot_reminder (
+					id UUID PRIMARY KEY,
+					date_created TIMESTAMP WITH TIME ZONE,
+					date_updated TIMESTAMP WITH TIME ZONE,
+					chat_id BIGINT REFERENCES reminderbot_chat_settings (chat_id) ON DELETE SET NULL,
+					from_user_id BIGINT NOT NULL,
+					file_id VARCHAR(255),
+					reminder_text TEXT,
+					frequency VARCHAR(255),
+					time VARCHAR(255),
+					in_construction BOOLEAN NOT NULL DEFAULT TRUE,
+					next_trigger_time TIMESTAMP
+				)`,
+				`CREATE INDEX IF NOT EXISTS reminderbot_reminder_next_trigger_time_idx ON reminderbot_reminder (next_trigger_time)`,
+				`CREATE INDEX IF NOT EXISTS reminderbot_reminder_chat_id_idx ON reminderbot_reminder (chat_id)`,
+			},
+			// datetimes are stored as DIRECTUS_DATETIME_FORMAT text, which compares correctly as strings
+			utils.STORE_SQLITE: {
+				`CREATE TABLE IF NOT EXISTS reminderbot_chat_settings (
+					chat_id INTEGER PRIMARY KEY,
+					date_created TEXT,
+					date_updated TEXT,
+					timezone TEXT NOT NULL,
+					updating BOOLEAN DEFAULT FALSE
+				)`,
+				`CREATE TABLE IF NOT EXISTS reminderbot_reminder (
+					id TEXT PRIMARY KEY,
+					date_created TEXT,
+					date_updated TEXT,
+					chat_id INTEGER REFERENCES reminderbot_chat_settings (chat_id) ON DELETE SET NULL,
+					from_user_id INTEGER NOT NULL,
+					file_id TEXT,
+					reminder_text TEXT,
+					frequency TEXT,
+					time TEXT,
+					in_construction BOOLEAN NOT NULL DEFAULT TRUE,
+					next_trigger_time TEXT
+				)`,
+				`CREATE INDEX IF NOT EXISTS reminderbot_reminder_next_trigger_time_idx ON reminderbot_reminder (next_trigger_time)`,
+				`CREATE INDEX IF NOT EXISTS reminderbot_reminder_chat_id_idx ON reminderbot_reminder (chat_id)`,
+			},
+		},
+	},
+	{
+		version:     2,
+		description: "add reminder lease columns",
+		statements: map[string][]string{
+			utils.STORE_POSTGRES: {
+				`ALTER TABLE reminderbot_reminder ADD COLUMN IF NOT EXISTS lease_owner VARCHAR(255)`,
+				`ALTER TABLE reminderbot_reminder ADD COLUMN IF NOT EXISTS lease_expires_at TIMESTAMP`,
+			},
+			utils.STORE_SQLITE: {
+				`ALTER TABLE reminderbot_reminder ADD COLUMN lease_owner TEXT`,
+				`ALTER TABLE reminderbot_reminder ADD COLUMN lease_expires_at TEXT`,
+			},
+		},
+	},
+	{
+		version:     3,
+		description: "create delivery table",
+		statements: map[string][]string{
+			// no foreign key to reminderbot_reminder, deliveries outlive the reminders they belong to
+			utils.STORE_POSTGRES: {
+				`CREATE TABLE IF NOT EXISTS reminderbot_delivery (
+					id UUID PRIMARY KEY,
+					reminder_id UUID NOT NULL,
+					chat_id BIGINT NOT NULL,
+					scheduled_time TIMESTAMP,
+					sent_time TIMESTAMP,
+					message_id BIGINT,
+					outcome VARCHAR(255) NOT NULL,
+					error TEXT
+				)`,
+				`CREATE INDEX IF NOT EXISTS reminderbot_delivery_reminder_id_idx ON reminderbot_delivery (reminder_id, sent_time)`,
+				`CREATE INDEX IF NOT EXISTS reminderbot_delivery_chat_id_idx ON reminderbot_delivery (chat_id)`,
+			},
+			utils.STORE_SQLITE: {
+				`CREATE TABLE IF NOT EXISTS reminderbot_delivery (
+					id TEXT PRIMARY KEY,
+					reminder_id TEXT NOT NULL,
+					chat_id INTEGER NOT NULL,
+					scheduled_time TEXT,
+					sent_time TEXT,
+					message_id INTEGER,
+					outcome TEXT NOT NULL,
+					error TEXT
+				)`,
+				`CREATE INDEX IF NOT EXISTS reminderbot_delivery_reminder_id_idx ON reminderbot_delivery (reminder_id, sent_time)`,
+				`CREATE INDEX IF NOT EXISTS reminderbot_delivery_chat_id_idx ON reminderbot_delivery (chat_id)`,
+			},
+		},
+	},
+	{
+		version:     4,
+		description: "create audit log table",
+		statements: map[string][]string{
+			utils.STORE_POSTGRES: {
+				`CREATE TABLE IF NOT EXISTS reminderbot_audit_log (
+					id UUID PRIMARY KEY,
+					chat_id BIGINT NOT NULL,
+					user_id BIGINT NOT NULL,
+					user_name VARCHAR(255),
+					action VARCHAR(255) NOT NULL,
+					entity_type VARCHAR(255) NOT NULL,
+					entity_id VARCHAR(255) NOT NULL,
+					before TEXT,
+					after TEXT,
+					created_at TIMESTAMP NOT NULL
+				)`,
+				`CREATE INDEX IF NOT EXISTS reminderbot_audit_log_chat_id_idx ON reminderbot_audit_log (chat_id, created_at)`,
+			},
+			utils.STORE_SQLITE: {
+				`CREATE TABLE IF NOT EXISTS reminderbot_audit_log (
+					id TEXT PRIMARY KEY,
+					chat_id INTEGER NOT NULL,
+					user_id INTEGER NOT NULL,
+					user_name TEXT,
+					action TEXT NOT NULL,
+					entity_type TEXT NOT NULL,
+					entity_id TEXT NOT NULL,
+					before TEXT,
+					after TEXT,
+					created_at TEXT NOT NULL
+				)`,
+				`CREATE INDEX IF NOT EXISTS reminderbot_audit_log_chat_id_idx ON reminderbot_audit_log (chat_id, created_at)`,
+			},
+		},
+	},
+	{
+		version:     5,
+		description: "add reminder soft delete column",
+		statements: map[string][]string{
+			utils.STORE_POSTGRES: {
+				`ALTER TABLE reminderbot_reminder ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP`,
+			},
+			utils.STORE_SQLITE: {
+				`ALTER TABLE reminderbot_reminder ADD COLUMN deleted_at TEXT`,
+			},
+		},
+	},
+	{
+		version:     6,
+		description: "widen reminder file id column for encrypted values",
+		statements: map[string][]string{
+			utils.STORE_POSTGRES: {
+				`ALTER TABLE reminderbot_reminder ALTER COLUMN file_id TYPE TEXT`,
+			},
+			// sqlite does not enforce column lengths
+			utils.STORE_SQLITE: {},
+		},
+	},
+	{
+		version:     7,
+		description: "add chat settings missed reminders column",
+		statements: map[string][]string{
+			utils.STORE_POSTGRES: {
+				`ALTER TABLE reminderbot_chat_settings ADD COLUMN IF NOT EXISTS missed_reminders VARCHAR(255) NOT NULL DEFAULT 'collapse'`,
+			},
+			utils.STORE_SQLITE: {
+				`ALTER TABLE reminderbot_chat_settings ADD COLUMN missed_reminders TEXT NOT NULL DEFAULT 'collapse'`,
+			},
+		},
+	},
+}
+
+// Migrate brings the database schema up to the latest version, applying every
+// pending migration in its own transaction. On postgres the whole run holds an
+// advisory lock, so instances starting together apply each migration once.
+func (store *SQLStore) Migrate(ctx context.Context) error {
+	// the advisory lock belongs to a session, so every statement goes through one connection
+	conn, err := store.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if store.dialect == utils.STORE_POSTGRES {
+		_, err = conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", utils.SQL_MIGRATION_LOCK_ID)
+		if err != nil {
+			return fmt.Errorf("error locking schema migrations: %v", err)
+		}
+		defer func() {
+			_, err := conn.ExecContext(context.WithoutCancel(ctx), "SELECT pg_advisory_unlock($1)", utils.SQL_MIGRATION_LOCK_ID)
+			if err != nil {
+				log.Error(err)
+			}
+		}()
+	}
+
+	_, err = conn.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS reminderbot_schema_migrations (
+		version INTEGER PRIMARY KEY,
+		description VARCHAR(255),
+		applied_at VARCHAR(255)
+	)`)
+	if err != nil {
+		return fmt.Errorf("error creating schema migrations table: %v", err)
+	}
+
+	var currentVersion sql.NullInt64
+	err = conn.QueryRowContext(ctx, "SELECT MAX(version) FROM reminderbot_schema_migrations").Scan(&currentVersion)
+	if err != nil {
+		return fmt.Errorf("error reading schema version: %v", err)
+	}
+
+	for _, migration := range sqlMigrations {
+		if int64(migration.version) <= currentVersion.Int64 {
+			continue
+		}
+		log.Infof("applying %v migration %v: %v", store.dialect, migration.version, migration.description)
+		tx, err := conn.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		for _, statement := range migration.statements[store.dialect] {
+			if _, err := tx.ExecContext(ctx, statement); err != nil {
+				tx.Rollback()
+				return fmt.Errorf("error applying migration %v: %v", migration.version, err)
+			}
+		}
+		_, err = tx.ExecContext(ctx,
+			store.rebind("INSERT INTO reminderbot_schema_migrations (version, description, applied_at) VALUES (?, ?, ?)"),
+			migration.version,
+			migration.description,
+			time.Now().UTC().Format(utils.DIRECTUS_DATETIME_FORMAT),
+		)
+		if err != nil {
+			tx.Rollback()
+			return fmt.Errorf("error recording migration %v: %v", migration.version, err)
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,638 @@
+package schemas
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Jason-CKY/telegram-reminderbot/pkg/utils"
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// SQLStore persists reminders and chat settings directly in a SQL database,
+// using the same reminderbot_reminder and reminderbot_chat_settings tables
+// that Directus manages.
+type SQLStore struct {
+	db      *sql.DB
+	dialect string
+}
+
+func NewPostgresStore(databaseUrl string) (*SQLStore, error) {
+	db, err := sql.Open("postgres", databaseUrl)
+	if err != nil {
+		return nil, err
+	}
+	return &SQLStore{
+		db:      db,
+		dialect: utils.STORE_POSTGRES,
+	}, nil
+}
+
+// NewSQLiteStore opens (or creates) the sqlite database file at path.
+func NewSQLiteStore(path string) (*SQLStore, error) {
+	db, err := sql.Open("sqlite3", fmt.Sprintf("file:%v?_busy_timeout=5000&_journal_mode=WAL", path))
+	if err != nil {
+		return nil, err
+	}
+	// sqlite only allows a single writer, serialise access instead of failing with SQLITE_BUSY
+	db.SetMaxOpenConns(1)
+	return &SQLStore{
+		db:      db,
+		dialect: utils.STORE_SQLITE,
+	}, nil
+}
+
+const reminderColumns = "id, chat_id, from_user_id, file_id, frequency, time, reminder_text, in_construction, next_trigger_time"
+
+// rebind rewrites ? placeholders into the positional form used by the dialect.
+func (store *SQLStore) rebind(query string) string {
+	if store.dialect != utils.STORE_POSTGRES {
+		return query
+	}
+	var builder strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			builder.WriteString(fmt.Sprintf("$%v", n))
+			continue
+		}
+		builder.WriteRune(r)
+	}
+	return builder.String()
+}
+
+func (store *SQLStore) exec(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	return store.db.ExecContext(ctx, store.rebind(query), args...)
+}
+
+// sqlDateTime scans a timestamp column that may come back from the driver as
+// a time.Time or as text, and exposes it in DIRECTUS_DATETIME_FORMAT.
+type sqlDateTime struct {
+	Value string
+}
+
+func (dateTime *sqlDateTime) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case nil:
+		dateTime.Value = ""
+	case time.Time:
+		dateTime.Value = v.UTC().Format(utils.DIRECTUS_DATETIME_FORMAT)
+	case string:
+		return dateTime.parse(v)
+	case []byte:
+		return dateTime.parse(string(v))
+	default:
+		return fmt.Errorf("unexpected type for datetime column: %T", v)
+	}
+	return nil
+}
+
+func (dateTime *sqlDateTime) parse(value string) error {
+	if value == "" {
+		dateTime.Value = ""
+		return nil
+	}
+	for _, layout := range []string{utils.DIRECTUS_DATETIME_FORMAT, time.RFC3339Nano, "2006-01-02 15:04:05"} {
+		t, err := time.Parse(layout, value)
+		if err == nil {
+			dateTime.Value = t.UTC().Format(utils.DIRECTUS_DATETIME_FORMAT)
+			return nil
+		}
+	}
+	return fmt.Errorf("unable to parse datetime column: %v", value)
+}
+
+func nullableDateTime(value string) interface{} {
+	if value == "" {
+		return nil
+	}
+	return value
+}
+
+func scanReminder(row interface{ Scan(...interface{}) error }) (Reminder, error) {
+	var reminder Reminder
+	var fileId, reminderTime, reminderText sql.NullString
+	var nextTriggerTime sqlDateTime
+	err := row.Scan(
+		&reminder.Id,
+		&reminder.ChatId,
+		&reminder.FromUserId,
+		&fileId,
+		&reminder.Frequency,
+		&reminderTime,
+		&reminderText,
+		&reminder.InConstruction,
+		&nextTriggerTime,
+	)
+	if err != nil {
+		return reminder, err
+	}
+	reminder.FileId = fileId.String
+	reminder.Time = reminderTime.String
+	reminder.ReminderText = reminderText.String
+	reminder.NextTriggerTime = nextTriggerTime.Value
+	return reminder, nil
+}
+
+func (store *SQLStore) queryReminders(ctx context.Context, query string, args ...interface{}) ([]Reminder, error) {
+	rows, err := store.db.QueryContext(ctx, store.rebind(query), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var reminders []Reminder
+	for rows.Next() {
+		reminder, err := scanReminder(rows)
+		if err != nil {
+			return nil, err
+		}
+		reminders = append(reminders, reminder)
+	}
+	return reminders, rows.Err()
+}
+
+func (store *SQLStore) queryReminder(ctx context.Context, query string, args ...interface{}) (*Reminder, error) {
+	reminders, err := store.queryReminders(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	if len(reminders) == 0 {
+		return nil, nil
+	}
+	return &reminders[0], nil
+}
+
+func (store *SQLStore) CreateReminder(ctx context.Context, reminder Reminder) error {
+	now := time.Now().UTC()
+	_, err := store.exec(ctx,
+		"INSERT INTO reminderbot_reminder ("+reminderColumns+", date_created, date_updated) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		reminder.Id,
+		reminder.ChatId,
+		reminder.FromUserId,
+		reminder.FileId,
+		reminder.Frequency,
+		reminder.Time,
+		reminder.ReminderText,
+		reminder.InConstruction,
+		nullableDateTime(reminder.NextTriggerTime),
+		now,
+		now,
+	)
+	if err != nil {
+		return fmt.Errorf("error inserting reminder: %v", err)
+	}
+	return nil
+}
+
+func (store *SQLStore) UpdateReminder(ctx context.Context, reminder Reminder) error {
+	_, err := store.exec(ctx,
+		`UPDATE reminderbot_reminder SET chat_id = ?, from_user_id = ?, file_id = ?, frequency = ?, time = ?,
+			reminder_text = ?, in_construction = ?, next_trigger_time = ?, lease_owner = NULL, lease_expires_at = NULL,
+			date_updated = ? WHERE id = ?`,
+		reminder.ChatId,
+		reminder.FromUserId,
+		reminder.FileId,
+		reminder.Frequency,
+		reminder.Time,
+		reminder.ReminderText,
+		reminder.InConstruction,
+		nullableDateTime(reminder.NextTriggerTime),
+		time.Now().UTC(),
+		reminder.Id,
+	)
+	if err != nil {
+		return fmt.Errorf("error updating reminder: %v", err)
+	}
+	return nil
+}
+
+func (store *SQLStore) DeleteReminder(ctx context.Context, id string) error {
+	_, err := store.exec(ctx, "DELETE FROM reminderbot_reminder WHERE id = ?", id)
+	if err != nil {
+		return fmt.Errorf("error deleting reminder: %v", err)
+	}
+	return nil
+}
+
+func (store *SQLStore) SoftDeleteReminder(ctx context.Context, id string, deletedAt time.Time) error {
+	_, err := store.exec(ctx,
+		"UPDATE reminderbot_reminder SET deleted_at = ?, lease_owner = NULL, lease_expires_at = NULL, date_updated = ? WHERE id = ?",
+		deletedAt.UTC().Format(utils.DIRECTUS_DATETIME_FORMAT), time.Now().UTC(), id,
+	)
+	if err != nil {
+		return fmt.Errorf("error soft deleting reminder: %v", err)
+	}
+	return nil
+}
+
+func (store *SQLStore) RestoreReminder(ctx context.Context, id string) (*Reminder, error) {
+	result, err := store.exec(ctx,
+		"UPDATE reminderbot_reminder SET deleted_at = NULL, date_updated = ? WHERE id = ? AND deleted_at IS NOT NULL",
+		time.Now().UTC(), id,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error restoring reminder: %v", err)
+	}
+	restored, err := result.RowsAffected()
+	if err != nil || restored == 0 {
+		return nil, err
+	}
+	return store.GetReminderById(ctx, id)
+}
+
+func (store *SQLStore) PurgeDeletedReminders(ctx context.Context, before time.Time) error {
+	_, err := store.exec(ctx, "DELETE FROM reminderbot_reminder WHERE deleted_at < ?", before.UTC().Format(utils.DIRECTUS_DATETIME_FORMAT))
+	if err != nil {
+		return fmt.Errorf("error purging deleted reminders: %v", err)
+	}
+	return nil
+}
+
+func (store *SQLStore) DeleteRemindersInConstruction(ctx context.Context, chatId int64, fromUserId int64) error {
+	_, err := store.exec(ctx,
+		"DELETE FROM reminderbot_reminder WHERE chat_id = ? AND from_user_id = ? AND in_construction = ?",
+		chatId, fromUserId, true,
+	)
+	if err != nil {
+		return fmt.Errorf("error deleting reminders in construction: %v", err)
+	}
+	return nil
+}
+
+func (store *SQLStore) GetReminderInConstruction(ctx context.Context, chatId int64, fromUserId int64) (*Reminder, error) {
+	return store.queryReminder(ctx,
+		"SELECT "+reminderColumns+" FROM reminderbot_reminder WHERE chat_id = ? AND from_user_id = ? AND in_construction = ? AND deleted_at IS NULL ORDER BY date_created",
+		chatId, fromUserId, true,
+	)
+}
+
+func (store *SQLStore) GetReminderById(ctx context.Context, id string) (*Reminder, error) {
+	return store.queryReminder(ctx, "SELECT "+reminderColumns+" FROM reminderbot_reminder WHERE id = ? AND deleted_at IS NULL", id)
+}
+
+func (store *SQLStore) GetRemindersByChatId(ctx context.Context, chatId int64) ([]Reminder, error) {
+	return store.queryReminders(ctx,
+		"SELECT "+reminderColumns+" FROM reminderbot_reminder WHERE chat_id = ? AND in_construction = ? AND deleted_at IS NULL",
+		chatId, false,
+	)
+}
+
+func (store *SQLStore) GetDueReminders(ctx context.Context, before time.Time, cursor string, limit int) ([]Reminder, error) {
+	if cursor == "" {
+		return store.queryReminders(ctx,
+			"SELECT "+reminderColumns+" FROM reminderbot_reminder WHERE in_construction = ? AND deleted_at IS NULL AND next_trigger_time < ? ORDER BY id LIMIT ?",
+			false, before.UTC().Format(utils.DIRECTUS_DATETIME_FORMAT), limit,
+		)
+	}
+	return store.queryReminders(ctx,
+		"SELECT "+reminderColumns+" FROM reminderbot_reminder WHERE in_construction = ? AND deleted_at IS NULL AND next_trigger_time < ? AND id > ? ORDER BY id LIMIT ?",
+		false, before.UTC().Format(utils.DIRECTUS_DATETIME_FORMAT), cursor, limit,
+	)
+}
+
+// ClaimDueReminders leases due reminders in a single UPDATE, so two instances can
+// never both claim the same reminder.
+func (store *SQLStore) ClaimDueReminders(ctx context.Context, owner string, before time.Time, leaseDuration time.Duration, limit int) ([]Reminder, error) {
+	now := time.Now().UTC()
+	lock := ""
+	if store.dialect == utils.STORE_POSTGRES {
+		// skip rows another instance is claiming right now instead of waiting for it
+		lock = " FOR UPDATE SKIP LOCKED"
+	}
+	reminders, err := store.queryReminders(ctx,
+		`UPDATE reminderbot_reminder SET lease_owner = ?, lease_expires_at = ? WHERE id IN (
+			SELECT id FROM reminderbot_reminder WHERE in_construction = ? AND deleted_at IS NULL AND next_trigger_time < ?
+			AND (lease_expires_at IS NULL OR lease_expires_at < ?) ORDER BY next_trigger_time, id LIMIT ?`+lock+`
+		) RETURNING `+reminderColumns,
+		owner,
+		now.Add(leaseDuration).Format(utils.DIRECTUS_DATETIME_FORMAT),
+		false,
+		before.UTC().Format(utils.DIRECTUS_DATETIME_FORMAT),
+		now.Format(utils.DIRECTUS_DATETIME_FORMAT),
+		limit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error claiming due reminders: %v", err)
+	}
+	return reminders, nil
+}
+
+func (store *SQLStore) ListChatReminders(ctx context.Context, chatId int64) ([]Reminder, error) {
+	return store.queryReminders(ctx,
+		"SELECT "+reminderColumns+" FROM reminderbot_reminder WHERE chat_id = ? AND in_construction = ? AND deleted_at IS NULL ORDER BY date_created, id",
+		chatId, false,
+	)
+}
+
+func (store *SQLStore) CreateChatSettings(ctx context.Context, chatSettings ChatSettings) error {
+	now := time.Now().UTC()
+	_, err := store.exec(ctx,
+		"INSERT INTO reminderbot_chat_settings (chat_id, timezone, updating, missed_reminders, date_created, date_updated) VALUES (?, ?, ?, ?, ?, ?)",
+		chatSettings.ChatId, chatSettings.Timezone, chatSettings.Updating, chatSettings.MissedReminderPolicy(), now, now,
+	)
+	if err != nil {
+		return fmt.Errorf("error inserting chat settings: %v", err)
+	}
+	return nil
+}
+
+func (store *SQLStore) UpdateChatSettings(ctx context.Context, chatSettings ChatSettings) error {
+	_, err := store.exec(ctx,
+		"UPDATE reminderbot_chat_settings SET timezone = ?, updating = ?, missed_reminders = ?, date_updated = ? WHERE chat_id = ?",
+		chatSettings.Timezone,
+		chatSettings.Updating,
+		chatSettings.MissedReminderPolicy(),
+		time.Now().UTC(),
+		chatSettings.ChatId,
+	)
+	if err != nil {
+		return fmt.Errorf("error updating chat settings: %v", err)
+	}
+	return nil
+}
+
+func (store *SQLStore) DeleteChatSettings(ctx context.Context, chatId int64) error {
+	_, err := store.exec(ctx, "DELETE FROM reminderbot_chat_settings WHERE chat_id = ?", chatId)
+	if err != nil {
+		return fmt.Errorf("error deleting chat settings: %v", err)
+	}
+	return nil
+}
+
+func (store *SQLStore) GetChatSettings(ctx context.Context, chatId int64) (*ChatSettings, error) {
+	row := store.db.QueryRowContext(ctx, store.rebind("SELECT chat_id, timezone, updating, missed_reminders FROM reminderbot_chat_settings WHERE chat_id = ?"), chatId)
+	var chatSettings ChatSettings
+	var timezone sql.NullString
+	var updating sql.NullBool
+	err := row.Scan(&chatSettings.ChatId, &timezone, &updating, &chatSettings.MissedReminders)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error getting chat settings: %v", err)
+	}
+	chatSettings.Timezone = timezone.String
+	chatSettings.Updating = updating.Bool
+	return &chatSettings, nil
+}
+
+// MigrateChat runs the whole migration in one transaction. Once it has
+// committed the old chat has no rows left, so running it again does nothing.
+func (store *SQLStore) MigrateChat(ctx context.Context, fromChatId int64, toChatId int64) error {
+	tx, err := store.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	statements := []struct {
+		query string
+		args  []interface{}
+	}{
+		{
+			`INSERT INTO reminderbot_chat_settings (chat_id, timezone, updating, missed_reminders, date_created, date_updated)
+				SELECT ?, timezone, updating, missed_reminders, date_created, ? FROM reminderbot_chat_settings WHERE chat_id = ?
+				ON CONFLICT (chat_id) DO UPDATE SET timezone = excluded.timezone, updating = excluded.updating, missed_reminders = excluded.missed_reminders, date_updated = excluded.date_updated`,
+			[]interface{}{toChatId, time.Now().UTC(), fromChatId},
+		},
+		{"UPDATE reminderbot_reminder SET chat_id = ? WHERE chat_id = ?", []interface{}{toChatId, fromChatId}},
+		{"UPDATE reminderbot_delivery SET chat_id = ? WHERE chat_id = ?", []interface{}{toChatId, fromChatId}},
+		{"UPDATE reminderbot_audit_log SET chat_id = ? WHERE chat_id = ?", []interface{}{toChatId, fromChatId}},
+		{"DELETE FROM reminderbot_chat_settings WHERE chat_id = ?", []interface{}{fromChatId}},
+	}
+	for _, statement := range statements {
+		if _, err := tx.ExecContext(ctx, store.rebind(statement.query), statement.args...); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("error migrating chat %v to %v: %v", fromChatId, toChatId, err)
+		}
+	}
+	return tx.Commit()
+}
+
+func (store *SQLStore) EraseUserData(ctx context.Context, userId int64) (ErasedUserData, error) {
+	var erased ErasedUserData
+	tx, err := store.db.BeginTx(ctx, nil)
+	if err != nil {
+		return erased, err
+	}
+	// the audit log refers to reminders by their id as text
+	userReminderIds := "SELECT CAST(id AS VARCHAR(255)) FROM reminderbot_reminder WHERE from_user_id = ?"
+	statements := []struct {
+		query string
+		args  []interface{}
+		count *int
+	}{
+		{
+			"DELETE FROM reminderbot_delivery WHERE reminder_id IN (SELECT id FROM reminderbot_reminder WHERE from_user_id = ?)",
+			[]interface{}{userId},
+			&erased.Deliveries,
+		},
+		{
+			"DELETE FROM reminderbot_audit_log WHERE user_id = ? OR chat_id = ? OR (entity_type = ? AND entity_id IN (" + userReminderIds + "))",
+			[]interface{}{userId, userId, utils.AUDIT_ENTITY_REMINDER, userId},
+			&erased.AuditEntries,
+		},
+		{"DELETE FROM reminderbot_reminder WHERE from_user_id = ?", []interface{}{userId}, &erased.Reminders},
+		{"DELETE FROM reminderbot_chat_settings WHERE chat_id = ?", []interface{}{userId}, &erased.ChatSettings},
+	}
+	for _, statement := range statements {
+		result, err := tx.ExecContext(ctx, store.rebind(statement.query), statement.args...)
+		if err != nil {
+			tx.Rollback()
+			return ErasedUserData{}, fmt.Errorf("error erasing data of user %v: %v", userId, err)
+		}
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			tx.Rollback()
+			return ErasedUserData{}, err
+		}
+		*statement.count = int(rowsAffected)
+	}
+	if err := tx.Commit(); err != nil {
+		return ErasedUserData{}, err
+	}
+	return erased, nil
+}
+
+// RewriteSensitiveFields reads each table in full before updating it, as
+// postgres does not allow other statements on a transaction while rows are open.
+func (store *SQLStore) RewriteSensitiveFields(ctx context.Context, rewrite func(string) (string, error)) (int, error) {
+	tx, err := store.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	rewritten := 0
+	for _, table := range []struct {
+		name    string
+		columns [2]string
+	}{
+		{"reminderbot_reminder", [2]string{"reminder_text", "file_id"}},
+		{"reminderbot_audit_log", [2]string{"before", "after"}},
+	} {
+		count, err := store.rewriteColumns(ctx, tx, table.name, table.columns, rewrite)
+		if err != nil {
+			tx.Rollback()
+			return 0, fmt.Errorf("error rewriting %v: %v", table.name, err)
+		}
+		rewritten += count
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return rewritten, nil
+}
+
+func (store *SQLStore) rewriteColumns(ctx context.Context, tx *sql.Tx, table string, columns [2]string, rewrite func(string) (string, error)) (int, error) {
+	type row struct {
+		id     string
+		values [2]string
+	}
+	rows, err := tx.QueryContext(ctx, fmt.Sprintf("SELECT CAST(id AS VARCHAR(255)), %v, %v FROM %v", columns[0], columns[1], table))
+	if err != nil {
+		return 0, err
+	}
+	var changedRows []row
+	for rows.Next() {
+		var id string
+		var first, second sql.NullString
+		if err := rows.Scan(&id, &first, &second); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		current := row{id: id, values: [2]string{first.String, second.String}}
+		changed, err := rewriteValues(rewrite, &current.values[0], &current.values[1])
+		if err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("row %v: %v", id, err)
+		}
+		if changed {
+			changedRows = append(changedRows, current)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	query := store.rebind(fmt.Sprintf("UPDATE %v SET %v = ?, %v = ? WHERE CAST(id AS VARCHAR(255)) = ?", table, columns[0], columns[1]))
+	for _, changedRow := range changedRows {
+		if _, err := tx.ExecContext(ctx, query, changedRow.values[0], changedRow.values[1], changedRow.id); err != nil {
+			return 0, err
+		}
+	}
+	return len(changedRows), nil
+}
+
+func (store *SQLStore) CreateDelivery(ctx context.Context, delivery Delivery) error {
+	_, err := store.exec(ctx,
+		"INSERT INTO reminderbot_delivery (id, reminder_id, chat_id, scheduled_time, sent_time, message_id, outcome, error) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+		delivery.Id,
+		delivery.ReminderId,
+		delivery.ChatId,
+		nullableDateTime(delivery.ScheduledTime),
+		nullableDateTime(delivery.SentTime),
+		delivery.MessageId,
+		delivery.Outcome,
+		delivery.Error,
+	)
+	if err != nil {
+		return fmt.Errorf("error inserting delivery: %v", err)
+	}
+	return nil
+}
+
+func (store *SQLStore) ListReminderDeliveries(ctx context.Context, reminderId string, limit int) ([]Delivery, error) {
+	rows, err := store.db.QueryContext(ctx,
+		store.rebind("SELECT id, reminder_id, chat_id, scheduled_time, sent_time, message_id, outcome, error FROM reminderbot_delivery WHERE reminder_id = ? ORDER BY sent_time DESC LIMIT ?"),
+		reminderId, limit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error listing deliveries: %v", err)
+	}
+	defer rows.Close()
+	var deliveries []Delivery
+	for rows.Next() {
+		var delivery Delivery
+		var scheduledTime, sentTime sqlDateTime
+		var messageId sql.NullInt64
+		var deliveryError sql.NullString
+		err := rows.Scan(
+			&delivery.Id,
+			&delivery.ReminderId,
+			&delivery.ChatId,
+			&scheduledTime,
+			&sentTime,
+			&messageId,
+			&delivery.Outcome,
+			&deliveryError,
+		)
+		if err != nil {
+			return nil, err
+		}
+		delivery.ScheduledTime = scheduledTime.Value
+		delivery.SentTime = sentTime.Value
+		delivery.MessageId = int(messageId.Int64)
+		delivery.Error = deliveryError.String
+		deliveries = append(deliveries, delivery)
+	}
+	return deliveries, rows.Err()
+}
+
+func (store *SQLStore) CreateAuditEntry(ctx context.Context, auditEntry AuditEntry) error {
+	_, err := store.exec(ctx,
+		"INSERT INTO reminderbot_audit_log (id, chat_id, user_id, user_name, action, entity_type, entity_id, before, after, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		auditEntry.Id,
+		auditEntry.ChatId,
+		auditEntry.UserId,
+		auditEntry.UserName,
+		auditEntry.Action,
+		auditEntry.EntityType,
+		auditEntry.EntityId,
+		auditEntry.Before,
+		auditEntry.After,
+		auditEntry.CreatedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("error inserting audit entry: %v", err)
+	}
+	return nil
+}
+
+func (store *SQLStore) ListChatAuditEntries(ctx context.Context, chatId int64, limit int) ([]AuditEntry, error) {
+	rows, err := store.db.QueryContext(ctx,
+		store.rebind("SELECT id, chat_id, user_id, user_name, action, entity_type, entity_id, before, after, created_at FROM reminderbot_audit_log WHERE chat_id = ? ORDER BY created_at DESC LIMIT ?"),
+		chatId, limit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error listing audit entries: %v", err)
+	}
+	defer rows.Close()
+	var auditEntries []AuditEntry
+	for rows.Next() {
+		var auditEntry AuditEntry
+		var userName, before, after sql.NullString
+		var createdAt sqlDateTime
+		err := rows.Scan(
+			&auditEntry.Id,
+			&auditEntry.ChatId,
+			&auditEntry.UserId,
+			&userName,
+			&auditEntry.Action,
+			&auditEntry.EntityType,
+			&auditEntry.EntityId,
+			&before,
+			&after,
+			&createdAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		auditEntry.UserName = userName.String
+		auditEntry.Before = before.String
+		auditEntry.After = after.String
+		auditEntry.CreatedAt = createdAt.Value
+		auditEntries = append(auditEntries, auditEntry)
+	}
+	return auditEntries, rows.Err()
+}
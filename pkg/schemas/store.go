@@ -0,0 +1,93 @@
+package schemas
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/Jason-CKY/telegram-reminderbot/pkg/utils"
+)
+
+// ReminderStore is the persistence layer used by the bot. Every read and write
+// of reminders and chat settings goes through the active Store, so the rest of
+// the bot does not need to know which backend is in use.
+type ReminderStore interface {
+	// Migrate creates or upgrades whatever schema the backend needs.
+	Migrate(ctx context.Context) error
+
+	CreateReminder(ctx context.Context, reminder Reminder) error
+	UpdateReminder(ctx context.Context, reminder Reminder) error
+	// DeleteReminder removes a reminder for good. Reminder.Delete soft deletes instead.
+	DeleteReminder(ctx context.Context, id string) error
+	// SoftDeleteReminder hides a reminder from every other query until it is
+	// restored, or purged once the retention window has passed.
+	SoftDeleteReminder(ctx context.Context, id string, deletedAt time.Time) error
+	// RestoreReminder undoes a soft delete and returns the restored reminder, or
+	// nil if the reminder is not soft deleted (anymore).
+	RestoreReminder(ctx context.Context, id string) (*Reminder, error)
+	// PurgeDeletedReminders removes reminders soft deleted before the given time.
+	PurgeDeletedReminders(ctx context.Context, before time.Time) error
+	DeleteRemindersInConstruction(ctx context.Context, chatId int64, fromUserId int64) error
+	GetReminderInConstruction(ctx context.Context, chatId int64, fromUserId int64) (*Reminder, error)
+	GetReminderById(ctx context.Context, id string) (*Reminder, error)
+	GetRemindersByChatId(ctx context.Context, chatId int64) ([]Reminder, error)
+	// GetDueReminders returns up to limit reminders due before the given time,
+	// ordered by id and starting after the cursor id ("" for the first page).
+	GetDueReminders(ctx context.Context, before time.Time, cursor string, limit int) ([]Reminder, error)
+	// ClaimDueReminders leases up to limit reminders due before the given time
+	// to owner, skipping reminders whose lease is held by another instance. The
+	// lease is released by UpdateReminder and DeleteReminder, or expires after
+	// leaseDuration so that another instance can take over from a crashed one.
+	ClaimDueReminders(ctx context.Context, owner string, before time.Time, leaseDuration time.Duration, limit int) ([]Reminder, error)
+	ListChatReminders(ctx context.Context, chatId int64) ([]Reminder, error)
+
+	CreateChatSettings(ctx context.Context, chatSettings ChatSettings) error
+	UpdateChatSettings(ctx context.Context, chatSettings ChatSettings) error
+	DeleteChatSettings(ctx context.Context, chatId int64) error
+	GetChatSettings(ctx context.Context, chatId int64) (*ChatSettings, error)
+
+	CreateDelivery(ctx context.Context, delivery Delivery) error
+	// ListReminderDeliveries returns up to limit deliveries of a reminder, newest first.
+	ListReminderDeliveries(ctx context.Context, reminderId string, limit int) ([]Delivery, error)
+
+	CreateAuditEntry(ctx context.Context, auditEntry AuditEntry) error
+	// ListChatAuditEntries returns up to limit audit entries of a chat, newest first.
+	ListChatAuditEntries(ctx context.Context, chatId int64, limit int) ([]AuditEntry, error)
+
+	// EraseUserData removes every reminder a user created in any chat, including
+	// soft deleted ones, with their deliveries and audit entries, the audit
+	// entries of changes the user made, and the user's private chat settings.
+	EraseUserData(ctx context.Context, userId int64) (ErasedUserData, error)
+
+	// RewriteSensitiveFields passes the stored text and file id of every
+	// reminder, soft deleted ones included, and the snapshots of every audit
+	// entry through rewrite, saving the values that change. It returns how many
+	// rows were changed.
+	RewriteSensitiveFields(ctx context.Context, rewrite func(string) (string, error)) (int, error)
+
+	// MigrateChat moves the settings, reminders, deliveries and audit entries of
+	// a chat to a new chat id, when a group is upgraded to a supergroup. Settings
+	// of the old chat win over any created for the new chat in the meantime. It
+	// must be safe to call again after a partial failure, and a no-op once the
+	// migration is complete.
+	MigrateChat(ctx context.Context, fromChatId int64, toChatId int64) error
+}
+
+// Store is the backend used by the package level helpers. It is set up in main
+// according to the --store flag.
+var Store ReminderStore
+
+func NewStore(backend string) (ReminderStore, error) {
+	switch backend {
+	case utils.STORE_DIRECTUS:
+		return NewDirectusStore(utils.DirectusHost, utils.DirectusToken), nil
+	case utils.STORE_MEMORY:
+		return NewMemoryStore(), nil
+	case utils.STORE_POSTGRES:
+		return NewPostgresStore(utils.DatabaseUrl)
+	case utils.STORE_SQLITE:
+		return NewSQLiteStore(utils.SQLitePath)
+	default:
+		return nil, fmt.Errorf("unknown store backend: %v", backend)
+	}
+}
@@ -0,0 +1,17 @@
+package schemas
+
+import "context"
+
+// ErasedUserData counts what EraseUserData removed.
+type ErasedUserData struct {
+	Reminders    int
+	Deliveries   int
+	AuditEntries int
+	ChatSettings int
+}
+
+// EraseUserData permanently removes the personal data the bot holds about a
+// Telegram user, see ReminderStore.EraseUserData.
+func EraseUserData(ctx context.Context, userId int64) (ErasedUserData, error) {
+	return Store.EraseUserData(ctx, userId)
+}
@@ -29,6 +29,18 @@ func LookupEnvOrInt(key string, defaultValue int) int {
 	return num
 }
 
+func LookupEnvOrBool(key string, defaultValue bool) bool {
+	envVariable, exists := os.LookupEnv(key)
+	if !exists {
+		return defaultValue
+	}
+	value, err := strconv.ParseBool(envVariable)
+	if err != nil {
+		panic(err.Error())
+	}
+	return value
+}
+
 func IsValidTime(time string) bool {
 	/*
 	   Use regex to match military time in <HH>:<MM>
@@ -67,9 +79,14 @@ func DaysInMonth(t time.Time) int {
 	return daysInMonth
 }
 
+// ParseReminderTime splits a <HH>:<MM> time into hours and minutes. Malformed input
+// yields 0 for the missing parts; check it with IsValidTime first.
 func ParseReminderTime(reminderTime string) (int, int) {
 	t := strings.Split(reminderTime, ":")
 	hour, _ := strconv.Atoi(t[0])
-	minute, _ := strconv.Atoi(t[1])
+	minute := 0
+	if len(t) > 1 {
+		minute, _ = strconv.Atoi(t[1])
+	}
 	return hour, minute
 }